@@ -4,59 +4,149 @@ import (
 	"bindxdb/pkg/config"
 	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
 	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+	"gopkg.in/yaml.v3"
 )
 
 func main() {
 	var (
 		configFile = flag.String("config", "config.yaml", "Configuration file")
-		command    = flag.String("cmd", "get", "Command: get, set, delete, list, watch, validate, reload")
+		command    = flag.String("cmd", "get", "Command: get, set, delete, list, watch, validate, reload, snapshot, restore, export, schema-validate, history, stats; see also 'configctl secrets set|get|delete|list'")
 		key        = flag.String("key", "", "Configuration key")
 		value      = flag.String("value", "", "Configuration value")
 		format     = flag.String("format", "yaml", "Output format (json, yaml)")
+		file       = flag.String("file", "", "File for snapshot, restore and export")
+		defaults   = flag.Bool("defaults", true, "Include default values in export")
+		secrets    = flag.Bool("show-secrets", false, "Include secret values in export")
+		schemaFile = flag.String("schema", "", "Schema file for schema-validate")
+		sourceName = flag.String("source", "", "Only reload the named source")
+		persist    = flag.Bool("persist", false, "Also write set values to the config file")
+		strict     = flag.Bool("strict", false, "Fail validation on warnings too")
+		rejectDups = flag.Bool("reject-duplicates", true, "Reject duplicate keys when validating")
+		probe      = flag.Bool("probe", false, "Check that database and graphite endpoints accept TCP connections when validating")
+		allowStat  = flag.Bool("allow-static", false, "Let set store keys that are not dynamic; they take effect after a restart")
+		prefix     = flag.String("prefix", "", "Only list keys at or below this prefix")
+		reveal     = flag.Bool("reveal", false, "Show secret values in list")
 	)
+	flagSource := config.NewFlagSourceFromFlagSet(flag.CommandLine, 100)
+	flag.VisitAll(func(f *flag.Flag) { flagSource.Skip(f.Name) })
 	flag.Parse()
 
+	if *command == "schema-validate" {
+		cmdSchemaValidate(*schemaFile, *configFile)
+		return
+	}
+
 	if err := config.InitConfig([]string{*configFile}); err != nil {
+		// Loading already validates, so an invalid file fails here.
+		if *command == "validate" && *format == "json" {
+			var multiErr *config.MultiError
+			if !errors.As(err, &multiErr) {
+				multiErr = &config.MultiError{Errors: []error{err}}
+			}
+			printOutput(multiErr, *format)
+			os.Exit(1)
+		}
 		fmt.Fprintf(os.Stderr, "failed to initialize config: %v\n", err)
+		os.Exit(1)
 	}
 
 	cfg := config.GetConfig()
 	ctx := context.Background()
 
+	if err := cfg.AddSource(flagSource); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to add flag source: %v\n", err)
+		os.Exit(1)
+	}
+	if err := cfg.Load(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load flags: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Secrets have their own subcommands: configctl secrets set -key KEY.
+	if flag.Arg(0) == "secrets" {
+		cmdSecrets(cfg, flag.Args()[1:])
+		return
+	}
+
 	switch *command {
 	case "get":
 		cmdGet(cfg, *key, *format)
 	case "set":
-		cmdSet(cfg, ctx, *key, *value, *format)
+		cmdSet(cfg, ctx, *key, *value, *format, *configFile, *persist, *allowStat)
 	case "delete":
 		cmdDelete(cfg, ctx, *key)
-	case "lsit":
-		cmdList(cfg, *format)
+	case "list":
+		listFormat := "table"
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "format" {
+				listFormat = *format
+			}
+		})
+		cmdList(cfg, *prefix, listFormat, *reveal)
 	case "watch":
 		cmdWatch(cfg, *key)
 	case "validate":
-		cmdValidate(cfg)
+		cmdValidate(cfg, *configFile, *format, *rejectDups, *strict, *probe)
+	case "reload":
+		cmdReload(cfg, ctx, *sourceName)
+	case "snapshot":
+		cmdSnapshot(cfg, *file)
+	case "restore":
+		cmdRestore(cfg, *file)
+	case "export":
+		cmdExport(cfg, *format, *file, *defaults, *secrets)
+	case "history":
+		cmdHistory(cfg, *key, *format)
+	case "stats":
+		cmdStats(cfg, *format)
 	default:
 		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", *command)
 		os.Exit(1)
 	}
 }
 
-func cmdSet(cfg *config.ConfigManager, ctx context.Context, key, value, format string) {
+func cmdSet(cfg *config.ConfigManager, ctx context.Context, key, value, format, configFile string, persist, allowStatic bool) {
 	var parsedValue interface{}
 
 	if err := json.Unmarshal([]byte(value), &parsedValue); err != nil {
 		parsedValue = value
 	}
 
-	if err := cfg.Set(key, parsedValue, config.SourceFlag, true); err != nil {
+	dynamic := config.NewDynamicConfigManager(cfg, config.WithAutoStart())
+	defer dynamic.Stop()
+	var opts []config.UpdateOption
+	if allowStatic {
+		opts = append(opts, config.AllowStatic())
+	}
+	response, err := dynamic.RequestUpdate(ctx, key, parsedValue, config.SourceFlag, opts...)
+	if errors.Is(err, config.ErrNotDynamic) {
+		fmt.Fprintf(os.Stderr, "%s is not a dynamic key and only takes effect after a restart; pass -allow-static to set it anyway\n", key)
+		os.Exit(1)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to set config: %v\n", err)
 		os.Exit(1)
 	}
+	if response.RestartRequired {
+		fmt.Printf("Config %s takes effect after a restart\n", key)
+	}
+
+	if persist {
+		if err := config.NewConfigWriter(cfg).SetInFile(configFile, key, parsedValue); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to persist config: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Config %s written to %s\n", key, configFile)
+	}
 
 	fmt.Printf("Config %s set successfully\n", key)
 }
@@ -76,8 +166,107 @@ func cmdGet(cfg *config.ConfigManager, key, format string) {
 func cmdDelete(cfg *config.ConfigManager, ctx context.Context, key string) {
 	fmt.Println("Delete not implemented yet")
 }
-func cmdList(cfg *config.ConfigManager, format string) {
-	fmt.Println("List not implemented yet")
+
+type listRow struct {
+	Key       string      `json:"key" yaml:"key"`
+	Value     interface{} `json:"value" yaml:"value"`
+	Source    string      `json:"source" yaml:"source"`
+	IsDefault bool        `json:"default" yaml:"default"`
+	IsSecret  bool        `json:"secret,omitempty" yaml:"secret,omitempty"`
+	Updated   *time.Time  `json:"updated,omitempty" yaml:"updated,omitempty"`
+}
+
+// cmdList prints every key under prefix as a table unless -format is
+// given. Secret values stay redacted unless reveal is set and the secret
+// store answers.
+func cmdList(cfg *config.ConfigManager, prefix, format string, reveal bool) {
+	infos := cfg.List(prefix)
+	if reveal && !secretStoreReachable(cfg) {
+		reveal = false
+	}
+
+	rows := make([]listRow, 0, len(infos))
+	for _, info := range infos {
+		row := listRow{
+			Key:       info.Key,
+			Value:     info.Value,
+			Source:    info.Source.String(),
+			IsDefault: info.IsDefault,
+			IsSecret:  info.IsSecret,
+		}
+		if !info.Timestamp.IsZero() {
+			updated := info.Timestamp
+			row.Updated = &updated
+		}
+		if info.IsSecret && reveal {
+			value, err := cfg.Get(info.Key)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to get secret %s: %v\n", info.Key, err)
+			} else {
+				row.Value = value
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	switch format {
+	case "json":
+		printOutput(rows, format)
+	case "yaml":
+		out, err := yaml.Marshal(rows)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to render list: %v\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE\tDEFAULT\tUPDATED")
+		for _, row := range rows {
+			updated := time.Time{}
+			if row.Updated != nil {
+				updated = *row.Updated
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\n", row.Key, formatListValue(row.Value),
+				row.Source, row.IsDefault, formatTime(updated))
+		}
+		w.Flush()
+	}
+}
+
+// secretStoreReachable reports whether -reveal can be honoured, warning
+// on stderr when it can't.
+func secretStoreReachable(cfg *config.ConfigManager) bool {
+	store := cfg.SecretStore()
+	if store == nil {
+		reason := cfg.SecretsDisabled()
+		if reason == nil {
+			reason = errors.New("none configured")
+		}
+		fmt.Fprintf(os.Stderr, "secret store unavailable (%v); secret values stay redacted\n", reason)
+		return false
+	}
+	if _, err := store.ListSecrets(); err != nil {
+		fmt.Fprintf(os.Stderr, "secret store unreachable (%v); secret values stay redacted\n", err)
+		return false
+	}
+	return true
+}
+
+// formatListValue prints strings and Stringers such as durations as they
+// are and anything else as JSON, so lists and maps stay on one line.
+func formatListValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case fmt.Stringer:
+		return v.String()
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
 }
 
 func cmdWatch(cfg *config.ConfigManager, key string) {
@@ -95,20 +284,191 @@ func cmdWatch(cfg *config.ConfigManager, key string) {
 
 }
 
-func cmdValidate(cfg *config.ConfigManager) {
-	if err := cfg.ValidateAll(); err != nil {
+func cmdValidate(cfg *config.ConfigManager, configFile, format string, rejectDuplicates, strict, probe bool) {
+	cfg.EnableConnectionProbes(probe)
+	var report config.ValidationReport
+	if rejectDuplicates {
+		if _, err := config.NewConfigLoader(config.WithStrictParsing()).LoadFile(configFile); err != nil {
+			report.Errors.Add(err)
+		}
+	}
+	if !report.Errors.HasErrors() {
+		report = cfg.Validate()
+	}
+	err := report.Err(strict)
+
+	if format == "json" {
+		printOutput(report, format)
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	for _, warning := range report.Warnings {
+		printWarning(warning)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Validation failed: %v\n", err)
 		os.Exit(1)
 	}
 	fmt.Println("Configuration is valid")
 }
 
-func cmdReload(cfg *config.ConfigManager, ctx context.Context) {
-	if err := cfg.Load(ctx); err != nil {
+// printWarning writes warning to stderr, in yellow on a terminal.
+func printWarning(warning config.ConfigWarning) {
+	line := fmt.Sprintf("Warning: %s", warning)
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		line = "\033[33m" + line + "\033[0m"
+	}
+	fmt.Fprintln(os.Stderr, line)
+}
+
+func cmdReload(cfg *config.ConfigManager, ctx context.Context, source string) {
+	var err error
+	if source == "" {
+		err = cfg.Load(ctx)
+	} else {
+		err = cfg.ReloadSource(ctx, source)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to reload config: %v\n", err)
 		os.Exit(1)
 	}
 	fmt.Println("Configuration reloaded")
+
+	for _, health := range cfg.SourceStatus() {
+		status := "ok"
+		if health.LastError != nil {
+			status = health.LastError.Error()
+		}
+		fmt.Printf("  %s (priority %d): %s in %v\n",
+			health.Name, health.Priority, status, health.LastDuration)
+	}
+}
+
+func cmdSnapshot(cfg *config.ConfigManager, file string) {
+	data, err := json.MarshalIndent(cfg.Snapshot(), "", " ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to encode snapshot: %v\n", err)
+		os.Exit(1)
+	}
+
+	if file == "" {
+		fmt.Println(string(data))
+		return
+	}
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write snapshot: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Snapshot written to %s\n", file)
+}
+
+func cmdRestore(cfg *config.ConfigManager, file string) {
+	if file == "" {
+		fmt.Fprintln(os.Stderr, "restore requires -file")
+		os.Exit(1)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read snapshot: %v\n", err)
+		os.Exit(1)
+	}
+
+	var snapshot config.ConfigSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to decode snapshot: %v\n", err)
+		os.Exit(1)
+	}
+	if err := cfg.Restore(snapshot); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to restore snapshot: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Configuration restored from %s\n", file)
+}
+
+func cmdExport(cfg *config.ConfigManager, format, file string, includeDefaults, showSecrets bool) {
+	data, err := cfg.Export(format, includeDefaults, !showSecrets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to export config: %v\n", err)
+		os.Exit(1)
+	}
+
+	if file == "" {
+		os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write export: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Configuration exported to %s\n", file)
+}
+
+func cmdHistory(cfg *config.ConfigManager, key, format string) {
+	if key == "" {
+		fmt.Fprintln(os.Stderr, "history requires -key")
+		os.Exit(1)
+	}
+
+	history := cfg.GetHistory(key)
+	if format == "json" {
+		printOutput(history, format)
+		return
+	}
+	if len(history) == 0 {
+		fmt.Printf("No recorded changes for %s\n", key)
+		return
+	}
+	for _, change := range history {
+		fmt.Printf("%s %s: %v -> %v (from %s)\n",
+			change.Timestamp.Format(time.RFC3339), change.Key,
+			change.OldValue, change.NewValue, change.Source)
+	}
+}
+
+func cmdStats(cfg *config.ConfigManager, format string) {
+	stats := cfg.Stats()
+	if format == "json" {
+		printOutput(stats.Metrics(), format)
+		return
+	}
+
+	fmt.Printf("Keys: %d (%d default, %d secret, %d dynamic)\n",
+		stats.Keys, stats.DefaultKeys, stats.SecretKeys, stats.DynamicKeys)
+	for source, count := range stats.KeysBySource {
+		fmt.Printf("  %s: %d\n", source, count)
+	}
+	fmt.Printf("Loads: %d (%d validation failures)\n", stats.Loads, stats.ValidationFailures)
+	if !stats.LastLoad.IsZero() {
+		fmt.Printf("Last successful load: %s\n", stats.LastLoad.Format(time.RFC3339))
+	}
+	if stats.LastLoadError != nil {
+		fmt.Printf("Last load error: %v\n", stats.LastLoadError)
+	}
+	for _, source := range stats.Sources {
+		fmt.Printf("Source %s: %d loads, %d failures\n", source.Name, source.Loads, source.Failures)
+	}
+	fmt.Printf("Watchers: %d, subscribers: %d\n", stats.Watchers, stats.Subscribers)
+	fmt.Printf("Dropped changes: %d, dropped deliveries: %d (policy %s)\n",
+		stats.DroppedChanges, stats.DroppedDeliveries, stats.OverflowPolicy)
+}
+
+func cmdSchemaValidate(schemaFile, configFile string) {
+	if schemaFile == "" {
+		fmt.Fprintln(os.Stderr, "schema-validate requires -schema")
+		os.Exit(1)
+	}
+	schema, err := config.LoadSchemaFromFile(schemaFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid schema: %v\n", err)
+		os.Exit(1)
+	}
+	if err := config.ValidateConfigFile(schema, configFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Validation failed: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s is valid against %s\n", configFile, schemaFile)
 }
 
 func printOutput(data interface{}, format string) {
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bindxdb/pkg/config"
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// cmdSecrets runs `configctl secrets set|get|delete|list`. Values are only
+// read from stdin, never from arguments, so they stay out of shell history.
+// Setting a secret only writes it to the store; for the running service to
+// read the key from there it must be declared secret in the schema.
+func cmdSecrets(cfg *config.ConfigManager, args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: configctl secrets set|get|delete|list [-key KEY] [-rotate-every DURATION] [-expires TIME]")
+		fmt.Fprintln(os.Stderr, "keys set here are only read from the secret store if the schema declares them secret")
+		os.Exit(1)
+	}
+	action := args[0]
+
+	fs := flag.NewFlagSet("secrets "+action, flag.ExitOnError)
+	key := fs.String("key", "", "Secret key")
+	reveal := fs.Bool("reveal", false, "Show secret values in list")
+	format := fs.String("format", "yaml", "Output format (json, yaml)")
+	rotateEvery := fs.Duration("rotate-every", 0, "Rotation period of the secret (set)")
+	expires := fs.String("expires", "", "Expiry of the secret as RFC 3339 (set)")
+	fs.Parse(args[1:])
+	if *key == "" && fs.NArg() > 0 {
+		*key = fs.Arg(0)
+	}
+
+	store := cfg.SecretStore()
+	if store == nil {
+		fmt.Fprintf(os.Stderr, "secret store unavailable: %v\n", cfg.SecretsDisabled())
+		os.Exit(1)
+	}
+
+	if action != "list" && *key == "" {
+		fmt.Fprintf(os.Stderr, "secrets %s requires -key\n", action)
+		os.Exit(1)
+	}
+
+	switch action {
+	case "set":
+		value, err := readSecretValue(*key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read secret value: %v\n", err)
+			os.Exit(1)
+		}
+		if err := store.SetSecret(*key, value); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to set secret: %v\n", err)
+			os.Exit(1)
+		}
+		if *rotateEvery != 0 || *expires != "" {
+			if err := setSecretMetadata(store, *key, *rotateEvery, *expires); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to set secret metadata: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		fmt.Printf("Secret %s set successfully\n", *key)
+	case "get":
+		value, err := store.GetSecret(*key)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to get secret: %v\n", err)
+			os.Exit(1)
+		}
+		printOutput(map[string]interface{}{*key: value}, *format)
+	case "delete":
+		if err := store.DeleteSecret(*key); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to delete secret: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Secret %s deleted\n", *key)
+	case "list":
+		keys, err := store.ListSecrets()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to list secrets: %v\n", err)
+			os.Exit(1)
+		}
+		rows := make([]secretRow, 0, len(keys))
+		for _, name := range keys {
+			row := secretRow{Key: name, Value: config.RedactedValue}
+			if *reveal {
+				value, err := store.GetSecret(name)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to get secret %s: %v\n", name, err)
+					os.Exit(1)
+				}
+				row.Value = value
+			}
+			if metaStore, ok := store.(config.SecretStoreWithMetadata); ok {
+				if meta, err := metaStore.GetSecretMetadata(name); err == nil {
+					row.Meta = meta
+				}
+			}
+			rows = append(rows, row)
+		}
+		printSecretRows(rows, *format)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown secrets command: %s\n", action)
+		os.Exit(1)
+	}
+}
+
+// readSecretValue prompts for the value without echo when stdin is a
+// terminal, and otherwise reads all of stdin minus the trailing newline.
+func readSecretValue(key string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprintf(os.Stderr, "Value for %s: ", key)
+		value, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		return string(value), nil
+	}
+
+	data, err := io.ReadAll(bufio.NewReader(os.Stdin))
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("no value on stdin")
+	}
+	return value, nil
+}
+
+func setSecretMetadata(store config.SecretStore, key string, rotateEvery time.Duration, expires string) error {
+	metaStore, ok := store.(config.SecretStoreWithMetadata)
+	if !ok {
+		return fmt.Errorf("secret store does not keep metadata")
+	}
+	meta, err := metaStore.GetSecretMetadata(key)
+	if err != nil {
+		return err
+	}
+	if rotateEvery != 0 {
+		meta.RotationPeriod = rotateEvery
+	}
+	if expires != "" {
+		if meta.Expires, err = time.Parse(time.RFC3339, expires); err != nil {
+			return fmt.Errorf("invalid -expires: %w", err)
+		}
+	}
+	return metaStore.SetSecretMetadata(key, meta)
+}
+
+type secretRow struct {
+	Key   string
+	Value string
+	Meta  config.SecretMetadata
+}
+
+func printSecretRows(rows []secretRow, format string) {
+	if format == "json" {
+		output := make(map[string]interface{}, len(rows))
+		for _, row := range rows {
+			entry := map[string]interface{}{"value": row.Value}
+			for name, t := range map[string]time.Time{
+				"created":      row.Meta.Created,
+				"updated":      row.Meta.Updated,
+				"rotation_due": row.Meta.RotationDue(),
+				"expires":      row.Meta.Expires,
+			} {
+				if !t.IsZero() {
+					entry[name] = t
+				}
+			}
+			output[row.Key] = entry
+		}
+		printOutput(output, format)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tCREATED\tUPDATED\tROTATION DUE\tEXPIRES")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", row.Key, row.Value,
+			formatTime(row.Meta.Created), formatTime(row.Meta.Updated),
+			formatTime(row.Meta.RotationDue()), formatTime(row.Meta.Expires))
+	}
+	w.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// The OrDefault getters return def when key is missing, has the wrong type
+// or its secret cannot be resolved. They never return an error.
+
+func (m *ConfigManager) GetStringOrDefault(key, def string) string {
+	value, err := m.GetString(key)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+func (m *ConfigManager) GetIntOrDefault(key string, def int) int {
+	value, err := m.GetInt(key)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+func (m *ConfigManager) GetBoolOrDefault(key string, def bool) bool {
+	value, err := m.GetBool(key)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+func (m *ConfigManager) GetDurationOrDefault(key string, def time.Duration) time.Duration {
+	value, err := m.GetDuration(key)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// MustGetString is for values the process cannot start without; it panics
+// if key is missing or not a string.
+func (m *ConfigManager) MustGetString(key string) string {
+	value, err := m.GetString(key)
+	if err != nil {
+		panic(fmt.Sprintf("config: required string %q: %v", key, err))
+	}
+	return value
+}
+
+func (m *ConfigManager) MustGetInt(key string) int {
+	value, err := m.GetInt(key)
+	if err != nil {
+		panic(fmt.Sprintf("config: required int %q: %v", key, err))
+	}
+	return value
+}
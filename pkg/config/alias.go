@@ -0,0 +1,86 @@
+package config
+
+import "fmt"
+
+type keyAlias struct {
+	newKey     string
+	deprecated bool
+}
+
+// RegisterAlias makes oldKey an alternative name for newKey. Get and Set on
+// oldKey operate on newKey, and sources that still supply oldKey are loaded
+// into newKey. Deprecated aliases are logged once when first seen and are
+// reported by ValidateAll when strict deprecation checking is enabled.
+func (m *ConfigManager) RegisterAlias(oldKey, newKey string, deprecated bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.normalizeKeys {
+		oldKey, newKey = normalizeKey(oldKey), normalizeKey(newKey)
+	}
+	if oldKey == newKey {
+		return fmt.Errorf("alias %s cannot point to itself", oldKey)
+	}
+	if target, exists := m.aliases[newKey]; exists {
+		return fmt.Errorf("alias target %s is itself an alias for %s", newKey, target.newKey)
+	}
+	m.aliases[oldKey] = keyAlias{newKey: newKey, deprecated: deprecated}
+	return nil
+}
+
+func (m *ConfigManager) EnableStrictDeprecations(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.strictDeprecations = enabled
+}
+
+// canonicalKey returns the key a lookup of key should use: normalized when
+// key normalization is enabled, then with any alias resolved.
+func (m *ConfigManager) canonicalKey(key string) string {
+	if m.normalizeKeys {
+		key = normalizeKey(key)
+	}
+	if alias, exists := m.aliases[key]; exists {
+		return alias.newKey
+	}
+	return key
+}
+
+// applyAliases moves values supplied under an alias onto their canonical key.
+// If a source supplies both names the canonical key wins.
+func (m *ConfigManager) applyAliases(flat map[string]interface{}, sourceName string) {
+	for oldKey, alias := range m.aliases {
+		value, exists := flat[oldKey]
+		if !exists {
+			continue
+		}
+		delete(flat, oldKey)
+
+		if alias.deprecated {
+			m.deprecatedSeen[oldKey] = alias.newKey
+			if !m.deprecationWarned[oldKey] {
+				m.deprecationWarned[oldKey] = true
+				m.logger.Warn("deprecated config key", "key", oldKey,
+					"replacement", alias.newKey, "source", sourceName)
+			}
+		}
+
+		if _, exists := flat[alias.newKey]; exists {
+			continue
+		}
+		flat[alias.newKey] = value
+	}
+}
+
+func (m *ConfigManager) validateDeprecations(multiErr *MultiError) {
+	if !m.strictDeprecations {
+		return
+	}
+	for oldKey, newKey := range m.deprecatedSeen {
+		multiErr.Add(&ConfigError{
+			Key:     oldKey,
+			Message: fmt.Sprintf("key is deprecated, use %s instead", newKey),
+			Code:    CodeDeprecated,
+		})
+	}
+}
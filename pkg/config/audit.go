@@ -0,0 +1,301 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// SecretAuditEvent records one access to a secret. It never carries the
+// secret's value.
+type SecretAuditEvent struct {
+	Time time.Time `json:"time"`
+	// Store is the backend that served the access, or "manager" for the
+	// resolution of a config key.
+	Store string `json:"store"`
+	// Op is get, set or delete, or resolve for a config key read through
+	// the manager.
+	Op     string `json:"op"`
+	Key    string `json:"key"`
+	Caller string `json:"caller,omitempty"`
+	// Secret is the secret a config key resolved to, when it differs from
+	// Key.
+	Secret  string `json:"secret,omitempty"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// AuditSink receives SecretAuditEvents. Record is called synchronously on
+// every access, so it should not block for long.
+type AuditSink interface {
+	Record(event SecretAuditEvent)
+}
+
+// ContextSecretStore is implemented by secret stores that take a context,
+// so the caller set with WithAuditCaller reaches their audit events.
+type ContextSecretStore interface {
+	GetSecretContext(ctx context.Context, key string) (string, error)
+	SetSecretContext(ctx context.Context, key string, value string) error
+	DeleteSecretContext(ctx context.Context, key string) error
+}
+
+type auditSetter interface {
+	SetAuditSink(sink AuditSink)
+}
+
+type auditCallerKey struct{}
+
+// WithAuditCaller names the component making secret accesses with ctx in
+// audit events.
+func WithAuditCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, auditCallerKey{}, caller)
+}
+
+func AuditCaller(ctx context.Context) string {
+	caller, _ := ctx.Value(auditCallerKey{}).(string)
+	return caller
+}
+
+// WithAuditSink records secret resolution by the manager in sink and hands
+// sink to the secret store when it supports auditing.
+func WithAuditSink(sink AuditSink) ManagerOption {
+	return func(m *ConfigManager) {
+		m.audit = sink
+		if setter, ok := m.secretStore.(auditSetter); ok {
+			setter.SetAuditSink(sink)
+		}
+	}
+}
+
+func recordAudit(ctx context.Context, sink AuditSink, store, op, key string, err error) {
+	if sink == nil {
+		return
+	}
+	event := SecretAuditEvent{
+		Time:    time.Now(),
+		Store:   store,
+		Op:      op,
+		Key:     key,
+		Caller:  AuditCaller(ctx),
+		Success: err == nil,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	sink.Record(event)
+}
+
+// readSecret reads key from store with ctx when the store accepts one.
+func readSecret(ctx context.Context, store SecretStore, key string) (string, error) {
+	if contextStore, ok := store.(ContextSecretStore); ok {
+		return contextStore.GetSecretContext(ctx, key)
+	}
+	return store.GetSecret(key)
+}
+
+func (m *ConfigManager) auditResolve(ctx context.Context, key, secret string, err error) {
+	if m.audit == nil {
+		return
+	}
+	event := SecretAuditEvent{
+		Time:    time.Now(),
+		Store:   "manager",
+		Op:      "resolve",
+		Key:     key,
+		Caller:  AuditCaller(ctx),
+		Success: err == nil,
+	}
+	if secret != key {
+		event.Secret = secret
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	m.audit.Record(event)
+}
+
+// JSONLinesAuditSink appends each event to a file as one JSON object per
+// line.
+type JSONLinesAuditSink struct {
+	file   *os.File
+	mu     sync.Mutex
+	logger Logger
+}
+
+func NewJSONLinesAuditSink(path string, logger Logger) (*JSONLinesAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &JSONLinesAuditSink{file: file, logger: logger}, nil
+}
+
+func (s *JSONLinesAuditSink) Record(event SecretAuditEvent) {
+	data, err := json.Marshal(event)
+	if err == nil {
+		s.mu.Lock()
+		_, err = s.file.Write(append(data, '\n'))
+		s.mu.Unlock()
+	}
+	if err != nil && s.logger != nil {
+		s.logger.Error("failed to write audit event", "key", event.Key, "error", err)
+	}
+}
+
+func (s *JSONLinesAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+func (s *FileSecretStore) SetAuditSink(sink AuditSink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = sink
+}
+
+func (s *FileSecretStore) auditSink() AuditSink {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.audit
+}
+
+func (s *FileSecretStore) GetSecret(key string) (string, error) {
+	return s.GetSecretContext(context.Background(), key)
+}
+
+func (s *FileSecretStore) GetSecretContext(ctx context.Context, key string) (string, error) {
+	value, err := s.getSecret(key)
+	recordAudit(ctx, s.auditSink(), "file", "get", key, err)
+	return value, err
+}
+
+func (s *FileSecretStore) SetSecret(key string, value string) error {
+	return s.SetSecretContext(context.Background(), key, value)
+}
+
+func (s *FileSecretStore) SetSecretContext(ctx context.Context, key string, value string) error {
+	err := s.setSecret(key, value)
+	recordAudit(ctx, s.auditSink(), "file", "set", key, err)
+	return err
+}
+
+func (s *FileSecretStore) DeleteSecret(key string) error {
+	return s.DeleteSecretContext(context.Background(), key)
+}
+
+func (s *FileSecretStore) DeleteSecretContext(ctx context.Context, key string) error {
+	err := s.deleteSecret(key)
+	recordAudit(ctx, s.auditSink(), "file", "delete", key, err)
+	return err
+}
+
+func (s *VaultSecretStore) SetAuditSink(sink AuditSink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.audit = sink
+}
+
+func (s *VaultSecretStore) auditSink() AuditSink {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.audit
+}
+
+func (s *VaultSecretStore) GetSecret(key string) (string, error) {
+	return s.GetSecretContext(context.Background(), key)
+}
+
+func (s *VaultSecretStore) GetSecretContext(ctx context.Context, key string) (string, error) {
+	value, err := s.getSecret(key)
+	recordAudit(ctx, s.auditSink(), "vault", "get", key, err)
+	return value, err
+}
+
+func (s *VaultSecretStore) SetSecret(key string, value string) error {
+	return s.SetSecretContext(context.Background(), key, value)
+}
+
+func (s *VaultSecretStore) SetSecretContext(ctx context.Context, key string, value string) error {
+	err := s.setSecret(key, value)
+	recordAudit(ctx, s.auditSink(), "vault", "set", key, err)
+	return err
+}
+
+func (s *VaultSecretStore) DeleteSecret(key string) error {
+	return s.DeleteSecretContext(context.Background(), key)
+}
+
+func (s *VaultSecretStore) DeleteSecretContext(ctx context.Context, key string) error {
+	err := s.deleteSecret(key)
+	recordAudit(ctx, s.auditSink(), "vault", "delete", key, err)
+	return err
+}
+
+func (s *ChainSecretStore) GetSecretContext(ctx context.Context, key string) (string, error) {
+	var multiErr MultiError
+	for _, store := range s.stores {
+		var value string
+		var err error
+		if ctxStore, ok := store.(ContextSecretStore); ok {
+			value, err = ctxStore.GetSecretContext(ctx, key)
+		} else {
+			value, err = store.GetSecret(key)
+		}
+		if err == nil {
+			return value, nil
+		}
+		multiErr.Add(err)
+	}
+	if !multiErr.HasErrors() {
+		return "", fmt.Errorf("secret %s not found", key)
+	}
+	return "", fmt.Errorf("secret %s not found in any store: %w", key, &multiErr)
+}
+
+func (s *ChainSecretStore) SetSecretContext(ctx context.Context, key string, value string) error {
+	for _, store := range s.stores {
+		var err error
+		if ctxStore, ok := store.(ContextSecretStore); ok {
+			err = ctxStore.SetSecretContext(ctx, key, value)
+		} else {
+			err = store.SetSecret(key, value)
+		}
+		if errors.Is(err, ErrReadOnlyStore) {
+			continue
+		}
+		return err
+	}
+	return fmt.Errorf("cannot set secret %s: %w", key, ErrReadOnlyStore)
+}
+
+func (s *ChainSecretStore) DeleteSecretContext(ctx context.Context, key string) error {
+	var multiErr MultiError
+	wrote := false
+	for _, store := range s.stores {
+		var err error
+		if ctxStore, ok := store.(ContextSecretStore); ok {
+			err = ctxStore.DeleteSecretContext(ctx, key)
+		} else {
+			err = store.DeleteSecret(key)
+		}
+		if errors.Is(err, ErrReadOnlyStore) {
+			continue
+		}
+		wrote = true
+		if err != nil {
+			multiErr.Add(err)
+		}
+	}
+	if !wrote {
+		return fmt.Errorf("cannot delete secret %s: %w", key, ErrReadOnlyStore)
+	}
+	if multiErr.HasErrors() {
+		return &multiErr
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	smtypes "github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+type AWSSecretMode string
+
+const (
+	AWSSecretsManager AWSSecretMode = "secretsmanager"
+	AWSParameterStore AWSSecretMode = "ssm"
+)
+
+const (
+	awsRequestTimeout  = 10 * time.Second
+	awsSecretCacheTime = 5 * time.Minute
+)
+
+// AWSOptions configures an AWSSecretStore. Region and credentials come from
+// the standard AWS chain unless Region is set; Endpoint overrides the
+// service endpoint, for example for LocalStack.
+type AWSOptions struct {
+	Mode     AWSSecretMode
+	Region   string
+	Prefix   string
+	Endpoint string
+}
+
+// AWSSecretStore keeps secrets in AWS Secrets Manager or, in
+// AWSParameterStore mode, as SecureString parameters in SSM Parameter Store.
+// Every key is stored under Prefix, and ListSecrets only returns names
+// below it.
+type AWSSecretStore struct {
+	mode   AWSSecretMode
+	prefix string
+	sm     *secretsmanager.Client
+	ssm    *ssm.Client
+	cache  map[string]cachedSecret
+	mu     sync.RWMutex
+	logger Logger
+}
+
+func NewAWSSecretStore(opts AWSOptions, logger Logger) (*AWSSecretStore, error) {
+	mode := opts.Mode
+	if mode == "" {
+		mode = AWSSecretsManager
+	}
+	if mode != AWSSecretsManager && mode != AWSParameterStore {
+		return nil, fmt.Errorf("unknown AWS secret mode %q", mode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), awsRequestTimeout)
+	defer cancel()
+
+	var loadOpts []func(*awsconfig.LoadOptions) error
+	if opts.Region != "" {
+		loadOpts = append(loadOpts, awsconfig.WithRegion(opts.Region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	if opts.Endpoint != "" {
+		cfg.BaseEndpoint = aws.String(opts.Endpoint)
+	}
+
+	store := &AWSSecretStore{
+		mode:   mode,
+		prefix: opts.Prefix,
+		cache:  make(map[string]cachedSecret),
+		logger: logger,
+	}
+	if mode == AWSSecretsManager {
+		store.sm = secretsmanager.NewFromConfig(cfg)
+	} else {
+		store.ssm = ssm.NewFromConfig(cfg)
+	}
+	return store, nil
+}
+
+func (s *AWSSecretStore) GetSecret(key string) (string, error) {
+	s.mu.RLock()
+	cached, exists := s.cache[key]
+	s.mu.RUnlock()
+	if exists && cached.expiresAt.After(time.Now()) {
+		return cached.value, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), awsRequestTimeout)
+	defer cancel()
+
+	name := s.prefix + key
+	var value string
+	if s.mode == AWSSecretsManager {
+		out, err := s.sm.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(name),
+		})
+		if err != nil {
+			return "", s.readError(key, err)
+		}
+		if out.SecretString == nil {
+			return "", fmt.Errorf("secret %s has no string value", key)
+		}
+		value = *out.SecretString
+	} else {
+		out, err := s.ssm.GetParameter(ctx, &ssm.GetParameterInput{
+			Name:           aws.String(name),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return "", s.readError(key, err)
+		}
+		value = aws.ToString(out.Parameter.Value)
+	}
+
+	s.mu.Lock()
+	s.cache[key] = cachedSecret{
+		value:     value,
+		expiresAt: time.Now().Add(awsSecretCacheTime),
+	}
+	s.mu.Unlock()
+	return value, nil
+}
+
+func (s *AWSSecretStore) SetSecret(key string, value string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), awsRequestTimeout)
+	defer cancel()
+
+	name := s.prefix + key
+	if s.mode == AWSSecretsManager {
+		_, err := s.sm.PutSecretValue(ctx, &secretsmanager.PutSecretValueInput{
+			SecretId:     aws.String(name),
+			SecretString: aws.String(value),
+		})
+		var notFound *smtypes.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			_, err = s.sm.CreateSecret(ctx, &secretsmanager.CreateSecretInput{
+				Name:         aws.String(name),
+				SecretString: aws.String(value),
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write to Secrets Manager: %w", err)
+		}
+	} else {
+		_, err := s.ssm.PutParameter(ctx, &ssm.PutParameterInput{
+			Name:      aws.String(name),
+			Value:     aws.String(value),
+			Type:      ssmtypes.ParameterTypeSecureString,
+			Overwrite: aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write to Parameter Store: %w", err)
+		}
+	}
+
+	s.mu.Lock()
+	s.cache[key] = cachedSecret{
+		value:     value,
+		expiresAt: time.Now().Add(awsSecretCacheTime),
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// DeleteSecret removes key. Secrets Manager keeps deleted secrets for its
+// default recovery window, during which the name can't be reused.
+func (s *AWSSecretStore) DeleteSecret(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), awsRequestTimeout)
+	defer cancel()
+
+	name := s.prefix + key
+	var err error
+	if s.mode == AWSSecretsManager {
+		_, err = s.sm.DeleteSecret(ctx, &secretsmanager.DeleteSecretInput{
+			SecretId: aws.String(name),
+		})
+	} else {
+		_, err = s.ssm.DeleteParameter(ctx, &ssm.DeleteParameterInput{
+			Name: aws.String(name),
+		})
+	}
+	if err != nil && !isAWSNotFound(err) {
+		return fmt.Errorf("failed to delete secret %s: %w", key, err)
+	}
+
+	s.mu.Lock()
+	delete(s.cache, key)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *AWSSecretStore) ListSecrets() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), awsRequestTimeout)
+	defer cancel()
+
+	var names []string
+	if s.mode == AWSSecretsManager {
+		input := &secretsmanager.ListSecretsInput{}
+		if s.prefix != "" {
+			input.Filters = []smtypes.Filter{{
+				Key:    smtypes.FilterNameStringTypeName,
+				Values: []string{s.prefix},
+			}}
+		}
+		pages := secretsmanager.NewListSecretsPaginator(s.sm, input)
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list secrets from Secrets Manager: %w", err)
+			}
+			for _, entry := range page.SecretList {
+				names = append(names, aws.ToString(entry.Name))
+			}
+		}
+	} else {
+		input := &ssm.DescribeParametersInput{}
+		if s.prefix != "" {
+			input.ParameterFilters = []ssmtypes.ParameterStringFilter{{
+				Key:    aws.String("Name"),
+				Option: aws.String("BeginsWith"),
+				Values: []string{s.prefix},
+			}}
+		}
+		pages := ssm.NewDescribeParametersPaginator(s.ssm, input)
+		for pages.HasMorePages() {
+			page, err := pages.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list parameters from Parameter Store: %w", err)
+			}
+			for _, param := range page.Parameters {
+				if param.Type == ssmtypes.ParameterTypeSecureString {
+					names = append(names, aws.ToString(param.Name))
+				}
+			}
+		}
+	}
+
+	// The Secrets Manager name filter matches words anywhere in the name, so
+	// the prefix is checked again here.
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		if strings.HasPrefix(name, s.prefix) {
+			keys = append(keys, strings.TrimPrefix(name, s.prefix))
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (s *AWSSecretStore) readError(key string, err error) error {
+	if isAWSNotFound(err) {
+		return fmt.Errorf("secret %s not found", key)
+	}
+	if s.mode == AWSSecretsManager {
+		return fmt.Errorf("failed to read from Secrets Manager: %w", err)
+	}
+	return fmt.Errorf("failed to read from Parameter Store: %w", err)
+}
+
+func isAWSNotFound(err error) bool {
+	var smNotFound *smtypes.ResourceNotFoundException
+	var ssmNotFound *ssmtypes.ParameterNotFound
+	return errors.As(err, &smNotFound) || errors.As(err, &ssmNotFound)
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// SetBatch applies every change in one step. All keys are checked for
+// precedence and validated first; if any of them fails nothing is applied
+// and the returned MultiError lists each offending key. Values of secret
+// keys are written to the secret store before anything is applied, and a
+// failed write is returned the same way. Watchers receive
+// the batch as a single ConfigChangeSet.
+func (m *ConfigManager) SetBatch(changes map[string]interface{}, source ConfigSource) error {
+	m.mu.Lock()
+
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+
+	keys := make([]string, 0, len(changes))
+	resolved := make(map[string]interface{}, len(changes))
+	for key, value := range changes {
+		canonical := m.canonicalKey(key)
+		keys = append(keys, canonical)
+		resolved[canonical] = value
+	}
+	sort.Strings(keys)
+
+	var multiErr MultiError
+	for _, key := range keys {
+		value := resolved[key]
+		secret := m.isSecretKey(key)
+		multiErr.Add(m.checkPrecedence(key, source, false))
+		for _, validator := range m.validators[key] {
+			if err := validator.Validate(key, value); err != nil {
+				if _, ok := asWarning(err); ok {
+					continue
+				}
+				validationErr := validatorError(key, validator, err)
+				if secret {
+					multiErr.Add(secretValidationError(key, validationErr))
+					continue
+				}
+				multiErr.Add(validationErr)
+			}
+		}
+		if m.schema != nil {
+			if err := m.validateAgainstSchema(key, value); err != nil {
+				if secret {
+					err = secretValidationError(key, err)
+				}
+				multiErr.Add(err)
+			}
+		}
+	}
+	if multiErr.HasErrors() {
+		m.mu.Unlock()
+		return &multiErr
+	}
+
+	type secretWrite struct {
+		store      SecretStore
+		key, value string
+	}
+	var writes []secretWrite
+	for _, key := range keys {
+		if store, value, ok := m.secretWrite(key, resolved[key]); ok {
+			writes = append(writes, secretWrite{store: store, key: key, value: value})
+		}
+	}
+	if len(writes) > 0 {
+		m.mu.Unlock()
+		for _, write := range writes {
+			multiErr.Add(writeSecret(write.store, write.key, write.value))
+		}
+		if multiErr.HasErrors() {
+			return &multiErr
+		}
+
+		// The manager may have changed while the secrets were written.
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return ErrClosed
+		}
+		for _, key := range keys {
+			multiErr.Add(m.checkPrecedence(key, source, false))
+		}
+		if multiErr.HasErrors() {
+			m.mu.Unlock()
+			return &multiErr
+		}
+	}
+
+	m.nextBatchID++
+	set := ConfigChangeSet{
+		BatchID:   fmt.Sprintf("batch-%d", m.nextBatchID),
+		Source:    source,
+		Timestamp: time.Now(),
+	}
+	for _, key := range keys {
+		change := m.store(key, resolved[key], source, sourcePriority(source))
+		change.BatchID = set.BatchID
+		set.Changes = append(set.Changes, change)
+	}
+	m.mu.Unlock()
+
+	m.notifyChangeSet(set)
+	return nil
+}
+
+func (m *ConfigManager) notifyChangeSet(set ConfigChangeSet) {
+	m.mu.RLock()
+	if m.closed {
+		m.mu.RUnlock()
+		return
+	}
+	for i, change := range set.Changes {
+		if m.isSecretKey(change.Key) {
+			set.Changes[i] = redactChange(change)
+		}
+	}
+
+	// Each registration is notified once, either with the whole set or with
+	// the changes to the keys it watches.
+	perWatcher := make(map[*watcherRegistration][]ConfigChange)
+	var order []*watcherRegistration
+	for _, change := range set.Changes {
+		for _, reg := range m.watchersFor(change.Key) {
+			if _, seen := perWatcher[reg]; !seen {
+				order = append(order, reg)
+			}
+			perWatcher[reg] = append(perWatcher[reg], change)
+		}
+	}
+
+	for _, reg := range order {
+		go func(reg *watcherRegistration, changes []ConfigChange) {
+			if !reg.active.Load() {
+				return
+			}
+			if batchWatcher, ok := reg.watcher.(ConfigChangeSetWatcher); ok {
+				batchWatcher.OnConfigChangeSet(set)
+				return
+			}
+			for _, change := range changes {
+				reg.watcher.OnConfigChange(change)
+			}
+		}(reg, perWatcher[reg])
+	}
+	m.mu.RUnlock()
+
+	for _, change := range set.Changes {
+		m.publish(change)
+	}
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+type funcWatcher func(change ConfigChange)
+
+func (f funcWatcher) OnConfigChange(change ConfigChange) {
+	f(change)
+}
+
+// OnKeyChange calls fn whenever key changes. A key ending in ".*" matches
+// every key below that prefix. The returned function unsubscribes fn.
+func (m *ConfigManager) OnKeyChange(key string, fn func(old, new interface{})) func() {
+	id := m.AddWatcher(key, funcWatcher(func(change ConfigChange) {
+		fn(change.OldValue, change.NewValue)
+	}))
+	return func() {
+		m.RemoveWatcher(id)
+	}
+}
+
+func (m *ConfigManager) OnIntChange(key string, fn func(old, new int)) func() {
+	return onTypedChange(m, key, func(key string, value interface{}) (int, error) {
+		i, err := toInt64(key, value)
+		return int(i), err
+	}, fn)
+}
+
+func (m *ConfigManager) OnDurationChange(key string, fn func(old, new time.Duration)) func() {
+	return onTypedChange(m, key, toDuration, fn)
+}
+
+func (m *ConfigManager) OnStringChange(key string, fn func(old, new string)) func() {
+	return onTypedChange(m, key, func(key string, value interface{}) (string, error) {
+		str, ok := value.(string)
+		if !ok {
+			return "", &ConfigError{Key: key, Message: "value is not a string"}
+		}
+		return str, nil
+	}, fn)
+}
+
+func (m *ConfigManager) OnBoolChange(key string, fn func(old, new bool)) func() {
+	return onTypedChange(m, key, func(key string, value interface{}) (bool, error) {
+		b, ok := value.(bool)
+		if !ok {
+			return false, &ConfigError{Key: key, Message: "value is not a bool"}
+		}
+		return b, nil
+	}, fn)
+}
+
+// onTypedChange converts both sides of a change before calling fn. A new
+// value that fails to convert is logged and skipped; an old value that
+// fails (or was unset) is passed as the zero value.
+func onTypedChange[T any](m *ConfigManager, key string,
+	convert func(string, interface{}) (T, error), fn func(old, new T)) func() {
+	id := m.AddWatcher(key, funcWatcher(func(change ConfigChange) {
+		newValue, err := convert(change.Key, change.NewValue)
+		if err != nil {
+			m.logger.Warn("ignoring change with unexpected type", "key", change.Key, "error", err)
+			return
+		}
+		var oldValue T
+		if change.OldValue != nil {
+			if converted, err := convert(change.Key, change.OldValue); err == nil {
+				oldValue = converted
+			}
+		}
+		fn(oldValue, newValue)
+	}))
+	return func() {
+		m.RemoveWatcher(id)
+	}
+}
+
+// watchersFor returns the registrations for key itself and for every
+// "prefix.*" pattern that covers it. The caller must hold m.mu.
+func (m *ConfigManager) watchersFor(key string) []*watcherRegistration {
+	regs := append([]*watcherRegistration(nil), m.watchers[key]...)
+	parts := strings.Split(key, ".")
+	for i := 1; i < len(parts); i++ {
+		regs = append(regs, m.watchers[strings.Join(parts[:i], ".")+".*"]...)
+	}
+	return regs
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ChaCha20Encryption encrypts with XChaCha20-Poly1305, which is faster
+// than AES-GCM on CPUs without AES instructions. Its extended nonce makes
+// random nonces safe for any number of secrets.
+type ChaCha20Encryption struct {
+	key []byte
+}
+
+// NewChaCha20Encryption accepts the same keys as NewAESEncryption.
+func NewChaCha20Encryption(key []byte) (*ChaCha20Encryption, error) {
+	key, err := deriveKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ChaCha20Encryption{key: key}, nil
+}
+
+func (e *ChaCha20Encryption) Encrypt(plaintext []byte) ([]byte, error) {
+	return e.EncryptWithContext(plaintext, nil)
+}
+
+func (e *ChaCha20Encryption) Decrypt(ciphertext []byte) ([]byte, error) {
+	return e.DecryptWithContext(ciphertext, nil)
+}
+
+func (e *ChaCha20Encryption) EncryptWithContext(plaintext, aad []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(e.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return sealAEAD(aead, plaintext, aad)
+}
+
+func (e *ChaCha20Encryption) DecryptWithContext(ciphertext, aad []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(e.key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return openAEAD(aead, ciphertext, aad)
+}
+
+// KeyID includes the algorithm, so the same key used with AES-GCM and
+// ChaCha20 gets two IDs.
+func (e *ChaCha20Encryption) KeyID() string {
+	sum := sha256.Sum256(append([]byte("bindxdb-key-id:chacha20:"), e.key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (e *ChaCha20Encryption) Algorithm() SecretAlgorithm {
+	return AlgorithmXChaCha20Poly1305
+}
@@ -2,8 +2,13 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"time"
 )
@@ -13,7 +18,7 @@ type DatabaseConfig struct {
 	Port           int           `json:"port"`
 	Name           string        `json:"name"`
 	User           string        `json:"user"`
-	Password       string        `json:"-"`
+	Password       string        `json:"-" config:"password"`
 	MaxConnections int           `json:"max_connections"`
 	IdleTimeout    time.Duration `json:"idle_timeout"`
 	SSLMode        string        `json:"ssl_mode"`
@@ -117,90 +122,135 @@ type AppConfig struct {
 
 var (
 	globalManager *ConfigManager
-	globalOnce    sync.Once
+	globalMu      sync.Mutex
 )
 
+// InitConfig builds the global manager. A failed attempt leaves no global
+// manager behind, so a later call retries from scratch.
 func InitConfig(configPaths []string) error {
-	var err error
-	globalOnce.Do(func() {
-		logger := &DefaultLogger{}
+	globalMu.Lock()
+	defer globalMu.Unlock()
 
-		secretStore, err := createSecretStore()
+	if globalManager != nil {
+		return nil
+	}
+
+	// The secret store has to exist before any config is read, so it is
+	// first built from the environment and rebuilt below if the loaded
+	// config selects a different backend.
+	logger := &DefaultLogger{}
+	bootstrap := SecretBackendConfigFromEnv()
+	secretStore, secretsErr := createSecretStore(bootstrap, logger)
+	if secretsErr != nil && !errors.Is(secretsErr, ErrSecretsDisabled) {
+		return fmt.Errorf("failed to create secret store: %w", secretsErr)
+	}
 
-		if err != nil {
-			return
+	manager := NewConfigManager(logger, secretStore)
+	if secretsErr != nil {
+		manager.disableSecrets(secretsErr)
+	}
+	manager.loader.RegisterFormat(&EnvFileFormat{Prefix: "BINDXDB_"})
+
+	// .env files sit between config files and the real environment, so an
+	// exported variable still wins over the same one in .env.
+	var filePaths, envPaths []string
+	for _, path := range configPaths {
+		if strings.EqualFold(filepath.Ext(path), ".env") {
+			envPaths = append(envPaths, path)
+		} else {
+			filePaths = append(filePaths, path)
 		}
+	}
 
-		manager := NewConfigManager(logger, secretStore)
+	fileSource := NewFileSourceWithLoader(filePaths, 50, manager.loader)
+	if err := manager.AddSource(fileSource); err != nil {
+		manager.Close()
+		return fmt.Errorf("failed to add file source: %w", err)
+	}
 
-		fileSource := NewFileSource(configPaths, 50)
-		if err := manager.AddSource(fileSource); err != nil {
-			return
+	if len(envPaths) > 0 {
+		envFileSource := NewFileSourceWithLoader(envPaths, 60, manager.loader)
+		if err := manager.AddSource(envFileSource); err != nil {
+			manager.Close()
+			return fmt.Errorf("failed to add .env file source: %w", err)
 		}
+	}
 
-		envSource := NewEnvironmentSource("BINDXDB_", 75)
-		if err := manager.AddSource(envSource); err != nil {
-			return
-		}
+	envSource := NewEnvironmentSource("BINDXDB_", 75)
+	for _, name := range secretBackendEnvVars {
+		envSource.IgnorePrefix(name)
+	}
+	if prefix, ok := bootstrap.envSecretPrefix(); ok {
+		envSource.IgnorePrefix(prefix)
+	}
+	if err := manager.AddSource(envSource); err != nil {
+		manager.Close()
+		return fmt.Errorf("failed to add environment source: %w", err)
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-		setDefault(manager)
-		addValidators(manager)
+	setDefault(manager)
+	addValidators(manager)
 
-		if err := manager.Load(ctx); err != nil {
-			return
+	if err := manager.Load(ctx); err != nil {
+		manager.Close()
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if backendConfig := SecretBackendConfigFromManager(manager, bootstrap); !reflect.DeepEqual(backendConfig, bootstrap) {
+		store, err := createSecretStore(backendConfig, logger)
+		switch {
+		case errors.Is(err, ErrSecretsDisabled):
+			manager.disableSecrets(err)
+		case err != nil:
+			manager.Close()
+			return fmt.Errorf("failed to create secret store: %w", err)
+		default:
+			manager.SetSecretStore(store)
 		}
+		if closer, ok := secretStore.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+	if err := manager.StartWatching(context.Background()); err != nil {
+		manager.Close()
+		return fmt.Errorf("failed to watch configuration sources: %w", err)
+	}
+
+	globalManager = manager
+	return nil
+}
 
-		globalManager = manager
+// ShutdownConfig closes the global manager and clears it, so GetConfig
+// returns nil until InitConfig builds a new one.
+func ShutdownConfig() error {
+	globalMu.Lock()
+	defer globalMu.Unlock()
 
-	})
+	if globalManager == nil {
+		return nil
+	}
+	err := globalManager.Close()
+	globalManager = nil
 	return err
 }
 
 func GetConfig() *ConfigManager {
+	globalMu.Lock()
+	defer globalMu.Unlock()
 	return globalManager
 }
 
 func GetAppConfig() (*AppConfig, error) {
-	if globalManager == nil {
+	manager := GetConfig()
+	if manager == nil {
 		return nil, fmt.Errorf("configuration not initialized")
 	}
 	var appConfig AppConfig
-	appConfig.Database.Host, _ = globalManager.GetString("database.host")
-	appConfig.Database.Port, _ = globalManager.GetInt("database.port")
-	appConfig.Database.Name, _ = globalManager.GetString("database.name")
-	appConfig.Database.User, _ = globalManager.GetString("database.user")
-	appConfig.Database.Password, _ = globalManager.GetString("database.password")
-	appConfig.Database.MaxConnections, _ = globalManager.GetInt("database.max_connections")
-	appConfig.Database.IdleTimeout, _ = globalManager.GetDuration("database.idle_timeout")
-	appConfig.Database.SSLMode, _ = globalManager.GetString("database.ssl_mode")
-
-	appConfig.Storage.Engine, _ = globalManager.GetString("storage.engine")
-	appConfig.Storage.DataDir, _ = globalManager.GetString("storage.engine")
-	appConfig.Storage.WALDir, _ = globalManager.GetString("storage.wal_dir")
-	appConfig.Storage.PageSize, _ = globalManager.GetInt("storage.page_size")
-	appConfig.Storage.SyncWrites, _ = globalManager.GetBool("storage.sync_writes")
-	appConfig.Storage.Compression, _ = globalManager.GetString("storage.compression")
-
-	appConfig.Server.HTTP.Enabled, _ = globalManager.GetBool("server.http.enabled")
-	appConfig.Server.HTTP.Port, _ = globalManager.GetInt("server.http.port")
-	appConfig.Server.HTTP.TLS.Enabled, _ = globalManager.GetBool("server.http.tls.enabled")
-	appConfig.Server.HTTP.TLS.CertFile, _ = globalManager.GetString("server.http.tls.cert_file")
-	appConfig.Server.HTTP.TLS.KeyFile, _ = globalManager.GetString("server.http.tls.key_file")
-
-	appConfig.Logging.Level, _ = globalManager.GetString("logging.level")
-	appConfig.Logging.Format, _ = globalManager.GetString("logging.format")
-	appConfig.Logging.Output, _ = globalManager.GetString("logging.output")
-	appConfig.Logging.File, _ = globalManager.GetString("logging.file")
-	appConfig.Logging.MaxBackups, _ = globalManager.GetInt("logging.max_backups")
-	appConfig.Logging.MaxAge, _ = globalManager.GetDuration("logging.max_age")
-
-	appConfig.Plugins.Directory, _ = globalManager.GetString("plugins.directory")
-	appConfig.Plugins.AutoLoad, _ = globalManager.GetBool("plugins.auto_load")
-	appConfig.Plugins.Enabled, _ = globalManager.GetStringSlice("plugins.enabled")
-
+	if err := manager.Unmarshal("", &appConfig); err != nil {
+		return nil, fmt.Errorf("failed to decode app config: %w", err)
+	}
 	return &appConfig, nil
 }
 
@@ -221,7 +271,7 @@ func setDefault(manager *ConfigManager) {
 	manager.SetDefault("logging.format", "json")
 	manager.SetDefault("logging.output", "stdout")
 	manager.SetDefault("logging.max_backups", 10)
-	manager.SetDefault("logging.max_age", "30d")
+	manager.SetDefault("logging.max_age", "720h")
 
 	manager.SetDefault("metrics.enabled", true)
 	manager.SetDefault("metrics.prometheus.enabled", true)
@@ -237,6 +287,7 @@ func addValidators(manager *ConfigManager) {
 	manager.AddValidator("database.port", portValidator)
 	manager.AddValidator("server.http.port", portValidator)
 	manager.AddValidator("server.grpc.port", portValidator)
+	manager.AddCrossKeyValidator(NewUniquePortValidator("server.http.port", "server.grpc.port"))
 
 	requiredValidator := &RequiredValidator{}
 	manager.AddValidator("database.name", requiredValidator)
@@ -245,47 +296,77 @@ func addValidators(manager *ConfigManager) {
 	fileValidator := &FileValidator{MustExist: true, MustBeDir: true}
 	manager.AddValidator("storage.data_dir", fileValidator)
 
+	sizeValidator := &SizeValidator{}
+	manager.AddValidator("storage.cache_size", &SizeValidator{WarnBelow: 64 << 20})
+	manager.AddValidator("logging.max_size", sizeValidator)
+
 	durationValidator := &DurationValidator{Min: 1 * time.Second}
 	manager.AddValidator("database.idle_timeout", durationValidator)
 
-	if hostnameValidator, err := NewPatternValidator(`^[a-zA-Z0-9\.\-]+$`); err != nil {
-		manager.AddValidator("database.host", hostnameValidator)
-	}
+	manager.AddValidator("logging.level", &EnumValidator{
+		Allowed:         []interface{}{"debug", "info", "warn", "error", "fatal"},
+		CaseInsensitive: true,
+	})
+	manager.AddValidator("logging.format", &EnumValidator{
+		Allowed:         []interface{}{"json", "text"},
+		CaseInsensitive: true,
+	})
+	manager.AddValidator("storage.engine", &EnumValidator{
+		Allowed:         []interface{}{"btree", "lsm", "memory"},
+		CaseInsensitive: true,
+	})
+	manager.AddValidator("storage.compression", &EnumValidator{
+		Allowed:         []interface{}{"none", "snappy", "lz4", "zstd", "gzip"},
+		CaseInsensitive: true,
+	})
 
-}
+	manager.AddCrossValidator(&ConnectionValidator{
+		Key:     "database.host",
+		Forms:   ConnectionHost,
+		PortKey: "database.port",
+	})
+	manager.AddCrossValidator(&ConnectionValidator{
+		Key:        "metrics.graphite.host",
+		Forms:      ConnectionHost,
+		PortKey:    "metrics.graphite.port",
+		EnabledKey: "metrics.graphite.enabled",
+	})
+	manager.AddCrossValidator(&TLSValidator{Prefix: "server.http.tls"})
+	manager.AddCrossValidator(&TLSValidator{Prefix: "server.grpc.tls"})
+	manager.AddCrossValidator(NewRequiredWhenEnabledValidator("metrics.graphite.enabled",
+		"metrics.graphite.host", "metrics.graphite.port"))
+	manager.AddCrossValidator(NewDistinctPathsValidator("storage.data_dir", "storage.wal_dir"))
 
-func createSecretStore() (SecretStore, error) {
-	encKey := os.Getenv("BINDXDB_ENCRYPTION_KEY")
-	if encKey == "" {
-		encKey = ""
-	}
-	encryption, err := NewAESEncryption([]byte(encKey))
-	if err != nil {
-		return nil, err
-	}
+}
 
-	secretDir := os.Getenv("BINDXDB_SECRET_DIR")
-	if secretDir == "" {
-		secretDir = "/etc/bindxdb/secrets"
+// createSecretStore builds the configured store. Without an encryption key
+// the file backend would encrypt with a publicly known key, so instead
+// secrets are disabled: the store is nil and the error wraps
+// ErrSecretsDisabled.
+func createSecretStore(cfg SecretBackendConfig, logger Logger) (SecretStore, error) {
+	store, err := NewSecretStore(cfg, logger)
+	if errors.Is(err, ErrNoEncryptionKey) {
+		logger.Warn("SECRETS DISABLED: no encryption key is configured, set BINDXDB_ENCRYPTION_KEY_FILE or BINDXDB_ENCRYPTION_KEY to enable the secret store")
+		return nil, fmt.Errorf("%w: %v", ErrSecretsDisabled, err)
 	}
-
-	return NewFileSecretStore(secretDir, encryption, &DefaultLogger{})
+	return store, err
 }
 
+// DefaultLogger writes to stderr, keeping stdout for command output.
 type DefaultLogger struct{}
 
 func (l *DefaultLogger) Debug(msg string, args ...interface{}) {
-	fmt.Printf("[DEBUG] "+msg+"\n", args...)
+	fmt.Fprintf(os.Stderr, "[DEBUG] "+msg+"\n", args...)
 }
 
 func (l *DefaultLogger) Info(msg string, args ...interface{}) {
-	fmt.Printf("[INFO] "+msg+"\n", args...)
+	fmt.Fprintf(os.Stderr, "[INFO] "+msg+"\n", args...)
 }
 
 func (l *DefaultLogger) Warn(msg string, args ...interface{}) {
-	fmt.Printf("[WARN] "+msg+"\n", args...)
+	fmt.Fprintf(os.Stderr, "[WARN] "+msg+"\n", args...)
 }
 
 func (l *DefaultLogger) Error(msg string, args ...interface{}) {
-	fmt.Printf("[ERROR] "+msg+"\n", args...)
+	fmt.Fprintf(os.Stderr, "[ERROR] "+msg+"\n", args...)
 }
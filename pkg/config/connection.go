@@ -0,0 +1,224 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ConnectionForm is a set of the ways an endpoint may be written.
+type ConnectionForm int
+
+const (
+	// ConnectionHost is a bare hostname or IP address.
+	ConnectionHost ConnectionForm = 1 << iota
+	// ConnectionHostPort is host:port, with IPv6 literals in brackets.
+	ConnectionHostPort
+	// ConnectionURL is scheme://host[:port][/path].
+	ConnectionURL
+)
+
+// defaultProbeTimeout bounds each dial when ConnectionValidator.Timeout is
+// zero.
+const defaultProbeTimeout = 2 * time.Second
+
+// ConnectionValidator checks the endpoint in Key. It catches the common
+// mistakes of pasting a whole DSN where only the host belongs and of
+// leaving the scheme off a URL. With Probe set it also dials the endpoint
+// over TCP, reporting a name that doesn't resolve separately from a
+// connection that is refused or times out.
+type ConnectionValidator struct {
+	Key string
+	// Forms lists the accepted forms; zero accepts all of them.
+	Forms ConnectionForm
+	// Schemes restricts the scheme of URLs; empty allows any.
+	Schemes []string
+	// PortKey holds the port to probe when Key doesn't include one.
+	PortKey string
+	// EnabledKey, if set, skips the check unless that key is true.
+	EnabledKey string
+	Probe      bool
+	Timeout    time.Duration
+}
+
+// connectionEndpoint is a parsed ConnectionValidator value.
+type connectionEndpoint struct {
+	host   string
+	port   int
+	scheme string
+}
+
+func (v *ConnectionValidator) Validate(get func(key string) (interface{}, error)) error {
+	if v.EnabledKey != "" && !isEnabled(get, v.EnabledKey) {
+		return nil
+	}
+	if isBlank(get, v.Key) {
+		return nil
+	}
+	value, _ := get(v.Key)
+	str, ok := value.(string)
+	if !ok {
+		return &ConfigError{Key: v.Key, Message: "expected endpoint string", Code: CodeTypeMismatch}
+	}
+	endpoint, err := v.parse(strings.TrimSpace(str))
+	if err != nil {
+		return &ConfigError{Key: v.Key, Message: err.Error(), Code: CodeInvalidFormat}
+	}
+	if !v.Probe {
+		return nil
+	}
+	return v.probe(get, endpoint)
+}
+
+func (v *ConnectionValidator) ValidatedKeys() []string {
+	keys := []string{v.Key}
+	for _, key := range []string{v.PortKey, v.EnabledKey} {
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (v *ConnectionValidator) allows(form ConnectionForm) bool {
+	return v.Forms == 0 || v.Forms&form != 0
+}
+
+func (v *ConnectionValidator) parse(str string) (connectionEndpoint, error) {
+	if strings.Contains(str, "://") {
+		if !v.allows(ConnectionURL) {
+			return connectionEndpoint{}, fmt.Errorf("%q is a URL or DSN; %s", str, v.hostOnlyHint())
+		}
+		u, err := url.Parse(str)
+		if err != nil {
+			return connectionEndpoint{}, fmt.Errorf("invalid URL %q: %v", str, err)
+		}
+		if u.Hostname() == "" {
+			return connectionEndpoint{}, fmt.Errorf("URL %q has no host", str)
+		}
+		if len(v.Schemes) > 0 && !slices.Contains(v.Schemes, u.Scheme) {
+			return connectionEndpoint{}, fmt.Errorf("URL scheme %q is not one of %v", u.Scheme, v.Schemes)
+		}
+		endpoint := connectionEndpoint{host: u.Hostname(), scheme: u.Scheme}
+		if port := u.Port(); port != "" {
+			if endpoint.port, err = parseEndpointPort(port); err != nil {
+				return connectionEndpoint{}, err
+			}
+		}
+		return endpoint, checkHost(endpoint.host)
+	}
+
+	if strings.ContainsAny(str, "@/?") {
+		if v.allows(ConnectionURL) {
+			return connectionEndpoint{}, fmt.Errorf("%q looks like a URL without a scheme", str)
+		}
+		return connectionEndpoint{}, fmt.Errorf("%q looks like a connection string; %s", str, v.hostOnlyHint())
+	}
+
+	if host, port, err := net.SplitHostPort(str); err == nil {
+		if !v.allows(ConnectionHostPort) {
+			if v.PortKey != "" {
+				return connectionEndpoint{}, fmt.Errorf("%q includes a port; set the port in %s", str, v.PortKey)
+			}
+			return connectionEndpoint{}, fmt.Errorf("%q must not include a port", str)
+		}
+		endpoint := connectionEndpoint{host: host}
+		if endpoint.port, err = parseEndpointPort(port); err != nil {
+			return connectionEndpoint{}, err
+		}
+		return endpoint, checkHost(host)
+	}
+
+	host := strings.TrimSuffix(strings.TrimPrefix(str, "["), "]")
+	if !v.allows(ConnectionHost) {
+		if v.allows(ConnectionURL) && !v.allows(ConnectionHostPort) {
+			return connectionEndpoint{}, fmt.Errorf("%q is missing the scheme, as in scheme://%s", str, str)
+		}
+		return connectionEndpoint{}, fmt.Errorf("%q is missing the port", str)
+	}
+	return connectionEndpoint{host: host}, checkHost(host)
+}
+
+func (v *ConnectionValidator) hostOnlyHint() string {
+	if v.PortKey != "" {
+		return fmt.Sprintf("set only the host here and the port in %s", v.PortKey)
+	}
+	return "set only the host"
+}
+
+func checkHost(host string) error {
+	if net.ParseIP(host) != nil || isValidHostname(host) {
+		return nil
+	}
+	return fmt.Errorf("invalid host %q", host)
+}
+
+func parseEndpointPort(port string) (int, error) {
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 1 || p > 65535 {
+		return 0, fmt.Errorf("invalid port %q", port)
+	}
+	return p, nil
+}
+
+func (v *ConnectionValidator) probe(get func(key string) (interface{}, error), endpoint connectionEndpoint) error {
+	port := endpoint.port
+	if port == 0 && v.PortKey != "" && !isBlank(get, v.PortKey) {
+		value, _ := get(v.PortKey)
+		port, _ = portNumber(v.PortKey, value)
+	}
+	if port == 0 && endpoint.scheme != "" {
+		port, _ = net.LookupPort("tcp", endpoint.scheme)
+	}
+	if port == 0 {
+		return &ConfigError{Key: v.Key, Message: "no port to probe"}
+	}
+
+	timeout := v.Timeout
+	if timeout == 0 {
+		timeout = defaultProbeTimeout
+	}
+	if net.ParseIP(endpoint.host) == nil {
+		resolver := &net.Resolver{}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if _, err := resolver.LookupHost(ctx, endpoint.host); err != nil {
+			return &ConfigError{Key: v.Key, Message: fmt.Sprintf("cannot resolve %s", endpoint.host), Err: err, Code: CodeUnresolvable}
+		}
+	}
+
+	address := net.JoinHostPort(endpoint.host, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		var netErr net.Error
+		switch {
+		case errors.Is(err, syscall.ECONNREFUSED):
+			return &ConfigError{Key: v.Key, Message: fmt.Sprintf("connection to %s refused", address), Err: err, Code: CodeUnreachable}
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return &ConfigError{Key: v.Key, Message: fmt.Sprintf("connection to %s timed out after %v", address, timeout), Err: err, Code: CodeUnreachable}
+		}
+		return &ConfigError{Key: v.Key, Message: fmt.Sprintf("cannot connect to %s", address), Err: err, Code: CodeUnreachable}
+	}
+	conn.Close()
+	return nil
+}
+
+// EnableConnectionProbes turns on the TCP probe of every registered
+// ConnectionValidator. Probing is off by default because it needs the
+// endpoints to be reachable from wherever validation runs.
+func (m *ConfigManager) EnableConnectionProbes(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, validator := range m.crossValidators {
+		if conn, ok := validator.(*ConnectionValidator); ok {
+			conn.Probe = enabled
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+const consulWaitTime = 5 * time.Minute
+
+// ConsulOptions describes how to reach a Consul agent.
+type ConsulOptions struct {
+	Address    string
+	Datacenter string
+	Token      string
+	TLS        TLSConfig
+}
+
+// ConsulOptionsFromConfig reads Consul connection settings from the keys
+// under prefix, e.g. "dynamic.consul.address" and "dynamic.consul.token".
+func ConsulOptionsFromConfig(m *ConfigManager, prefix string) ConsulOptions {
+	return ConsulOptions{
+		Address:    m.GetStringOrDefault(prefix+".address", ""),
+		Datacenter: m.GetStringOrDefault(prefix+".datacenter", ""),
+		Token:      m.GetStringOrDefault(prefix+".token", ""),
+		TLS: TLSConfig{
+			Enabled:  m.GetBoolOrDefault(prefix+".tls.enabled", false),
+			CertFile: m.GetStringOrDefault(prefix+".tls.cert_file", ""),
+			KeyFile:  m.GetStringOrDefault(prefix+".tls.key_file", ""),
+			CAFile:   m.GetStringOrDefault(prefix+".tls.ca_file", ""),
+		},
+	}
+}
+
+// ConsulBackend is a DynamicBackend that stores values in the Consul KV
+// store. Consul keys have no leading slash, so keys are returned in the
+// same form as the prefix they were requested with.
+type ConsulBackend struct {
+	kv     *consul.KV
+	logger Logger
+}
+
+func NewConsulBackend(opts ConsulOptions, logger Logger) (*ConsulBackend, error) {
+	config := consul.DefaultConfig()
+	if opts.Address != "" {
+		config.Address = opts.Address
+	}
+	config.Datacenter = opts.Datacenter
+	config.Token = opts.Token
+	if opts.TLS.Enabled {
+		config.Scheme = "https"
+		config.TLSConfig = consul.TLSConfig{
+			CAFile:   opts.TLS.CAFile,
+			CertFile: opts.TLS.CertFile,
+			KeyFile:  opts.TLS.KeyFile,
+		}
+	}
+
+	client, err := consul.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Consul client: %w", err)
+	}
+	return &ConsulBackend{
+		kv:     client.KV(),
+		logger: logger,
+	}, nil
+}
+
+func (b *ConsulBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	pair, _, err := b.kv.Get(consulKey(key), (&consul.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from Consul: %w", key, err)
+	}
+	if pair == nil {
+		return nil, fmt.Errorf("key %s not found", key)
+	}
+	return pair.Value, nil
+}
+
+func (b *ConsulBackend) Put(ctx context.Context, key string, value []byte) error {
+	pair := &consul.KVPair{Key: consulKey(key), Value: value}
+	if _, err := b.kv.Put(pair, (&consul.WriteOptions{}).WithContext(ctx)); err != nil {
+		return fmt.Errorf("failed to write %s to Consul: %w", key, err)
+	}
+	return nil
+}
+
+func (b *ConsulBackend) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	pairs, _, err := b.kv.List(consulKey(prefix), (&consul.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s from Consul: %w", prefix, err)
+	}
+	return listedPairs(prefix, pairs), nil
+}
+
+// Watch polls the key prefix with blocking queries and sends the value of
+// every key that was added or modified, and a nil value for deletes. Failed
+// queries, for example during a leader election, are retried with backoff.
+// The channel is closed when ctx is cancelled.
+func (b *ConsulBackend) Watch(ctx context.Context, key string) (<-chan []byte, error) {
+	pairs, meta, err := b.kv.List(consulKey(key), (&consul.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch %s in Consul: %w", key, err)
+	}
+
+	out := make(chan []byte, 16)
+	go b.watchLoop(ctx, key, meta.LastIndex, modifyIndexes(pairs), out)
+	return out, nil
+}
+
+func (b *ConsulBackend) watchLoop(ctx context.Context, key string, index uint64, seen map[string]uint64, out chan<- []byte) {
+	defer close(out)
+
+	send := func(value []byte) bool {
+		select {
+		case out <- value:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	backoff := backendRetryBackoff
+	for ctx.Err() == nil {
+		opts := &consul.QueryOptions{WaitIndex: index, WaitTime: consulWaitTime}
+		pairs, meta, err := b.kv.List(consulKey(key), opts.WithContext(ctx))
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			b.logger.Warn("Consul watch failed", "key", key, "error", err)
+			if !sleepBackoff(ctx, &backoff) {
+				return
+			}
+			continue
+		}
+		backoff = backendRetryBackoff
+
+		// Consul may reset the index, e.g. after a snapshot restore; start
+		// over rather than blocking on an index that will never be reached.
+		if meta.LastIndex < index {
+			index = 0
+			continue
+		}
+		if meta.LastIndex == index {
+			continue
+		}
+		index = meta.LastIndex
+
+		current := modifyIndexes(pairs)
+		for _, pair := range pairs {
+			if seen[pair.Key] != pair.ModifyIndex && !send(pair.Value) {
+				return
+			}
+		}
+		for k := range seen {
+			if _, exists := current[k]; !exists && !send(nil) {
+				return
+			}
+		}
+		seen = current
+	}
+}
+
+func consulKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+// listedPairs keys pairs the way prefix was written, skipping folder
+// entries.
+func listedPairs(prefix string, pairs consul.KVPairs) map[string][]byte {
+	lead := ""
+	if strings.HasPrefix(prefix, "/") {
+		lead = "/"
+	}
+
+	result := make(map[string][]byte, len(pairs))
+	for _, pair := range pairs {
+		if strings.HasSuffix(pair.Key, "/") {
+			continue
+		}
+		result[lead+pair.Key] = pair.Value
+	}
+	return result
+}
+
+func modifyIndexes(pairs consul.KVPairs) map[string]uint64 {
+	indexes := make(map[string]uint64, len(pairs))
+	for _, pair := range pairs {
+		indexes[pair.Key] = pair.ModifyIndex
+	}
+	return indexes
+}
@@ -0,0 +1,327 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// CrossValidator checks constraints that span several keys. get returns
+// the value of a key, or an error when the key isn't set. ValidateAll runs
+// cross validators after the per-key validators.
+type CrossValidator interface {
+	Validate(get func(key string) (interface{}, error)) error
+}
+
+// CrossValidatorFunc adapts a function to a CrossValidator.
+type CrossValidatorFunc func(get func(key string) (interface{}, error)) error
+
+func (f CrossValidatorFunc) Validate(get func(key string) (interface{}, error)) error {
+	return f(get)
+}
+
+func (m *ConfigManager) AddCrossValidator(validator CrossValidator) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.crossValidators = append(m.crossValidators, validator)
+}
+
+// AddCrossKeyValidator registers a validator ValidateAll runs over the
+// values of several keys at once.
+func (m *ConfigManager) AddCrossKeyValidator(validator *CrossKeyValidator) {
+	m.AddCrossValidator(validator)
+}
+
+// validationValue is the get function handed to cross validators. The
+// caller must hold m.mu.
+func (m *ConfigManager) validationValue(key string) (interface{}, error) {
+	key = m.canonicalKey(key)
+	value, exists := m.values[key]
+	if !exists || !value.IsSet {
+		return nil, &ConfigError{Key: key, Message: "key not found"}
+	}
+	return value.Value, nil
+}
+
+// KeyedCrossValidator is implemented by cross validators that can name
+// the keys they read. ValidateKey and ValidateSubtree only run cross
+// validators that do, and only when one of the keys is in scope.
+type KeyedCrossValidator interface {
+	CrossValidator
+	ValidatedKeys() []string
+}
+
+// keyedCrossValidator pairs a CrossValidatorFunc with the keys it reads.
+type keyedCrossValidator struct {
+	CrossValidatorFunc
+	keys []string
+}
+
+func (v keyedCrossValidator) ValidatedKeys() []string { return v.keys }
+
+// validateCross runs the cross validators, or with inScope only the keyed
+// ones that read a key inScope accepts. The caller must hold m.mu.
+func (m *ConfigManager) validateCross(report *ValidationReport, inScope func(key string) bool,
+	get func(key string) (interface{}, error)) {
+	for _, validator := range m.crossValidators {
+		if inScope != nil && !m.crossValidatorInScope(validator, inScope) {
+			continue
+		}
+		errs := []error{validator.Validate(get)}
+		if nested, ok := errs[0].(*MultiError); ok {
+			errs = nested.Errors
+		}
+		for _, err := range errs {
+			var key string
+			var configErr *ConfigError
+			if errors.As(err, &configErr) {
+				key = configErr.Key
+			}
+			if report.addWarning(key, err) {
+				continue
+			}
+			report.Errors.Add(err)
+		}
+	}
+}
+
+func (m *ConfigManager) crossValidatorInScope(validator CrossValidator, inScope func(key string) bool) bool {
+	keyed, ok := validator.(KeyedCrossValidator)
+	if !ok {
+		return false
+	}
+	for _, key := range keyed.ValidatedKeys() {
+		if inScope(m.canonicalKey(key)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *CrossKeyValidator) ValidatedKeys() []string { return v.Keys }
+
+func (v *CrossKeyValidator) Validate(get func(key string) (interface{}, error)) error {
+	values := make(map[string]interface{}, len(v.Keys))
+	for _, key := range v.Keys {
+		if value, err := get(key); err == nil {
+			values[key] = value
+		}
+	}
+	return v.Check(values)
+}
+
+// isEnabled reports whether key is set to true, as a bool or a string.
+func isEnabled(get func(key string) (interface{}, error), key string) bool {
+	value, err := get(key)
+	if err != nil {
+		return false
+	}
+	switch val := value.(type) {
+	case bool:
+		return val
+	case string:
+		enabled, _ := strconv.ParseBool(val)
+		return enabled
+	}
+	return false
+}
+
+// isBlank reports whether key is unset, nil or an empty string.
+func isBlank(get func(key string) (interface{}, error), key string) bool {
+	value, err := get(key)
+	if err != nil || value == nil {
+		return true
+	}
+	str, ok := value.(string)
+	return ok && str == ""
+}
+
+// NewRequiredWhenEnabledValidator requires keys to be set while
+// enabledKey is true.
+func NewRequiredWhenEnabledValidator(enabledKey string, keys ...string) CrossValidator {
+	validated := append([]string{enabledKey}, keys...)
+	return keyedCrossValidator{keys: validated, CrossValidatorFunc: func(get func(key string) (interface{}, error)) error {
+		if !isEnabled(get, enabledKey) {
+			return nil
+		}
+		var multiErr MultiError
+		for _, key := range keys {
+			if isBlank(get, key) {
+				multiErr.Add(&ConfigError{
+					Key:     key,
+					Message: fmt.Sprintf("required when %s is true", enabledKey),
+					Code:    CodeRequiredMissing,
+				})
+			}
+		}
+		if multiErr.HasErrors() {
+			return &multiErr
+		}
+		return nil
+	}}
+}
+
+// NewTLSFilesValidator checks the TLS settings under prefix, such as
+// server.http.tls: when prefix.enabled is true, prefix.cert_file and
+// prefix.key_file must name readable files, as must prefix.ca_file if set.
+func NewTLSFilesValidator(prefix string) CrossValidator {
+	enabledKey := prefix + ".enabled"
+	return keyedCrossValidator{keys: tlsKeys(prefix), CrossValidatorFunc: func(get func(key string) (interface{}, error)) error {
+		if !isEnabled(get, enabledKey) {
+			return nil
+		}
+		var multiErr MultiError
+		for _, name := range []string{"cert_file", "key_file", "ca_file"} {
+			key := prefix + "." + name
+			if isBlank(get, key) {
+				if name != "ca_file" {
+					multiErr.Add(&ConfigError{
+						Key:     key,
+						Message: fmt.Sprintf("required when %s is true", enabledKey),
+						Code:    CodeRequiredMissing,
+					})
+				}
+				continue
+			}
+			value, _ := get(key)
+			path, ok := value.(string)
+			if !ok {
+				multiErr.Add(&ConfigError{Key: key, Message: "expected file path string", Code: CodeTypeMismatch})
+				continue
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				multiErr.Add(&ConfigError{Key: key, Message: "file is not readable", Err: err})
+				continue
+			}
+			file.Close()
+		}
+		if multiErr.HasErrors() {
+			return &multiErr
+		}
+		return nil
+	}}
+}
+
+// defaultCertExpiryWarning is how far ahead of NotAfter TLSValidator
+// warns when ExpiryWarning is zero.
+const defaultCertExpiryWarning = 30 * 24 * time.Hour
+
+// TLSValidator goes further than NewTLSFilesValidator: when
+// Prefix.enabled is true it loads the certificate and key as a pair, so a
+// key that doesn't match the certificate is an error, and parses the CA
+// file if one is set. An expired certificate is an error; one that expires
+// within ExpiryWarning (30 days by default) is only a warning.
+type TLSValidator struct {
+	Prefix        string
+	ExpiryWarning time.Duration
+}
+
+func tlsKeys(prefix string) []string {
+	return []string{prefix + ".enabled", prefix + ".cert_file", prefix + ".key_file", prefix + ".ca_file"}
+}
+
+func (v *TLSValidator) ValidatedKeys() []string { return tlsKeys(v.Prefix) }
+
+func (v *TLSValidator) Validate(get func(key string) (interface{}, error)) error {
+	if err := NewTLSFilesValidator(v.Prefix).Validate(get); err != nil {
+		return err
+	}
+	if !isEnabled(get, v.Prefix+".enabled") {
+		return nil
+	}
+
+	certKey := v.Prefix + ".cert_file"
+	certFile, _ := get(certKey)
+	keyFile, _ := get(v.Prefix + ".key_file")
+	var multiErr MultiError
+	pair, err := tls.LoadX509KeyPair(certFile.(string), keyFile.(string))
+	if err != nil {
+		multiErr.Add(&ConfigError{Key: certKey, Message: "cannot load certificate and key", Err: err})
+	} else {
+		multiErr.Add(v.checkExpiry(certKey, pair.Leaf))
+	}
+
+	caKey := v.Prefix + ".ca_file"
+	if !isBlank(get, caKey) {
+		caFile, _ := get(caKey)
+		data, err := os.ReadFile(caFile.(string))
+		if err != nil {
+			multiErr.Add(&ConfigError{Key: caKey, Message: "file is not readable", Err: err})
+		} else if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			multiErr.Add(&ConfigError{Key: caKey, Message: "no PEM certificates found"})
+		}
+	}
+	if multiErr.HasErrors() {
+		return &multiErr
+	}
+	return nil
+}
+
+func (v *TLSValidator) checkExpiry(key string, cert *x509.Certificate) error {
+	if cert == nil {
+		return nil
+	}
+	horizon := v.ExpiryWarning
+	if horizon == 0 {
+		horizon = defaultCertExpiryWarning
+	}
+	now := time.Now()
+	notAfter := cert.NotAfter.Format(time.RFC3339)
+	switch {
+	case now.After(cert.NotAfter):
+		return &ConfigError{Key: key, Message: fmt.Sprintf("certificate expired at %s", notAfter)}
+	case now.Before(cert.NotBefore):
+		return &ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("certificate is not valid until %s", cert.NotBefore.Format(time.RFC3339)),
+		}
+	case now.Add(horizon).After(cert.NotAfter):
+		return &ConfigError{
+			Key:     key,
+			Message: "certificate expires soon",
+			Err:     &ValidationWarning{Message: fmt.Sprintf("certificate expires at %s", notAfter)},
+		}
+	}
+	return nil
+}
+
+// NewDistinctPathsValidator fails when two of keys name the same path.
+// Unset and empty keys are skipped.
+func NewDistinctPathsValidator(keys ...string) CrossValidator {
+	return keyedCrossValidator{keys: keys, CrossValidatorFunc: func(get func(key string) (interface{}, error)) error {
+		var multiErr MultiError
+		used := make(map[string]string)
+		for _, key := range keys {
+			if isBlank(get, key) {
+				continue
+			}
+			value, _ := get(key)
+			path, ok := value.(string)
+			if !ok {
+				continue
+			}
+			if abs, err := filepath.Abs(path); err == nil {
+				path = abs
+			}
+			if other, taken := used[path]; taken {
+				multiErr.Add(&ConfigError{
+					Key:     key,
+					Message: fmt.Sprintf("must not be the same path as %s", other),
+					Code:    CodeConflict,
+				})
+				continue
+			}
+			used[path] = key
+		}
+		if multiErr.HasErrors() {
+			return &multiErr
+		}
+		return nil
+	}}
+}
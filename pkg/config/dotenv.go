@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// EnvFileFormat reads .env files of KEY=value lines. Lines may start with
+// "export", "#" starts a comment outside quotes, and quoted values may span
+// several lines. Keys are mapped like EnvironmentSource maps variables:
+// when Prefix is set only keys carrying it are read, and "__" nests, so
+// BINDXDB_DATABASE__PORT becomes database.port.
+type EnvFileFormat struct {
+	Prefix string
+}
+
+func (f *EnvFileFormat) Name() string { return "env" }
+
+func (f *EnvFileFormat) Extension() []string { return []string{".env"} }
+
+func (f *EnvFileFormat) Unmarshal(data []byte) (map[string]interface{}, error) {
+	vars, err := parseDotenv(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]interface{})
+	for name, value := range vars {
+		if f.Prefix != "" && !strings.HasPrefix(name, f.Prefix) {
+			continue
+		}
+		key := envToKey(strings.TrimPrefix(name, f.Prefix))
+		if key == "" {
+			continue
+		}
+		setNestedValue(result, key, parseEnvValue(value))
+	}
+	return result, nil
+}
+
+// Marshal writes one prefixed variable per flattened key.
+func (f *EnvFileFormat) Marshal(config map[string]interface{}) ([]byte, error) {
+	flat := flattenMap(config)
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		if flat[key] == nil {
+			continue
+		}
+		name := f.Prefix + strings.ToUpper(strings.ReplaceAll(key, ".", "__"))
+		fmt.Fprintf(&b, "%s=%s\n", name, quoteDotenv(fmt.Sprint(flat[key])))
+	}
+	return []byte(b.String()), nil
+}
+
+func parseDotenv(data string) (map[string]string, error) {
+	vars := make(map[string]string)
+	lines := strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		name, raw, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("line %d: expected KEY=value", lineNo)
+		}
+		raw = strings.TrimSpace(raw)
+
+		if raw == "" || (raw[0] != '"' && raw[0] != '\'') {
+			if idx := strings.Index(raw, " #"); idx >= 0 {
+				raw = raw[:idx]
+			}
+			vars[name] = strings.TrimSpace(raw)
+			continue
+		}
+
+		// Quoted values run to the matching quote, which may be on a later
+		// line.
+		quote := raw[0]
+		value := raw[1:]
+		for {
+			if end := closingQuote(value, quote); end >= 0 {
+				rest := strings.TrimSpace(value[end+1:])
+				if rest != "" && !strings.HasPrefix(rest, "#") {
+					return nil, fmt.Errorf("line %d: unexpected text after quoted value", lineNo)
+				}
+				value = value[:end]
+				break
+			}
+			i++
+			if i >= len(lines) {
+				return nil, fmt.Errorf("line %d: unterminated quoted value", lineNo)
+			}
+			value += "\n" + lines[i]
+		}
+
+		if quote == '"' {
+			value = unescapeDotenv(value)
+		}
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// closingQuote returns the index of the first unescaped quote in s, or -1.
+// Backslash escapes only apply inside double quotes.
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		if quote == '"' && s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeDotenv(s string) string {
+	replacer := strings.NewReplacer(
+		`\n`, "\n",
+		`\r`, "\r",
+		`\t`, "\t",
+		`\"`, `"`,
+		`\\`, `\`,
+	)
+	return replacer.Replace(s)
+}
+
+func quoteDotenv(value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\n\"'#\\") {
+		return value
+	}
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\r", `\r`,
+		"\t", `\t`,
+	)
+	return `"` + replacer.Replace(value) + `"`
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// checkJSONDuplicates walks the token stream of data and reports the first
+// key defined twice within one object.
+func checkJSONDuplicates(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	lineAt := func() int {
+		return bytes.Count(data[:dec.InputOffset()], []byte("\n")) + 1
+	}
+
+	var walk func(path string) error
+	walk = func(path string) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		delim, ok := tok.(json.Delim)
+		if !ok {
+			return nil
+		}
+
+		switch delim {
+		case '{':
+			seen := make(map[string]int)
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				key := keyTok.(string)
+				fullKey := joinKey(path, key)
+				line := lineAt()
+				if first, dup := seen[key]; dup {
+					return duplicateKeyError(fullKey, first, line)
+				}
+				seen[key] = line
+				if err := walk(fullKey); err != nil {
+					return err
+				}
+			}
+		case '[':
+			for i := 0; dec.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+		// Consume the closing delimiter.
+		_, err = dec.Token()
+		return err
+	}
+	return walk("")
+}
+
+// checkYAMLDuplicates reports the first key defined twice within one YAML
+// mapping, with the full path of the key.
+func checkYAMLDuplicates(data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("invalid YAML: %w", err)
+	}
+
+	var walk func(node *yaml.Node, path string) error
+	walk = func(node *yaml.Node, path string) error {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				if err := walk(child, path); err != nil {
+					return err
+				}
+			}
+		case yaml.MappingNode:
+			seen := make(map[string]int)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				keyNode, valueNode := node.Content[i], node.Content[i+1]
+				fullKey := joinKey(path, keyNode.Value)
+				// Merge keys may repeat and their contents are allowed to be
+				// overridden by the mapping that merges them.
+				if keyNode.Tag != "!!merge" {
+					if first, dup := seen[keyNode.Value]; dup {
+						return duplicateKeyError(fullKey, first, keyNode.Line)
+					}
+					seen[keyNode.Value] = keyNode.Line
+				}
+				if err := walk(valueNode, fullKey); err != nil {
+					return err
+				}
+			}
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				if err := walk(child, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(&doc, "")
+}
+
+func duplicateKeyError(key string, first, line int) error {
+	return &ConfigError{
+		Key:     key,
+		Message: fmt.Sprintf("duplicate key at line %d, first defined at line %d", line, first),
+	}
+}
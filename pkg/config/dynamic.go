@@ -2,11 +2,26 @@ package config
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var (
+	ErrUpdateQueueFull   = errors.New("dynamic update queue is full")
+	ErrUpdaterNotRunning = errors.New("dynamic config manager is not running")
+	ErrNotDynamic        = errors.New("key is not dynamic and only takes effect after a restart")
+)
+
+const (
+	defaultUpdateQueueSize = 100
+	defaultUpdateTimeout   = 30 * time.Second
+)
+
 type DynamicUpdater interface {
 	CanUpdate(key string) bool
 	ApplyUpdate(key string, value interface{}) error
@@ -14,15 +29,51 @@ type DynamicUpdater interface {
 	RollbackUpdate(key string, oldValue interface{}) error
 }
 
+// ChainedUpdater is implemented by updaters that can share a key with
+// others. Normally only the first updater that can update a key runs; when
+// it allows chaining, every later one that also allows it runs after it.
+type ChainedUpdater interface {
+	AllowChained() bool
+}
+
+type registeredUpdater struct {
+	name     string
+	updater  DynamicUpdater
+	priority int
+}
+
 type DynamicConfigManager struct {
 	manager     *ConfigManager
-	updaters    map[string]DynamicUpdater
+	updaters    map[string]*registeredUpdater
 	mu          sync.RWMutex
 	updateQueue chan UpdateRequest
+	limiter     *updateLimiter
+	timeout     time.Duration
+	startOnce   sync.Once
+	started     atomic.Bool
 	ctx         context.Context
 	cancel      context.CancelFunc
 }
 
+// DynamicOption configures a DynamicConfigManager.
+type DynamicOption func(*DynamicConfigManager)
+
+// WithAutoStart starts the update worker from NewDynamicConfigManager, so
+// Start need not be called.
+func WithAutoStart() DynamicOption {
+	return func(d *DynamicConfigManager) {
+		d.Start()
+	}
+}
+
+// WithUpdateTimeout sets how long RequestUpdate waits for an update to be
+// applied when its context has no earlier deadline. The default is 30s.
+func WithUpdateTimeout(timeout time.Duration) DynamicOption {
+	return func(d *DynamicConfigManager) {
+		d.timeout = timeout
+	}
+}
+
 type UpdateRequest struct {
 	Key      string
 	Value    interface{}
@@ -30,6 +81,22 @@ type UpdateRequest struct {
 	Response chan UpdateResponse
 
 	Timeout time.Duration
+
+	// AllowStatic stores a value for a key that isn't dynamic instead of
+	// rejecting it. No updater is run, since the change only takes effect
+	// after a restart.
+	AllowStatic bool
+
+	// ctx is the requester's; the update is skipped if it is done before
+	// the worker gets to it.
+	ctx context.Context
+	// changes holds the values of a RequestBatchUpdate, in place of Key
+	// and Value.
+	changes map[string]interface{}
+	// stage is set on the change a StagedUpdate makes.
+	stage ChangeStage
+	// seq numbers single-key updates so superseded ones can be dropped.
+	seq uint64
 }
 
 type UpdateResponse struct {
@@ -37,35 +104,198 @@ type UpdateResponse struct {
 	Error    error
 	OldValue interface{}
 	NewValue interface{}
+	// RestartRequired is set when a static key was stored under
+	// AllowStatic.
+	RestartRequired bool
+	// Results has one entry per key of a RequestBatchUpdate, in the order
+	// the keys were applied, which is sorted key order.
+	Results []KeyUpdateResult
+
+	// restore is OldValue before redaction, which a StagedUpdate reverts
+	// to.
+	restore interface{}
+}
+
+// appliedResponse reports a successful update from oldValue to newValue,
+// redacting both for a secret key.
+func appliedResponse(oldValue, newValue interface{}, secret bool) UpdateResponse {
+	response := UpdateResponse{
+		Success:  true,
+		OldValue: oldValue,
+		NewValue: newValue,
+		restore:  oldValue,
+	}
+	if secret {
+		response.OldValue, response.NewValue = RedactedValue, RedactedValue
+	}
+	return response
+}
+
+// updateValue returns key's value as updaters and reverts see it: the
+// stored value with any ${secret:...} references left unresolved, so
+// writing it back keeps the reference, or for a secret key the value from
+// the secret store, where writing it back puts it again. secret reports
+// whether key is secret.
+func (m *ConfigManager) updateValue(key string) (value interface{}, secret bool, err error) {
+	m.mu.RLock()
+	canonical := m.canonicalKey(key)
+	stored, exists := m.values[canonical]
+	if !exists {
+		m.mu.RUnlock()
+		return nil, false, &ConfigError{Key: canonical, Message: "key not found"}
+	}
+	secret = stored.IsSecret || m.isSecretKey(canonical)
+	value = deepCopyValue(stored.Value)
+	m.mu.RUnlock()
+
+	if secret {
+		value, err = m.Get(key)
+	}
+	return value, secret, err
+}
+
+// UpdateOption adjusts a request made with RequestUpdate.
+type UpdateOption func(*UpdateRequest)
+
+// AllowStatic sets UpdateRequest.AllowStatic.
+func AllowStatic() UpdateOption {
+	return func(r *UpdateRequest) {
+		r.AllowStatic = true
+	}
 }
 
-func NewDynamicConfigManager(manager *ConfigManager) *DynamicConfigManager {
+func NewDynamicConfigManager(manager *ConfigManager, opts ...DynamicOption) *DynamicConfigManager {
 	ctx, cancel := context.WithCancel(context.Background())
 	dcm := &DynamicConfigManager{
 		manager:     manager,
-		updaters:    make(map[string]DynamicUpdater),
-		updateQueue: make(chan UpdateRequest, 100),
+		updaters:    make(map[string]*registeredUpdater),
+		updateQueue: make(chan UpdateRequest, defaultUpdateQueueSize),
+		limiter:     newUpdateLimiter(),
+		timeout:     defaultUpdateTimeout,
 		ctx:         ctx,
 		cancel:      cancel,
 	}
+	for _, opt := range opts {
+		opt(dcm)
+	}
+	manager.attachDynamic(dcm)
 
 	return dcm
 }
 
+// Start launches the worker that applies queued updates. Calling it again,
+// or after Stop, does nothing.
+func (d *DynamicConfigManager) Start() {
+	d.startOnce.Do(func() {
+		if d.ctx.Err() != nil {
+			return
+		}
+		d.started.Store(true)
+		go d.processUpdates()
+	})
+}
+
+// RequestUpdate queues an update of key and waits for its outcome, for at
+// most the update timeout or until ctx is done. A rejected update is
+// returned as both the response's Error and the error. A full queue or a
+// manager that isn't running fails at once rather than blocking.
+func (d *DynamicConfigManager) RequestUpdate(ctx context.Context, key string,
+	value interface{}, source ConfigSource, opts ...UpdateOption) (UpdateResponse, error) {
+	request := UpdateRequest{
+		Key:    key,
+		Value:  value,
+		Source: source,
+	}
+	for _, opt := range opts {
+		opt(&request)
+	}
+	return d.submit(ctx, request)
+}
+
+// submit queues request and waits for its response. Reverts of staged
+// updates are exempt from the rate limits.
+func (d *DynamicConfigManager) submit(ctx context.Context, request UpdateRequest) (UpdateResponse, error) {
+	key := request.Key
+	if !d.running() {
+		return UpdateResponse{}, &ConfigError{Key: key, Message: "cannot update", Err: ErrUpdaterNotRunning}
+	}
+	if request.stage != StageReverted {
+		keys := []string{key}
+		if request.changes != nil {
+			keys = keys[:0]
+			for k := range request.changes {
+				keys = append(keys, k)
+			}
+		}
+		if err := d.limiter.admit(d.manager, keys); err != nil {
+			return UpdateResponse{}, &ConfigError{Key: key, Message: "cannot update", Err: err}
+		}
+	}
+
+	if d.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+	}
+	request.Response = make(chan UpdateResponse, 1)
+	request.Timeout = d.timeout
+	request.ctx = ctx
+
+	if !d.limiter.enqueue(d.updateQueue, request) {
+		return UpdateResponse{}, &ConfigError{Key: key, Message: "cannot update", Err: ErrUpdateQueueFull}
+	}
+
+	select {
+	case response := <-request.Response:
+		return response, response.Error
+	case <-ctx.Done():
+		return UpdateResponse{}, &ConfigError{
+			Key:     key,
+			Message: "update not applied",
+			Err:     fmt.Errorf("gave up waiting: %w", ctx.Err()),
+		}
+	case <-d.ctx.Done():
+		return UpdateResponse{}, &ConfigError{Key: key, Message: "update not applied", Err: ErrUpdaterNotRunning}
+	}
+}
+
+func (d *DynamicConfigManager) running() bool {
+	return d.started.Load() && d.ctx.Err() == nil
+}
+
 func (d *DynamicConfigManager) processUpdates() {
 	for {
 		select {
 		case <-d.ctx.Done():
 			return
 		case request := <-d.updateQueue:
+			if request.changes != nil {
+				d.processBatch(request)
+				continue
+			}
+			if !d.limiter.dequeued(request.Key, request.seq) {
+				d.sendResponse(request, UpdateResponse{
+					Success: false,
+					Error:   &ConfigError{Key: request.Key, Message: "update not applied", Err: ErrSuperseded},
+				})
+				continue
+			}
 			d.processUpdate(request)
 		}
 	}
 }
 
 func (d *DynamicConfigManager) processUpdate(request UpdateRequest) {
+	if request.ctx != nil && request.ctx.Err() != nil {
+		d.sendResponse(request, UpdateResponse{
+			Success: false,
+			Error:   request.ctx.Err(),
+		})
+		return
+	}
+
 	var response UpdateResponse
-	oldValue, err := d.manager.Get(request.Key)
+	oldValue, secret, err := d.manager.updateValue(request.Key)
 	if err != nil {
 		response = UpdateResponse{
 			Success: false,
@@ -75,51 +305,69 @@ func (d *DynamicConfigManager) processUpdate(request UpdateRequest) {
 		return
 	}
 
-	var updater DynamicUpdater
-	d.mu.RLock()
-	for _, u := range d.updaters {
-		if u.CanUpdate(request.Key) {
-			updater = u
-			break
-		}
+	static := !d.manager.IsDynamic(request.Key)
+	if static && !request.AllowStatic {
+		d.sendResponse(request, UpdateResponse{
+			Success: false,
+			Error:   &ConfigError{Key: request.Key, Message: "cannot update at runtime", Err: ErrNotDynamic},
+		})
+		return
 	}
-	d.mu.RUnlock()
 
-	if updater == nil {
-		if err := d.manager.Set(request.Key, request.Value, request.Source, true); err != nil {
+	// Rejected before any updater acts on it, so there is nothing to roll
+	// back.
+	if err := d.manager.ValidateValue(request.Key, request.Value); err != nil {
+		d.sendResponse(request, UpdateResponse{
+			Success: false,
+			Error:   err,
+		})
+		return
+	}
+
+	if static {
+		if err := d.manager.setStaged(request.Key, request.Value, request.Source, false, request.stage); err != nil {
 			response = UpdateResponse{
 				Success: false,
 				Error:   err,
 			}
 		} else {
+			response = appliedResponse(oldValue, request.Value, secret)
+			response.RestartRequired = true
+		}
+		d.sendResponse(request, response)
+		return
+	}
+
+	updaters := d.updatersFor(request.Key)
+	if len(updaters) == 0 {
+		if err := d.manager.setStaged(request.Key, request.Value, request.Source, true, request.stage); err != nil {
 			response = UpdateResponse{
-				Success:  true,
-				OldValue: oldValue,
-				NewValue: request.Value,
+				Success: false,
+				Error:   err,
 			}
+		} else {
+			response = appliedResponse(oldValue, request.Value, secret)
 		}
 	} else {
-		if err := updater.ApplyUpdate(request.Key, request.Value); err != nil {
-			if rollbackErr := updater.RollbackUpdate(request.Key, oldValue); rollbackErr != nil {
-				d.manager.logger.Error("failed to rollback update",
-					"key", request.Key,
-					"error", rollbackErr)
-			}
-
+		if err := d.applyUpdaters(updaters, request.Key, request.Value, oldValue); err != nil {
 			response = UpdateResponse{
 				Success: false,
 				Error:   err,
 			}
 		} else {
-			if err := d.manager.Set(request.Key, request.Value, request.Source, true); err != nil {
-				d.manager.logger.Error("failed to update stored value after successful apply",
-					"key", request.Key,
-					"error", err)
-			}
-			response = UpdateResponse{
-				Success:  true,
-				OldValue: oldValue,
-				NewValue: request.Value,
+			if err := d.manager.setStaged(request.Key, request.Value, request.Source, true, request.stage); err != nil {
+				// The manager kept the old value, so the components must
+				// go back to it too.
+				if rollbackErr := d.rollbackUpdaters(updaters, request.Key, oldValue); rollbackErr != nil {
+					err = &MultiError{Errors: []error{err,
+						&ConfigError{Key: request.Key, Message: "rollback failed", Err: rollbackErr}}}
+				}
+				response = UpdateResponse{
+					Success: false,
+					Error:   err,
+				}
+			} else {
+				response = appliedResponse(oldValue, request.Value, secret)
 			}
 		}
 	}
@@ -127,6 +375,108 @@ func (d *DynamicConfigManager) processUpdate(request UpdateRequest) {
 	d.sendResponse(request, response)
 }
 
+// RegisterUpdater adds u under name. When several updaters can update a
+// key, the one with the lowest priority is used, ties going to the name
+// that sorts first.
+func (d *DynamicConfigManager) RegisterUpdater(name string, u DynamicUpdater, priority int) error {
+	if name == "" {
+		return fmt.Errorf("updater name is empty")
+	}
+	if u == nil {
+		return fmt.Errorf("updater %q is nil", name)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, exists := d.updaters[name]; exists {
+		return fmt.Errorf("updater %q is already registered", name)
+	}
+	d.updaters[name] = &registeredUpdater{name: name, updater: u, priority: priority}
+	return nil
+}
+
+// UnregisterUpdater removes the updater registered under name and reports
+// whether there was one.
+func (d *DynamicConfigManager) UnregisterUpdater(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, exists := d.updaters[name]; !exists {
+		return false
+	}
+	delete(d.updaters, name)
+	return true
+}
+
+// updatersFor returns the updaters to run for key, in order: the first
+// that can update it, followed by the later ones if they all allow
+// chaining.
+func (d *DynamicConfigManager) updatersFor(key string) []DynamicUpdater {
+	d.mu.RLock()
+	var matches []*registeredUpdater
+	for _, registration := range d.updaters {
+		if registration.updater.CanUpdate(key) {
+			matches = append(matches, registration)
+		}
+	}
+	d.mu.RUnlock()
+	if len(matches) == 0 {
+		return nil
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].priority != matches[j].priority {
+			return matches[i].priority < matches[j].priority
+		}
+		return matches[i].name < matches[j].name
+	})
+	updaters := []DynamicUpdater{matches[0].updater}
+	if !allowsChained(matches[0].updater) {
+		return updaters
+	}
+	for _, registration := range matches[1:] {
+		if allowsChained(registration.updater) {
+			updaters = append(updaters, registration.updater)
+		}
+	}
+	return updaters
+}
+
+func allowsChained(u DynamicUpdater) bool {
+	chained, ok := u.(ChainedUpdater)
+	return ok && chained.AllowChained()
+}
+
+// applyUpdaters applies value with each of updaters in turn. If one fails,
+// it and the ones before it are rolled back in reverse order and its error
+// is returned, along with the rollback error if any of them could not be
+// rolled back.
+func (d *DynamicConfigManager) applyUpdaters(updaters []DynamicUpdater, key string, value, oldValue interface{}) error {
+	for i, u := range updaters {
+		if err := u.ApplyUpdate(key, value); err != nil {
+			if rollbackErr := d.rollbackUpdaters(updaters[:i+1], key, oldValue); rollbackErr != nil {
+				d.manager.logger.Error("failed to rollback update",
+					"key", key,
+					"error", rollbackErr)
+				return &MultiError{Errors: []error{err,
+					&ConfigError{Key: key, Message: "rollback failed", Err: rollbackErr}}}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// rollbackUpdaters restores oldValue with each of updaters in reverse
+// order, returning the first error but rolling back the rest regardless.
+func (d *DynamicConfigManager) rollbackUpdaters(updaters []DynamicUpdater, key string, oldValue interface{}) error {
+	var firstErr error
+	for i := len(updaters) - 1; i >= 0; i-- {
+		if err := updaters[i].RollbackUpdate(key, oldValue); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 func (d *DynamicConfigManager) sendResponse(request UpdateRequest, response UpdateResponse) {
 	defer close(request.Response)
 
@@ -146,6 +496,34 @@ type ComponentUpdater struct {
 	keys         []string
 	applyFunc    func(key string, value interface{}) error
 	rollbackFunc func(key string, oldValue interface{}) error
+	chained      bool
+}
+
+// NewComponentUpdater returns an updater for keys and everything below
+// them. Either function may be nil.
+func NewComponentUpdater(name string, keys []string,
+	apply func(key string, value interface{}) error,
+	rollback func(key string, oldValue interface{}) error) *ComponentUpdater {
+	return &ComponentUpdater{
+		name:         name,
+		keys:         keys,
+		applyFunc:    apply,
+		rollbackFunc: rollback,
+	}
+}
+
+func (c *ComponentUpdater) Name() string {
+	return c.name
+}
+
+// EnableChaining lets c run alongside other chained updaters for the same
+// key.
+func (c *ComponentUpdater) EnableChaining(enabled bool) {
+	c.chained = enabled
+}
+
+func (c *ComponentUpdater) AllowChained() bool {
+	return c.chained
 }
 
 func (c *ComponentUpdater) CanUpdate(key string) bool {
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"context"
+	"sort"
+)
+
+// UpdateStatus is what happened to one key of a batch update.
+type UpdateStatus int
+
+const (
+	// UpdateNotApplied means the key was never changed: the batch was
+	// rejected, or failed before reaching it.
+	UpdateNotApplied UpdateStatus = iota
+	UpdateApplied
+	// UpdateFailed marks the key whose updater failed.
+	UpdateFailed
+	UpdateRolledBack
+	// UpdateRollbackFailed means the key was applied and could not be
+	// rolled back, so the component it belongs to may still use the new
+	// value.
+	UpdateRollbackFailed
+)
+
+var updateStatusNames = [...]string{
+	"not_applied",
+	"applied",
+	"failed",
+	"rolled_back",
+	"rollback_failed",
+}
+
+func (s UpdateStatus) String() string {
+	if int(s) < len(updateStatusNames) {
+		return updateStatusNames[s]
+	}
+	return "unknown"
+}
+
+// KeyUpdateResult is the outcome for one key of a batch update. The values
+// of secret keys are redacted.
+type KeyUpdateResult struct {
+	Key      string
+	Status   UpdateStatus
+	OldValue interface{}
+	NewValue interface{}
+	Error    error
+}
+
+// RequestBatchUpdate changes several keys as one transaction. Every key is
+// checked and the new values validated together before anything is
+// applied; then the updaters run in key order. If one fails, the keys
+// already applied are rolled back in reverse order and the combined error
+// is returned. The response's Results report where each key ended up.
+func (d *DynamicConfigManager) RequestBatchUpdate(ctx context.Context, changes map[string]interface{},
+	source ConfigSource, opts ...UpdateOption) (UpdateResponse, error) {
+	if len(changes) == 0 {
+		return UpdateResponse{Success: true}, nil
+	}
+	request := UpdateRequest{
+		Source:  source,
+		changes: changes,
+	}
+	for _, opt := range opts {
+		opt(&request)
+	}
+	return d.submit(ctx, request)
+}
+
+// batchStep is a key of a batch whose updaters have applied it.
+type batchStep struct {
+	index    int
+	updaters []DynamicUpdater
+}
+
+func (d *DynamicConfigManager) processBatch(request UpdateRequest) {
+	if request.ctx != nil && request.ctx.Err() != nil {
+		d.sendResponse(request, UpdateResponse{
+			Success: false,
+			Error:   request.ctx.Err(),
+		})
+		return
+	}
+
+	keys := make([]string, 0, len(request.changes))
+	for key := range request.changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results := make([]KeyUpdateResult, len(keys))
+	oldValues := make([]interface{}, len(keys))
+	static := make(map[string]bool)
+	var rejected MultiError
+	for i, key := range keys {
+		results[i] = KeyUpdateResult{Key: key, NewValue: request.changes[key]}
+		oldValue, secret, err := d.manager.updateValue(key)
+		if err != nil {
+			results[i].Error = err
+			rejected.Add(err)
+			continue
+		}
+		oldValues[i], results[i].OldValue = oldValue, oldValue
+		if secret {
+			results[i].OldValue, results[i].NewValue = RedactedValue, RedactedValue
+		}
+		if !d.manager.IsDynamic(key) {
+			if !request.AllowStatic {
+				err := &ConfigError{Key: key, Message: "cannot update at runtime", Err: ErrNotDynamic}
+				results[i].Error = err
+				rejected.Add(err)
+				continue
+			}
+			static[key] = true
+		}
+	}
+	if !rejected.HasErrors() {
+		rejected.Add(d.manager.ValidateValues(request.changes))
+	}
+	if rejected.HasErrors() {
+		d.sendResponse(request, UpdateResponse{
+			Success: false,
+			Error:   &rejected,
+			Results: results,
+		})
+		return
+	}
+
+	var applied []batchStep
+	var failure error
+	for i, key := range keys {
+		if static[key] {
+			continue
+		}
+		updaters := d.updatersFor(key)
+		if len(updaters) == 0 {
+			continue
+		}
+		if err := d.applyUpdaters(updaters, key, request.changes[key], oldValues[i]); err != nil {
+			results[i].Status = UpdateFailed
+			results[i].Error = err
+			failure = &ConfigError{Key: key, Message: "update failed", Err: err}
+			break
+		}
+		results[i].Status = UpdateApplied
+		applied = append(applied, batchStep{index: i, updaters: updaters})
+	}
+
+	if failure == nil {
+		failure = d.manager.SetBatch(request.changes, request.Source)
+	}
+	if failure == nil {
+		var dynamicKeys []string
+		for i, key := range keys {
+			results[i].Status = UpdateApplied
+			if !static[key] {
+				dynamicKeys = append(dynamicKeys, key)
+			}
+		}
+		d.manager.markDynamic(dynamicKeys)
+		d.sendResponse(request, UpdateResponse{
+			Success:         true,
+			RestartRequired: len(static) > 0,
+			Results:         results,
+		})
+		return
+	}
+
+	var multiErr MultiError
+	multiErr.Add(failure)
+	for j := len(applied) - 1; j >= 0; j-- {
+		i := applied[j].index
+		if err := d.rollbackUpdaters(applied[j].updaters, keys[i], oldValues[i]); err != nil {
+			results[i].Status = UpdateRollbackFailed
+			results[i].Error = err
+			multiErr.Add(&ConfigError{Key: keys[i], Message: "rollback failed", Err: err})
+			continue
+		}
+		results[i].Status = UpdateRolledBack
+	}
+	d.sendResponse(request, UpdateResponse{
+		Success: false,
+		Error:   &multiErr,
+		Results: results,
+	})
+}
+
+// markDynamic flags the values of keys as dynamic, as Set does for its
+// dynamic argument.
+func (m *ConfigManager) markDynamic(keys []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		if value, ok := m.values[m.canonicalKey(key)]; ok {
+			value.IsDynamic = true
+		}
+	}
+}
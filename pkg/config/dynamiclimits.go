@@ -0,0 +1,218 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRateLimited is matched by every RateLimitError.
+var ErrRateLimited = errors.New("dynamic update rate limit exceeded")
+
+// ErrSuperseded is returned for a queued update that was dropped because
+// a later update of the same key was queued behind it.
+var ErrSuperseded = errors.New("superseded by a later update of the same key")
+
+// Keys under dynamic.limits set the update rate limits, in updates per
+// second with a burst size. A rate of zero or less, the default, means
+// no limit.
+const (
+	limitGlobalRateKey  = "dynamic.limits.global_rate"
+	limitGlobalBurstKey = "dynamic.limits.global_burst"
+	limitKeyRateKey     = "dynamic.limits.key_rate"
+	limitKeyBurstKey    = "dynamic.limits.key_burst"
+)
+
+// maxKeyBuckets bounds the per-key buckets kept before full ones, which
+// carry no state, are dropped.
+const maxKeyBuckets = 1024
+
+// RateLimitError rejects an update that exceeded a rate limit.
+type RateLimitError struct {
+	Key string
+	// Scope is "global" or "key".
+	Scope string
+	// RetryAfter is how long until the update would be admitted.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s update rate limit exceeded, retry after %v", e.Scope, e.RetryAfter.Round(time.Millisecond))
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// tokenBucket holds up to burst tokens and gains rate tokens a second.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	b := &tokenBucket{last: now}
+	b.configure(rate, burst)
+	b.tokens = b.burst
+	return b
+}
+
+func (b *tokenBucket) configure(rate float64, burst int) {
+	b.rate = rate
+	b.burst = math.Max(float64(burst), 1)
+	b.tokens = math.Min(b.tokens, b.burst)
+}
+
+// wait refills the bucket and returns how long until it holds a token,
+// zero if it already does.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take() {
+	b.tokens--
+}
+
+func (b *tokenBucket) full() bool {
+	return b.tokens >= b.burst
+}
+
+// updateLimiter enforces the dynamic.limits rates and coalesces queued
+// updates of the same key.
+type updateLimiter struct {
+	mu     sync.Mutex
+	global *tokenBucket
+	keys   map[string]*tokenBucket
+	// latest holds the sequence number of the last update queued for each
+	// key; earlier ones still in the queue are superseded.
+	latest  map[string]uint64
+	nextSeq uint64
+
+	rateLimited uint64
+	queueFull   uint64
+	coalesced   uint64
+}
+
+func newUpdateLimiter() *updateLimiter {
+	return &updateLimiter{
+		keys:   make(map[string]*tokenBucket),
+		latest: make(map[string]uint64),
+	}
+}
+
+// admit takes a token for keys from the global bucket and from each key's
+// bucket, or none if any of them is empty.
+func (l *updateLimiter) admit(manager *ConfigManager, keys []string) error {
+	globalRate, _ := manager.GetFloat(limitGlobalRateKey)
+	globalBurst := manager.GetIntOrDefault(limitGlobalBurstKey, 1)
+	keyRate, _ := manager.GetFloat(limitKeyRateKey)
+	keyBurst := manager.GetIntOrDefault(limitKeyBurstKey, 1)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var buckets []*tokenBucket
+	if globalRate > 0 {
+		if l.global == nil {
+			l.global = newTokenBucket(globalRate, globalBurst, now)
+		}
+		l.global.configure(globalRate, globalBurst)
+		if wait := l.global.wait(now); wait > 0 {
+			atomic.AddUint64(&l.rateLimited, 1)
+			return &RateLimitError{Scope: "global", RetryAfter: wait}
+		}
+		buckets = append(buckets, l.global)
+	}
+	if keyRate > 0 {
+		if len(l.keys) > maxKeyBuckets {
+			for key, bucket := range l.keys {
+				if bucket.wait(now) == 0 && bucket.full() {
+					delete(l.keys, key)
+				}
+			}
+		}
+		for _, key := range keys {
+			bucket, ok := l.keys[key]
+			if !ok {
+				bucket = newTokenBucket(keyRate, keyBurst, now)
+				l.keys[key] = bucket
+			}
+			bucket.configure(keyRate, keyBurst)
+			if wait := bucket.wait(now); wait > 0 {
+				atomic.AddUint64(&l.rateLimited, 1)
+				return &RateLimitError{Key: key, Scope: "key", RetryAfter: wait}
+			}
+			buckets = append(buckets, bucket)
+		}
+	}
+	for _, bucket := range buckets {
+		bucket.take()
+	}
+	return nil
+}
+
+// enqueue puts request on queue without blocking. Single-key updates are
+// numbered so that the ones queued earlier for the same key are dropped.
+func (l *updateLimiter) enqueue(queue chan<- UpdateRequest, request UpdateRequest) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if request.changes == nil {
+		l.nextSeq++
+		request.seq = l.nextSeq
+	}
+	select {
+	case queue <- request:
+	default:
+		atomic.AddUint64(&l.queueFull, 1)
+		return false
+	}
+	if request.changes == nil {
+		l.latest[request.Key] = request.seq
+	}
+	return true
+}
+
+// dequeued reports whether the update of key numbered seq is still the
+// latest one, forgetting it if so.
+func (l *updateLimiter) dequeued(key string, seq uint64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.latest[key] != seq {
+		atomic.AddUint64(&l.coalesced, 1)
+		return false
+	}
+	delete(l.latest, key)
+	return true
+}
+
+// DynamicStats describes the update queue of a DynamicConfigManager.
+type DynamicStats struct {
+	QueueDepth int
+	// RateLimited and QueueFull count updates rejected by a rate limit or
+	// because the queue was full.
+	RateLimited uint64
+	QueueFull   uint64
+	// Coalesced counts queued updates dropped for a later one of the same
+	// key.
+	Coalesced uint64
+}
+
+func (d *DynamicConfigManager) Stats() DynamicStats {
+	return DynamicStats{
+		QueueDepth:  len(d.updateQueue),
+		RateLimited: atomic.LoadUint64(&d.limiter.rateLimited),
+		QueueFull:   atomic.LoadUint64(&d.limiter.queueFull),
+		Coalesced:   atomic.LoadUint64(&d.limiter.coalesced),
+	}
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// stageChecks is how many times HealthFunc is called over an observation
+// window, the last one when the window ends.
+const stageChecks = 10
+
+// HealthFunc reports whether the system is still healthy while a staged
+// update is observed.
+type HealthFunc func(ctx context.Context) error
+
+// UpdateStage follows a StagedUpdate through its observation window.
+type UpdateStage struct {
+	Key string
+	// OldValue and NewValue are redacted for a secret key.
+	OldValue interface{}
+	NewValue interface{}
+
+	// restore and applied are OldValue and NewValue unredacted, with
+	// secret references unresolved.
+	restore  interface{}
+	applied  interface{}
+	done     chan struct{}
+	mu       sync.Mutex
+	reverted bool
+	err      error
+}
+
+// Done is closed once the update has been kept or reverted.
+func (s *UpdateStage) Done() <-chan struct{} {
+	return s.done
+}
+
+// Reverted reports whether the old value was restored.
+func (s *UpdateStage) Reverted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reverted
+}
+
+// Err returns why the update was reverted, or why it could not be, and nil
+// while it is being observed or once it has been kept.
+func (s *UpdateStage) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *UpdateStage) finish(reverted bool, err error) {
+	s.mu.Lock()
+	s.reverted, s.err = reverted, err
+	s.mu.Unlock()
+	close(s.done)
+}
+
+// StagedUpdate applies value to key like RequestUpdate and then watches
+// health over window. If health fails before the window ends, or ctx is
+// cancelled, the old value is applied again through the updaters and
+// stored. The apply and revert reach watchers as changes with Stage set to
+// StageApplied and StageReverted.
+func (d *DynamicConfigManager) StagedUpdate(ctx context.Context, key string, value interface{},
+	source ConfigSource, window time.Duration, health HealthFunc, opts ...UpdateOption) (*UpdateStage, error) {
+	request := UpdateRequest{
+		Key:    key,
+		Value:  value,
+		Source: source,
+		stage:  StageApplied,
+	}
+	for _, opt := range opts {
+		opt(&request)
+	}
+	response, err := d.submit(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	stage := &UpdateStage{
+		Key:      key,
+		OldValue: response.OldValue,
+		NewValue: response.NewValue,
+		restore:  response.restore,
+		applied:  value,
+		done:     make(chan struct{}),
+	}
+	go d.observe(ctx, stage, request, window, health)
+	return stage, nil
+}
+
+func (d *DynamicConfigManager) observe(ctx context.Context, stage *UpdateStage,
+	request UpdateRequest, window time.Duration, health HealthFunc) {
+	deadline := time.NewTimer(window)
+	defer deadline.Stop()
+	var tick <-chan time.Time
+	if interval := window / stageChecks; interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			d.revert(stage, request, ctx.Err())
+			return
+		case <-d.ctx.Done():
+			stage.finish(false, &ConfigError{Key: stage.Key, Message: "staged update not observed", Err: ErrUpdaterNotRunning})
+			return
+		case <-tick:
+			if err := health(ctx); err != nil {
+				d.revert(stage, request, err)
+				return
+			}
+		case <-deadline.C:
+			if err := health(ctx); err != nil {
+				d.revert(stage, request, err)
+				return
+			}
+			stage.finish(false, nil)
+			return
+		}
+	}
+}
+
+// revert restores the value key had before stage, unless it has been
+// changed again since.
+func (d *DynamicConfigManager) revert(stage *UpdateStage, request UpdateRequest, cause error) {
+	if current, _, err := d.manager.updateValue(stage.Key); err == nil && !reflect.DeepEqual(current, stage.applied) {
+		d.manager.logger.Warn("not reverting staged update, key changed since",
+			"key", stage.Key,
+			"cause", cause)
+		stage.finish(false, &ConfigError{
+			Key:     stage.Key,
+			Message: "staged update not reverted, value changed since",
+			Err:     cause,
+		})
+		return
+	}
+
+	request.Value = stage.restore
+	request.stage = StageReverted
+	if _, err := d.submit(context.Background(), request); err != nil {
+		d.manager.logger.Error("failed to revert staged update",
+			"key", stage.Key,
+			"error", err)
+		stage.finish(false, &ConfigError{
+			Key:     stage.Key,
+			Message: fmt.Sprintf("staged update failed (%v) and could not be reverted", cause),
+			Err:     err,
+		})
+		return
+	}
+	d.manager.logger.Info("reverted staged update", "key", stage.Key, "cause", cause)
+	stage.finish(true, &ConfigError{Key: stage.Key, Message: "staged update reverted", Err: cause})
+}
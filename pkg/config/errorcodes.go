@@ -0,0 +1,135 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Error codes set on ConfigError.Code. They are stable, unlike messages,
+// so tools can match on them.
+const (
+	CodeInvalidValue    = "CFG_INVALID_VALUE"
+	CodeTypeMismatch    = "CFG_TYPE_MISMATCH"
+	CodeOutOfRange      = "CFG_OUT_OF_RANGE"
+	CodeRequiredMissing = "CFG_REQUIRED_MISSING"
+	CodePatternMismatch = "CFG_PATTERN_MISMATCH"
+	CodeInvalidLength   = "CFG_INVALID_LENGTH"
+	CodeNotAllowed      = "CFG_NOT_ALLOWED"
+	CodeInvalidFormat   = "CFG_INVALID_FORMAT"
+	CodeUnknownKey      = "CFG_UNKNOWN_KEY"
+	CodeDeprecated      = "CFG_DEPRECATED"
+	CodeConflict        = "CFG_CONFLICT"
+	CodeInvalidSchema   = "CFG_INVALID_SCHEMA"
+	CodeUnresolvable    = "CFG_UNRESOLVABLE"
+	CodeUnreachable     = "CFG_UNREACHABLE"
+	CodeWarning         = "CFG_WARNING"
+	CodeRejected        = "CFG_REJECTED"
+)
+
+// errorCode returns the code of the first ConfigError in err's chain that
+// has one, or CodeInvalidValue.
+func errorCode(err error) string {
+	for err != nil {
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) {
+			break
+		}
+		if configErr.Code != "" {
+			return configErr.Code
+		}
+		err = configErr.Err
+	}
+	return CodeInvalidValue
+}
+
+// validatorCode picks the code for an error returned by validator.
+func validatorCode(validator ConfigValidator, err error) string {
+	if code := errorCode(err); code != CodeInvalidValue {
+		return code
+	}
+	switch validator.(type) {
+	case *RequiredValidator:
+		return CodeRequiredMissing
+	case *TypeValidator:
+		return CodeTypeMismatch
+	case *RangeValidator, *PortValidator, *DurationValidator, *SizeValidator:
+		return CodeOutOfRange
+	case *PatternValidator:
+		return CodePatternMismatch
+	case *LengthValidator:
+		return CodeInvalidLength
+	case *EnumValidator:
+		return CodeNotAllowed
+	case *URLValidator, *IPValidator, *IPListValidator, *HostValidator,
+		*EmailValidator, *UUIDValidator, *RegexValidator:
+		return CodeInvalidFormat
+	}
+	return CodeInvalidValue
+}
+
+// validatorError wraps an error returned by validator for key.
+func validatorError(key string, validator ConfigValidator, err error) *ConfigError {
+	return &ConfigError{
+		Key:     key,
+		Message: "validation failed",
+		Err:     err,
+		Code:    validatorCode(validator, err),
+	}
+}
+
+// ReportEntry is one error or warning in the JSON form of a MultiError or
+// ValidationReport.
+type ReportEntry struct {
+	Key      string `json:"key"`
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Severity string `json:"severity"`
+}
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Entries flattens e, including nested MultiErrors, into report entries.
+func (e *MultiError) Entries() []ReportEntry {
+	entries := []ReportEntry{}
+	for _, err := range e.Errors {
+		var nested *MultiError
+		if errors.As(err, &nested) {
+			entries = append(entries, nested.Entries()...)
+			continue
+		}
+		entry := ReportEntry{Code: errorCode(err), Message: err.Error(), Severity: SeverityError}
+		var configErr *ConfigError
+		if errors.As(err, &configErr) {
+			entry.Key = configErr.Key
+			entry.Message = strings.TrimPrefix(err.Error(), "config error for key "+configErr.Key+": ")
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (e *MultiError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Entries())
+}
+
+// Entries lists the errors followed by the warnings.
+func (r *ValidationReport) Entries() []ReportEntry {
+	entries := r.Errors.Entries()
+	for _, warning := range r.Warnings {
+		entries = append(entries, ReportEntry{
+			Key:      warning.Key,
+			Code:     CodeWarning,
+			Message:  warning.Message,
+			Severity: SeverityWarning,
+		})
+	}
+	return entries
+}
+
+func (r ValidationReport) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Entries())
+}
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// EtcdOptions describes how to reach an etcd v3 cluster.
+type EtcdOptions struct {
+	Endpoints   []string
+	DialTimeout time.Duration
+	Username    string
+	Password    string
+	TLS         TLSConfig
+}
+
+// EtcdOptionsFromConfig reads etcd connection settings from the keys under
+// prefix, e.g. "dynamic.etcd.endpoints" and "dynamic.etcd.password", so the
+// credentials can come from files, the environment or the secret store like
+// any other value.
+func EtcdOptionsFromConfig(m *ConfigManager, prefix string) (EtcdOptions, error) {
+	endpoints, err := m.GetStringSlice(prefix + ".endpoints")
+	if err != nil {
+		return EtcdOptions{}, err
+	}
+
+	return EtcdOptions{
+		Endpoints:   endpoints,
+		DialTimeout: m.GetDurationOrDefault(prefix+".dial_timeout", 5*time.Second),
+		Username:    m.GetStringOrDefault(prefix+".username", ""),
+		Password:    m.GetStringOrDefault(prefix+".password", ""),
+		TLS: TLSConfig{
+			Enabled:  m.GetBoolOrDefault(prefix+".tls.enabled", false),
+			CertFile: m.GetStringOrDefault(prefix+".tls.cert_file", ""),
+			KeyFile:  m.GetStringOrDefault(prefix+".tls.key_file", ""),
+			CAFile:   m.GetStringOrDefault(prefix+".tls.ca_file", ""),
+		},
+	}, nil
+}
+
+// EtcdBackend is a DynamicBackend that stores values in etcd v3.
+type EtcdBackend struct {
+	client *clientv3.Client
+	logger Logger
+	once   sync.Once
+}
+
+func NewEtcdBackend(opts EtcdOptions, logger Logger) (*EtcdBackend, error) {
+	if len(opts.Endpoints) == 0 {
+		return nil, fmt.Errorf("etcd backend requires at least one endpoint")
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 5 * time.Second
+	}
+
+	clientConfig := clientv3.Config{
+		Endpoints:   opts.Endpoints,
+		DialTimeout: opts.DialTimeout,
+		Username:    opts.Username,
+		Password:    opts.Password,
+	}
+	if opts.TLS.Enabled {
+		tlsConfig, err := buildTLSConfig(opts.TLS)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig.TLS = tlsConfig
+	}
+
+	client, err := clientv3.New(clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create etcd client: %w", err)
+	}
+	return &EtcdBackend{
+		client: client,
+		logger: logger,
+	}, nil
+}
+
+func (b *EtcdBackend) Get(ctx context.Context, key string) ([]byte, error) {
+	resp, err := b.client.Get(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from etcd: %w", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, fmt.Errorf("key %s not found", key)
+	}
+	return resp.Kvs[0].Value, nil
+}
+
+func (b *EtcdBackend) Put(ctx context.Context, key string, value []byte) error {
+	if _, err := b.client.Put(ctx, key, string(value)); err != nil {
+		return fmt.Errorf("failed to write %s to etcd: %w", key, err)
+	}
+	return nil
+}
+
+func (b *EtcdBackend) List(ctx context.Context, prefix string) (map[string][]byte, error) {
+	resp, err := b.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s from etcd: %w", prefix, err)
+	}
+
+	result := make(map[string][]byte, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		result[string(kv.Key)] = kv.Value
+	}
+	return result, nil
+}
+
+// Watch streams the value of every put under the key prefix, and a nil
+// value for deletes. If the watch is lost to a disconnect or compaction it
+// is re-established from the last seen revision; after a compaction a nil
+// value is sent since intermediate events may be missing. The channel is
+// closed when ctx is cancelled.
+func (b *EtcdBackend) Watch(ctx context.Context, key string) (<-chan []byte, error) {
+	resp, err := b.client.Get(ctx, key, clientv3.WithPrefix(), clientv3.WithCountOnly())
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch %s in etcd: %w", key, err)
+	}
+
+	out := make(chan []byte, 16)
+	go b.watchLoop(ctx, key, resp.Header.Revision+1, out)
+	return out, nil
+}
+
+func (b *EtcdBackend) watchLoop(ctx context.Context, key string, rev int64, out chan<- []byte) {
+	defer close(out)
+
+	send := func(value []byte) bool {
+		select {
+		case out <- value:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	backoff := backendRetryBackoff
+	for ctx.Err() == nil {
+		watchCtx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+		watchCh := b.client.Watch(watchCtx, key, clientv3.WithPrefix(), clientv3.WithRev(rev))
+
+		for resp := range watchCh {
+			if resp.CompactRevision != 0 {
+				b.logger.Warn("etcd watch compacted, resyncing",
+					"key", key, "revision", resp.CompactRevision)
+				rev = resp.CompactRevision
+				if !send(nil) {
+					cancel()
+					return
+				}
+				break
+			}
+			if err := resp.Err(); err != nil {
+				b.logger.Warn("etcd watch failed", "key", key, "error", err)
+				break
+			}
+
+			backoff = backendRetryBackoff
+			for _, event := range resp.Events {
+				var value []byte
+				if event.Type == clientv3.EventTypePut {
+					value = event.Kv.Value
+				}
+				if !send(value) {
+					cancel()
+					return
+				}
+			}
+			rev = resp.Header.Revision + 1
+		}
+		cancel()
+
+		if !sleepBackoff(ctx, &backoff) {
+			return
+		}
+	}
+}
+
+func (b *EtcdBackend) Close() error {
+	var err error
+	b.once.Do(func() {
+		err = b.client.Close()
+	})
+	return err
+}
+
+func buildTLSConfig(cfg TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.CAFile != "" {
+		data, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificates found in CA file")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func (m *ConfigManager) EnableEnvExpansion(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.expandEnv = enabled
+}
+
+func expandEnvValues(key string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return expandEnvValue(key, v)
+	case []interface{}:
+		expanded := make([]interface{}, len(v))
+		for i, item := range v {
+			e, err := expandEnvValues(fmt.Sprintf("%s[%d]", key, i), item)
+			if err != nil {
+				return nil, err
+			}
+			expanded[i] = e
+		}
+		return expanded, nil
+	default:
+		return value, nil
+	}
+}
+
+// expandEnvValue substitutes ${VAR} and ${VAR:-default} references in value
+// from the process environment. "$$" produces a literal dollar sign, and a
+// "$" not followed by "{" is left alone.
+func expandEnvValue(key, value string) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c != '$' || i+1 >= len(value) {
+			b.WriteByte(c)
+			continue
+		}
+
+		switch value[i+1] {
+		case '$':
+			b.WriteByte('$')
+			i++
+		case '{':
+			end := strings.IndexByte(value[i+2:], '}')
+			if end < 0 {
+				return "", &ConfigError{
+					Key:     key,
+					Message: fmt.Sprintf("unterminated variable reference in %q", value),
+				}
+			}
+			expr := value[i+2 : i+2+end]
+			if strings.HasPrefix(expr, "secret:") {
+				// Secret references are resolved on Get, not at load time.
+				b.WriteString(value[i : i+3+end])
+				i += end + 2
+				continue
+			}
+			name, fallback, hasDefault := strings.Cut(expr, ":-")
+			if name == "" {
+				return "", &ConfigError{
+					Key:     key,
+					Message: fmt.Sprintf("empty variable reference in %q", value),
+				}
+			}
+			if envValue, ok := os.LookupEnv(name); ok {
+				b.WriteString(envValue)
+			} else if hasDefault {
+				b.WriteString(fallback)
+			} else {
+				return "", &ConfigError{
+					Key:     key,
+					Message: fmt.Sprintf("environment variable %s is not set", name),
+				}
+			}
+			i += end + 2
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,133 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RedactedValue stands in for secret values wherever they are shown.
+const RedactedValue = "[REDACTED]"
+
+// redactChange hides the values of a change to a secret key. A nil side
+// stays nil so watchers can still tell a key was added or removed.
+func redactChange(change ConfigChange) ConfigChange {
+	if change.OldValue != nil {
+		change.OldValue = RedactedValue
+	}
+	if change.NewValue != nil {
+		change.NewValue = RedactedValue
+	}
+	return change
+}
+
+// Export renders the effective configuration as a nested document in the
+// named format. Secret values are replaced with a placeholder when
+// redactSecrets is set.
+func (m *ConfigManager) Export(format string, includeDefaults bool, redactSecrets bool) ([]byte, error) {
+	configFormat := m.loader.Format(format)
+	if configFormat == nil {
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+
+	m.mu.RLock()
+	keys := make([]string, 0, len(m.values))
+	flat := make(map[string]interface{}, len(m.values))
+	for key, value := range m.values {
+		if value.IsDefault && !includeDefaults {
+			continue
+		}
+		if value.IsSecret {
+			keys = append(keys, key)
+			continue
+		}
+		// Secret references are exported unresolved.
+		flat[key] = exportValue(value.Value)
+	}
+	m.mu.RUnlock()
+
+	for _, key := range keys {
+		if redactSecrets {
+			flat[key] = RedactedValue
+			continue
+		}
+		value, err := m.Get(key)
+		if err != nil {
+			continue
+		}
+		flat[key] = exportValue(value)
+	}
+
+	m.mu.RLock()
+	if len(m.displayKeys) > 0 {
+		display := make(map[string]interface{}, len(flat))
+		for key, value := range flat {
+			display[m.displayKey(key)] = value
+		}
+		flat = display
+	}
+	m.mu.RUnlock()
+
+	nested, err := unflatten(flat)
+	if err != nil {
+		return nil, err
+	}
+	return configFormat.Marshal(nested)
+}
+
+func exportValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case time.Duration:
+		return v.String()
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return deepCopyValue(v)
+	}
+}
+
+// unflatten turns dotted keys back into nested maps. A key that is both a
+// scalar and the parent of other keys cannot be represented and is reported
+// as an error.
+func unflatten(flat map[string]interface{}) (map[string]interface{}, error) {
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make(map[string]interface{})
+	for _, key := range keys {
+		parts := strings.Split(key, ".")
+		current := result
+		for i, part := range parts[:len(parts)-1] {
+			existing, exists := current[part]
+			if !exists {
+				next := make(map[string]interface{})
+				current[part] = next
+				current = next
+				continue
+			}
+			next, ok := existing.(map[string]interface{})
+			if !ok {
+				return nil, &ConfigError{
+					Key: key,
+					Message: fmt.Sprintf("cannot nest under %q, which already holds a scalar value",
+						strings.Join(parts[:i+1], ".")),
+				}
+			}
+			current = next
+		}
+
+		leaf := parts[len(parts)-1]
+		if _, exists := current[leaf]; exists {
+			return nil, &ConfigError{
+				Key:     key,
+				Message: "key holds a scalar value but other keys are nested under it",
+			}
+		}
+		current[leaf] = flat[key]
+	}
+	return result, nil
+}
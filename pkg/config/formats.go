@@ -1,12 +1,16 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v3"
 )
 
@@ -17,17 +21,28 @@ type ConfigFormat interface {
 	Marshal(config map[string]interface{}) ([]byte, error)
 }
 
-type JSONFormat struct{}
+// JSONFormat reads JSON. In strict mode a key defined twice in the same
+// object is an error instead of the last one silently winning.
+type JSONFormat struct {
+	Strict bool
+}
 
 func (f *JSONFormat) Name() string { return "json" }
 
 func (f *JSONFormat) Extension() []string { return []string{".json"} }
 
+func (f *JSONFormat) SetStrict(strict bool) { f.Strict = strict }
+
 func (f *JSONFormat) Unmarshal(data []byte) (map[string]interface{}, error) {
 	var config map[string]interface{}
 	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("invlid JSON: %w", err)
 	}
+	if f.Strict {
+		if err := checkJSONDuplicates(data); err != nil {
+			return nil, err
+		}
+	}
 	return config, nil
 }
 
@@ -35,12 +50,23 @@ func (f *JSONFormat) Marshal(config map[string]interface{}) ([]byte, error) {
 	return json.MarshalIndent(config, "", " ")
 }
 
-type YAMLFormat struct{}
+// YAMLFormat reads YAML. The decoder already rejects a duplicated key; in
+// strict mode the error names the full dotted path of the key.
+type YAMLFormat struct {
+	Strict bool
+}
 
 func (f *YAMLFormat) Name() string        { return "yaml" }
 func (f *YAMLFormat) Extension() []string { return []string{".yaml", ".yml"} }
 
+func (f *YAMLFormat) SetStrict(strict bool) { f.Strict = strict }
+
 func (f *YAMLFormat) Unmarshal(data []byte) (map[string]interface{}, error) {
+	if f.Strict {
+		if err := checkYAMLDuplicates(data); err != nil {
+			return nil, err
+		}
+	}
 	var config map[string]interface{}
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("invalid YAML: %w", err)
@@ -59,42 +85,194 @@ func (f *TOMLFormat) Name() string { return "toml" }
 func (f *TOMLFormat) Extension() []string { return []string{".toml"} }
 
 func (f *TOMLFormat) Unmarshal(data []byte) (map[string]interface{}, error) {
-	return nil, fmt.Errorf("TOML format not yet implemented")
+	var config map[string]interface{}
+	if err := toml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return normalizeTOMLMap(config), nil
 }
 
 func (f *TOMLFormat) Marshal(config map[string]interface{}) ([]byte, error) {
-	return nil, fmt.Errorf("TOML format not yet implemented")
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(dropNilValues(config)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// normalizeTOMLMap turns arrays of tables, which the decoder returns as
+// []map[string]interface{}, into the []interface{} every other format
+// produces. Integers stay int64 and datetimes time.Time.
+func normalizeTOMLMap(config map[string]interface{}) map[string]interface{} {
+	for key, value := range config {
+		config[key] = normalizeTOMLValue(value)
+	}
+	return config
+}
+
+func normalizeTOMLValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return normalizeTOMLMap(v)
+	case []map[string]interface{}:
+		result := make([]interface{}, len(v))
+		for i, table := range v {
+			result[i] = normalizeTOMLMap(table)
+		}
+		return result
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeTOMLValue(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// dropNilValues copies config without nil values, which TOML has no way to
+// represent.
+func dropNilValues(config map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(config))
+	for key, value := range config {
+		switch v := value.(type) {
+		case nil:
+			continue
+		case map[string]interface{}:
+			result[key] = dropNilValues(v)
+		default:
+			result[key] = v
+		}
+	}
+	return result
 }
 
 type ConfigLoader struct {
 	formats []ConfigFormat
+	// ArrayStrategy controls how arrays combine when LoadDir or a
+	// multi-file FileSource merges several files.
+	ArrayStrategy ArrayMergeStrategy
+
+	logger     Logger
+	strict     bool
+	templating bool
+}
+
+// LoaderOption configures a ConfigLoader.
+type LoaderOption func(*ConfigLoader)
+
+// WithStrictParsing makes formats that support it reject duplicate keys.
+func WithStrictParsing() LoaderOption {
+	return func(l *ConfigLoader) {
+		l.SetStrictParsing(true)
+	}
+}
+
+// strictSetter is implemented by formats with a strict parsing mode.
+type strictSetter interface {
+	SetStrict(strict bool)
+}
+
+// SetStrictParsing turns strict parsing on or off for every registered
+// format that supports it.
+func (l *ConfigLoader) SetStrictParsing(strict bool) {
+	l.strict = strict
+	for _, format := range l.formats {
+		if setter, ok := format.(strictSetter); ok {
+			setter.SetStrict(strict)
+		}
+	}
+}
+
+// loggerSetter is implemented by formats that report problems, such as
+// duplicate keys, which don't fail the parse.
+type loggerSetter interface {
+	SetLogger(logger Logger)
+}
+
+// SetLogger passes logger to every registered format that wants one.
+func (l *ConfigLoader) SetLogger(logger Logger) {
+	l.logger = logger
+	for _, format := range l.formats {
+		if setter, ok := format.(loggerSetter); ok {
+			setter.SetLogger(logger)
+		}
+	}
 }
 
-func NewConfigLoader() *ConfigLoader {
+func NewConfigLoader(opts ...LoaderOption) *ConfigLoader {
 	loader := &ConfigLoader{
 		formats: make([]ConfigFormat, 0),
 	}
 
 	loader.RegisterFormat(&JSONFormat{})
 	loader.RegisterFormat(&YAMLFormat{})
+	loader.RegisterFormat(&TOMLFormat{})
+	loader.RegisterFormat(&HCLFormat{})
+	loader.RegisterFormat(&EnvFileFormat{})
+	loader.RegisterFormat(&INIFormat{})
+	loader.RegisterFormat(&PropertiesFormat{})
 
+	for _, opt := range opts {
+		opt(loader)
+	}
 	return loader
 
 }
 
+// RegisterFormat adds format, replacing any format registered under the
+// same name.
 func (l *ConfigLoader) RegisterFormat(format ConfigFormat) {
+	if setter, ok := format.(loggerSetter); ok && l.logger != nil {
+		setter.SetLogger(l.logger)
+	}
+	if setter, ok := format.(strictSetter); ok && l.strict {
+		setter.SetStrict(true)
+	}
+	for i, existing := range l.formats {
+		if existing.Name() == format.Name() {
+			l.formats[i] = format
+			return
+		}
+	}
 	l.formats = append(l.formats, format)
 }
 
+func (l *ConfigLoader) Format(name string) ConfigFormat {
+	for _, format := range l.formats {
+		if format.Name() == name {
+			return format
+		}
+	}
+	return nil
+}
+
 func (l *ConfigLoader) LoadFile(path string) (map[string]interface{}, error) {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	return l.Parse(path, data)
+}
+
+// Parse decodes data using the format registered for path's extension. A
+// path without an extension has its format sniffed from the content.
+func (l *ConfigLoader) Parse(path string, data []byte) (map[string]interface{}, error) {
+	data, err := l.render(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
 
 	format := l.detectFormat(path)
+	if format == nil && filepath.Ext(path) == "" {
+		_, config, err := l.DetectByContent(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		return config, nil
+	}
 	if format == nil {
-		return nil, fmt.Errorf("unsupported file format: %s", path)
+		return nil, fmt.Errorf("unsupported format for file %s", path)
 	}
 
 	config, err := format.Unmarshal(data)
@@ -102,30 +280,71 @@ func (l *ConfigLoader) LoadFile(path string) (map[string]interface{}, error) {
 		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
 	}
 	return config, nil
+}
+
+// LoadFileAs parses path with the named format whatever its extension.
+func (l *ConfigLoader) LoadFileAs(path, formatName string) (map[string]interface{}, error) {
+	format := l.Format(formatName)
+	if format == nil {
+		return nil, fmt.Errorf("unknown format %s", formatName)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if data, err = l.render(path, data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
 
+	config, err := format.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s as %s: %w", path, formatName, err)
+	}
+	return config, nil
 }
 
+// LoadDir merges every config file in dir in lexical filename order, so
+// 90-site.yaml overrides 10-base.yaml. Subdirectories, dotfiles and files
+// without a registered extension are skipped.
 func (l *ConfigLoader) LoadDir(dir string) (map[string]interface{}, error) {
-	files, err := ioutil.ReadDir(dir)
+	files, err := l.DirFiles(dir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read directory: %w", err)
+		return nil, err
 	}
 
 	result := make(map[string]interface{})
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		path := filepath.Join(dir, file.Name())
+	for _, path := range files {
 		config, err := l.LoadFile(path)
 		if err != nil {
 			return nil, fmt.Errorf("failed to load %s: %w", path, err)
 		}
-		result = mergeMaps(result, config)
+		result = mergeMapsWith(result, config, l.ArrayStrategy)
 	}
 	return result, nil
 }
 
+// DirFiles lists the files LoadDir would read, in merge order.
+func (l *ConfigLoader) DirFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() || !l.isConfigFile(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (l *ConfigLoader) isConfigFile(name string) bool {
+	return !strings.HasPrefix(name, ".") && l.detectFormat(name) != nil
+}
+
 func (l *ConfigLoader) detectFormat(path string) ConfigFormat {
 	ext := strings.ToLower(filepath.Ext(path))
 	for _, format := range l.formats {
@@ -138,3 +357,63 @@ func (l *ConfigLoader) detectFormat(path string) ConfigFormat {
 
 	return nil
 }
+
+// DetectByContent parses data with the first format that accepts it. The
+// formats the content looks like are tried first, then every registered
+// format in registration order, so the same input always resolves to the
+// same format.
+func (l *ConfigLoader) DetectByContent(data []byte) (ConfigFormat, map[string]interface{}, error) {
+	var candidates []ConfigFormat
+	tried := make(map[string]bool)
+	for _, name := range sniffFormatNames(data) {
+		if format := l.Format(name); format != nil && !tried[name] {
+			candidates = append(candidates, format)
+			tried[name] = true
+		}
+	}
+	for _, format := range l.formats {
+		if !tried[format.Name()] {
+			candidates = append(candidates, format)
+			tried[format.Name()] = true
+		}
+	}
+
+	var failed []string
+	for _, format := range candidates {
+		config, err := format.Unmarshal(data)
+		if err != nil {
+			failed = append(failed, format.Name())
+			continue
+		}
+		if l.logger != nil {
+			l.logger.Debug("detected config format from content",
+				"format", format.Name(), "rejected", strings.Join(failed, ","))
+		}
+		return format, config, nil
+	}
+	return nil, nil, fmt.Errorf("content matches none of the formats %s", strings.Join(failed, ", "))
+}
+
+// sniffFormatNames guesses formats from the first meaningful line: "{" is
+// JSON, "---" or "key:" is YAML and a "[section]" header is INI or TOML.
+func sniffFormatNames(data []byte) []string {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "{"):
+			return []string{"json"}
+		case strings.HasPrefix(line, "---"):
+			return []string{"yaml"}
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			return []string{"ini", "toml"}
+		}
+		if key, _, ok := strings.Cut(line, ":"); ok && !strings.ContainsAny(key, " =\t") {
+			return []string{"yaml"}
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/hcl/ast"
+	"github.com/hashicorp/hcl/hcl/parser"
+)
+
+// HCLFormat reads HCL. Attributes become scalars, blocks become nested maps
+// with each label adding a level, so `server "http" { port = 8080 }` sets
+// server.http.port, and a block repeated under the same path becomes an
+// array of maps.
+type HCLFormat struct{}
+
+func (f *HCLFormat) Name() string { return "hcl" }
+
+func (f *HCLFormat) Extension() []string { return []string{".hcl"} }
+
+func (f *HCLFormat) Unmarshal(data []byte) (config map[string]interface{}, err error) {
+	file, err := parser.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Literal decoding panics on values the scanner accepted but that don't
+	// fit, such as an integer overflowing int64.
+	defer func() {
+		if r := recover(); r != nil {
+			config, err = nil, fmt.Errorf("invalid HCL value: %v", r)
+		}
+	}()
+
+	list, ok := file.Node.(*ast.ObjectList)
+	if !ok {
+		return nil, fmt.Errorf("unexpected HCL document root %T", file.Node)
+	}
+	return decodeHCLObject(list)
+}
+
+func decodeHCLObject(list *ast.ObjectList) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	// Paths of blocks seen so far, and which of them already repeat.
+	blocks := make(map[string]bool)
+
+	for _, item := range list.Items {
+		keys := make([]string, len(item.Keys))
+		for i, key := range item.Keys {
+			keys[i] = fmt.Sprint(key.Token.Value())
+		}
+
+		value, err := decodeHCLValue(item.Val)
+		if err != nil {
+			return nil, err
+		}
+
+		parent := result
+		for _, key := range keys[:len(keys)-1] {
+			child, exists := parent[key]
+			if !exists {
+				child = make(map[string]interface{})
+				parent[key] = child
+			}
+			childMap, ok := child.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: block %q conflicts with an attribute", item.Pos(), key)
+			}
+			parent = childMap
+		}
+
+		last := keys[len(keys)-1]
+		_, isObject := item.Val.(*ast.ObjectType)
+		if !isObject || item.Assign.IsValid() {
+			parent[last] = value
+			continue
+		}
+
+		path := strings.Join(keys, ".")
+		repeated, seen := blocks[path]
+		switch {
+		case !seen:
+			parent[last] = value
+			blocks[path] = false
+		case !repeated:
+			parent[last] = []interface{}{parent[last], value}
+			blocks[path] = true
+		default:
+			parent[last] = append(parent[last].([]interface{}), value)
+		}
+	}
+	return result, nil
+}
+
+func decodeHCLValue(node ast.Node) (interface{}, error) {
+	switch n := node.(type) {
+	case *ast.LiteralType:
+		return n.Token.Value(), nil
+	case *ast.ObjectType:
+		return decodeHCLObject(n.List)
+	case *ast.ListType:
+		result := make([]interface{}, 0, len(n.List))
+		for _, elem := range n.List {
+			value, err := decodeHCLValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, value)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported HCL node %T", node.Pos(), node)
+	}
+}
+
+// Marshal writes nested maps as blocks and everything else as attributes.
+// Arrays whose elements are all maps are written as repeated blocks.
+func (f *HCLFormat) Marshal(config map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeHCLBody(&buf, config, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHCLBody(buf *bytes.Buffer, config map[string]interface{}, depth int) error {
+	indent := strings.Repeat("  ", depth)
+
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name := hclKey(key)
+		switch v := config[key].(type) {
+		case nil:
+			continue
+		case map[string]interface{}:
+			if err := writeHCLBlock(buf, indent, name, v, depth); err != nil {
+				return err
+			}
+		case []interface{}:
+			if blocks, ok := hclBlockList(v); ok {
+				for _, block := range blocks {
+					if err := writeHCLBlock(buf, indent, name, block, depth); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			literal, err := hclLiteral(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			fmt.Fprintf(buf, "%s%s = %s\n", indent, name, literal)
+		default:
+			literal, err := hclLiteral(v)
+			if err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+			fmt.Fprintf(buf, "%s%s = %s\n", indent, name, literal)
+		}
+	}
+	return nil
+}
+
+func writeHCLBlock(buf *bytes.Buffer, indent, name string, body map[string]interface{}, depth int) error {
+	fmt.Fprintf(buf, "%s%s {\n", indent, name)
+	if err := writeHCLBody(buf, body, depth+1); err != nil {
+		return err
+	}
+	fmt.Fprintf(buf, "%s}\n", indent)
+	return nil
+}
+
+func hclBlockList(values []interface{}) ([]map[string]interface{}, bool) {
+	if len(values) == 0 {
+		return nil, false
+	}
+	blocks := make([]map[string]interface{}, len(values))
+	for i, value := range values {
+		block, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		blocks[i] = block
+	}
+	return blocks, true
+}
+
+func hclLiteral(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return strconv.Quote(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			literal, err := hclLiteral(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = literal
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case fmt.Stringer:
+		return strconv.Quote(v.String()), nil
+	default:
+		return "", fmt.Errorf("cannot write %T as HCL", value)
+	}
+}
+
+// hclKey quotes key when it isn't a valid identifier.
+func hclKey(key string) string {
+	for i, r := range key {
+		valid := r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			(i > 0 && r >= '0' && r <= '9')
+		if !valid {
+			return strconv.Quote(key)
+		}
+	}
+	if key == "" {
+		return strconv.Quote(key)
+	}
+	return key
+}
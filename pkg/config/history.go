@@ -0,0 +1,73 @@
+package config
+
+const defaultHistoryDepth = 20
+
+// changeRing keeps the most recent changes to a single key.
+type changeRing struct {
+	entries []ConfigChange
+	next    int
+	full    bool
+}
+
+func (r *changeRing) add(change ConfigChange) {
+	r.entries[r.next] = change
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// list returns the recorded changes, oldest first.
+func (r *changeRing) list() []ConfigChange {
+	if !r.full {
+		return append([]ConfigChange(nil), r.entries[:r.next]...)
+	}
+	result := make([]ConfigChange, 0, len(r.entries))
+	result = append(result, r.entries[r.next:]...)
+	return append(result, r.entries[:r.next]...)
+}
+
+// SetHistoryDepth sets how many changes are kept per key. A depth of zero
+// disables history and discards what was recorded.
+func (m *ConfigManager) SetHistoryDepth(depth int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if depth < 0 {
+		depth = 0
+	}
+	m.historyDepth = depth
+	m.history = make(map[string]*changeRing)
+}
+
+// GetHistory returns the recorded changes to key, oldest first. Values of
+// secret keys are redacted.
+func (m *ConfigManager) GetHistory(key string) []ConfigChange {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ring, ok := m.history[m.canonicalKey(key)]
+	if !ok {
+		return nil
+	}
+	return ring.list()
+}
+
+// recordHistory appends changes to their keys' history. The caller must
+// hold m.mu.
+func (m *ConfigManager) recordHistory(changes ...ConfigChange) {
+	if m.historyDepth == 0 {
+		return
+	}
+	for _, change := range changes {
+		if m.isSecretKey(change.Key) {
+			change = redactChange(change)
+		}
+		ring, ok := m.history[change.Key]
+		if !ok {
+			ring = &changeRing{entries: make([]ConfigChange, m.historyDepth)}
+			m.history[change.Key] = ring
+		}
+		ring.add(change)
+	}
+}
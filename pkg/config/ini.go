@@ -0,0 +1,323 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// INIFormat reads INI files. A [section] becomes the first key segment, or
+// several when it is dotted like [server.http], and the keys below it its
+// children. Values are typed as bool, int or float when they look like one;
+// quote a value to keep it a string. A key repeated within a section keeps
+// its last value.
+type INIFormat struct {
+	logger Logger
+}
+
+func (f *INIFormat) Name() string { return "ini" }
+
+func (f *INIFormat) Extension() []string { return []string{".ini"} }
+
+func (f *INIFormat) SetLogger(logger Logger) { f.logger = logger }
+
+func (f *INIFormat) Unmarshal(data []byte) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	seen := make(map[string]int)
+	section := ""
+
+	for i, line := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		lineNo := i + 1
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section header", lineNo)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if section == "" {
+				return nil, fmt.Errorf("line %d: empty section name", lineNo)
+			}
+			continue
+		}
+
+		sep := strings.IndexAny(line, "=:")
+		if sep <= 0 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNo)
+		}
+		key := joinKey(section, strings.TrimSpace(line[:sep]))
+		raw := strings.TrimSpace(line[sep+1:])
+
+		if previous, duplicate := seen[key]; duplicate {
+			warnDuplicateKey(f.logger, "ini", key, previous, lineNo)
+		}
+		seen[key] = lineNo
+
+		setNestedValue(result, key, parseQuotedValue(raw))
+	}
+	return result, nil
+}
+
+// Marshal writes top-level scalars first and then one section per nested
+// map, using dotted section names for deeper maps.
+func (f *INIFormat) Marshal(config map[string]interface{}) ([]byte, error) {
+	var b strings.Builder
+	writeINISection(&b, "", config)
+	return []byte(b.String()), nil
+}
+
+func writeINISection(b *strings.Builder, section string, config map[string]interface{}) {
+	keys := sortedKeys(config)
+
+	if section != "" {
+		hasScalars := false
+		for _, key := range keys {
+			if _, isMap := config[key].(map[string]interface{}); !isMap && config[key] != nil {
+				hasScalars = true
+				break
+			}
+		}
+		if hasScalars {
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(b, "[%s]\n", section)
+		}
+	}
+
+	for _, key := range keys {
+		value := config[key]
+		if _, isMap := value.(map[string]interface{}); isMap || value == nil {
+			continue
+		}
+		fmt.Fprintf(b, "%s = %s\n", key, formatINIValue(value))
+	}
+	for _, key := range keys {
+		if child, isMap := config[key].(map[string]interface{}); isMap {
+			writeINISection(b, joinKey(section, key), child)
+		}
+	}
+}
+
+func formatINIValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		if _, isString := parseQuotedValue(s).(string); !isString || s != strings.TrimSpace(s) || s == "" {
+			return strconv.Quote(s)
+		}
+		return s
+	}
+	return fmt.Sprint(value)
+}
+
+// PropertiesFormat reads Java-style .properties files. Keys are already
+// dotted, so they map straight onto the config key space. Lines may use
+// "=", ":" or whitespace as the separator and end in a backslash to
+// continue. A repeated key keeps its last value.
+type PropertiesFormat struct {
+	logger Logger
+}
+
+func (f *PropertiesFormat) Name() string { return "properties" }
+
+func (f *PropertiesFormat) Extension() []string { return []string{".properties"} }
+
+func (f *PropertiesFormat) SetLogger(logger Logger) { f.logger = logger }
+
+func (f *PropertiesFormat) Unmarshal(data []byte) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	seen := make(map[string]int)
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+
+	for i := 0; i < len(lines); i++ {
+		lineNo := i + 1
+		line := strings.TrimLeft(lines[i], " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		for endsWithContinuation(line) && i+1 < len(lines) {
+			i++
+			line = line[:len(line)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+
+		rawKey, rawValue := splitProperty(line)
+		key, err := unescapeProperty(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if key == "" {
+			return nil, fmt.Errorf("line %d: empty key", lineNo)
+		}
+		value, err := unescapeProperty(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		if previous, duplicate := seen[key]; duplicate {
+			warnDuplicateKey(f.logger, "properties", key, previous, lineNo)
+		}
+		seen[key] = lineNo
+
+		setNestedValue(result, key, parseEnvValue(value))
+	}
+	return result, nil
+}
+
+func (f *PropertiesFormat) Marshal(config map[string]interface{}) ([]byte, error) {
+	flat := flattenMap(config)
+
+	var b strings.Builder
+	for _, key := range sortedKeys(flat) {
+		if flat[key] == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s=%s\n", escapeProperty(key, true), escapeProperty(fmt.Sprint(flat[key]), false))
+	}
+	return []byte(b.String()), nil
+}
+
+// endsWithContinuation reports whether line ends in an odd number of
+// backslashes.
+func endsWithContinuation(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+// splitProperty splits at the first unescaped "=", ":" or whitespace, and
+// skips whitespace and one separator after the key.
+func splitProperty(line string) (string, string) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte("=: \t\f", line[i]) >= 0 {
+			end = i
+			break
+		}
+	}
+
+	rest := strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	return line[:end], rest
+}
+
+func unescapeProperty(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+4 >= len(s) {
+				return "", fmt.Errorf("truncated unicode escape")
+			}
+			code, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid unicode escape \\u%s", s[i+1:i+5])
+			}
+			b.WriteRune(rune(code))
+			i += 4
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+func escapeProperty(s string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '=', ':':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case '#', '!':
+			if i == 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		case ' ':
+			if isKey || i == 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		default:
+			if r >= utf8.RuneSelf && r <= 0xFFFF {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// parseQuotedValue keeps a double- or single-quoted value as the string
+// inside the quotes and types anything else with parseEnvValue.
+func parseQuotedValue(raw string) interface{} {
+	if len(raw) >= 2 {
+		if raw[0] == '"' && raw[len(raw)-1] == '"' {
+			if unquoted, err := strconv.Unquote(raw); err == nil {
+				return unquoted
+			}
+		}
+		if raw[0] == '\'' && raw[len(raw)-1] == '\'' {
+			return raw[1 : len(raw)-1]
+		}
+	}
+	return parseEnvValue(raw)
+}
+
+func warnDuplicateKey(logger Logger, format, key string, previous, line int) {
+	if logger == nil {
+		return
+	}
+	logger.Warn("duplicate key, last value wins",
+		"format", format, "key", key, "first_line", previous, "line", line)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
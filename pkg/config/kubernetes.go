@@ -0,0 +1,209 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	kubernetesDataLink     = "..data"
+	defaultKubernetesPoll  = time.Second
+	kubernetesKeySeparator = "__"
+)
+
+// KubernetesDirSource reads a ConfigMap or Secret volume mount, where every
+// file is one key and its content the value. "__" in a file name nests the
+// key, so "database__port" becomes "database.port". Kubernetes updates a
+// mount by atomically swapping the ..data symlink, which inotify watches on
+// the files themselves never see, so Watch polls the link target instead.
+type KubernetesDirSource struct {
+	dir          string
+	secretsDir   string
+	priority     int
+	pollInterval time.Duration
+
+	mu         sync.RWMutex
+	secretKeys map[string]bool
+}
+
+func NewKubernetesDirSource(dir string, priority int) *KubernetesDirSource {
+	return &KubernetesDirSource{
+		dir:          dir,
+		priority:     priority,
+		pollInterval: defaultKubernetesPoll,
+		secretKeys:   make(map[string]bool),
+	}
+}
+
+// WithSecretsDir also reads a Secret mount from dir. Its keys are merged over
+// the ConfigMap keys and their values are marked secret.
+func (k *KubernetesDirSource) WithSecretsDir(dir string) *KubernetesDirSource {
+	k.secretsDir = dir
+	return k
+}
+
+// WithPollInterval sets how often Watch checks the mounts for a new ..data
+// target.
+func (k *KubernetesDirSource) WithPollInterval(interval time.Duration) *KubernetesDirSource {
+	if interval > 0 {
+		k.pollInterval = interval
+	}
+	return k
+}
+
+func (k *KubernetesDirSource) Name() string {
+	return "kubernetes"
+}
+
+func (k *KubernetesDirSource) Kind() ConfigSource {
+	return SourceFile
+}
+
+func (k *KubernetesDirSource) Priority() int {
+	return k.priority
+}
+
+func (k *KubernetesDirSource) IsSecretKey(key string) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.secretKeys[key]
+}
+
+func (k *KubernetesDirSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	result := make(map[string]interface{})
+	if _, err := readKubernetesDir(k.dir, result); err != nil {
+		return nil, err
+	}
+
+	secretKeys := make(map[string]bool)
+	if k.secretsDir != "" {
+		keys, err := readKubernetesDir(k.secretsDir, result)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			secretKeys[key] = true
+		}
+	}
+
+	k.mu.Lock()
+	k.secretKeys = secretKeys
+	k.mu.Unlock()
+	return result, nil
+}
+
+func (k *KubernetesDirSource) Watch(ctx context.Context, onChange func(ConfigChange)) error {
+	previous, err := k.fingerprint()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(k.pollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				current, err := k.fingerprint()
+				if err != nil || current == previous {
+					continue
+				}
+				config, err := k.Load(ctx)
+				if err != nil {
+					continue
+				}
+				previous = current
+				onChange(ConfigChange{
+					Key:       k.dir,
+					NewValue:  config,
+					Source:    SourceFile,
+					Timestamp: time.Now(),
+				})
+			}
+		}
+	}()
+	return nil
+}
+
+// fingerprint identifies the current contents of the mounts: the ..data
+// target when Kubernetes manages the directory, otherwise the name, size and
+// modification time of every file.
+func (k *KubernetesDirSource) fingerprint() (string, error) {
+	var parts []string
+	for _, dir := range []string{k.dir, k.secretsDir} {
+		if dir == "" {
+			continue
+		}
+		if target, err := os.Readlink(filepath.Join(dir, kubernetesDataLink)); err == nil {
+			parts = append(parts, dir+"="+target)
+			continue
+		}
+
+		names, err := kubernetesKeyFiles(dir)
+		if err != nil {
+			return "", err
+		}
+		for _, name := range names {
+			info, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, fmt.Sprintf("%s/%s:%d:%d", dir, name, info.Size(), info.ModTime().UnixNano()))
+		}
+	}
+	return strings.Join(parts, "\n"), nil
+}
+
+// readKubernetesDir sets a key in result for every file in dir and returns
+// the keys it set.
+func readKubernetesDir(dir string, result map[string]interface{}) ([]string, error) {
+	names, err := kubernetesKeyFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filepath.Join(dir, name), err)
+		}
+		key := strings.ReplaceAll(name, kubernetesKeySeparator, ".")
+		setNestedValue(result, key, parseRawValue(strings.TrimRight(string(data), "\r\n")))
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// kubernetesKeyFiles lists the key files in dir in name order. Dot entries,
+// such as ..data and the timestamped directories behind it, are skipped.
+func kubernetesKeyFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		// Keys are usually symlinks into ..data, so stat the target.
+		info, err := os.Stat(filepath.Join(dir, entry.Name()))
+		if err != nil || info.IsDir() {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names, nil
+}
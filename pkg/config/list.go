@@ -0,0 +1,59 @@
+package config
+
+import (
+	"sort"
+	"time"
+)
+
+// KeyInfo describes one key as List reports it.
+type KeyInfo struct {
+	Key string
+	// Value is RedactedValue for secret keys; Get resolves them.
+	Value     interface{}
+	Source    ConfigSource
+	IsDefault bool
+	IsSecret  bool
+	IsDynamic bool
+	// Timestamp is when the value last changed.
+	Timestamp time.Time
+}
+
+// Keys returns the keys at or below prefix in sorted order, spelled as
+// they were first seen. An empty prefix returns every key.
+func (m *ConfigManager) Keys(prefix string) []string {
+	infos := m.List(prefix)
+	keys := make([]string, len(infos))
+	for i, info := range infos {
+		keys[i] = info.Key
+	}
+	return keys
+}
+
+// List describes the keys at or below prefix, sorted by key.
+func (m *ConfigManager) List(prefix string) []KeyInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	prefix = m.canonicalKey(prefix)
+	infos := make([]KeyInfo, 0, len(m.values))
+	for key, value := range m.values {
+		if !keyUnder(prefix, key) {
+			continue
+		}
+		info := KeyInfo{
+			Key:       m.displayKey(key),
+			Value:     deepCopyValue(value.Value),
+			Source:    value.Source,
+			IsDefault: value.IsDefault,
+			IsSecret:  value.IsSecret,
+			IsDynamic: value.IsDynamic,
+			Timestamp: value.Timestamp,
+		}
+		if value.IsSecret {
+			info.Value = RedactedValue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key < infos[j].Key })
+	return infos
+}
@@ -4,25 +4,87 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 type ConfigManager struct {
-	sources     []ConfigSources
-	values      map[string]*ConfigValue
-	defaults    map[string]interface{}
-	validators  map[string][]ConfigValidator
-	watchers    map[string][]ConfigWatcher
-	schema      *ConfigSchema
-	mu          sync.RWMutex
-	onChange    chan ConfigChange
-	ctx         context.Context
-	cancel      context.CancelFunc
-	logger      Logger
-	secretStore SecretStore
+	sources      []ConfigSources
+	values       map[string]*ConfigValue
+	defaults     map[string]interface{}
+	overrides    map[string]*ConfigValue
+	validators   map[string][]ConfigValidator
+	watchers     map[string][]*watcherRegistration
+	nextWatchID  uint64
+	nextBatchID  uint64
+	schema       *ConfigSchema
+	patterns     map[*SchemaNode]*regexp.Regexp
+	strictSchema bool
+	loader       *ConfigLoader
+	expandEnv    bool
+	emptyAsUnset bool
+
+	arrayStrategy ArrayMergeStrategy
+
+	normalizeKeys bool
+	displayKeys   map[string]string
+	loadSpellings map[string]keySpelling
+
+	sourceData   map[ConfigSources]map[string]interface{}
+	sourceHealth map[ConfigSources]*SourceHealth
+	watches      map[ConfigSources]sourceWatch
+
+	aliases            map[string]keyAlias
+	deprecatedSeen     map[string]string
+	deprecationWarned  map[string]bool
+	strictDeprecations bool
+	history            map[string]*changeRing
+	historyDepth       int
+	mu                 sync.RWMutex
+	// readMu serializes reading sources and recording what was read,
+	// which happens partly outside mu, so an older read can't be recorded
+	// over a newer one.
+	readMu   sync.Mutex
+	onChange chan ConfigChange
+	// publishMu is held by publish while it may wait for room in
+	// onChange, so Close can't close the channel under it.
+	publishMu          sync.RWMutex
+	publishClosed      bool
+	pending            *changeQueue
+	bufferSize         int
+	overflow           OverflowPolicy
+	blockTimeout       time.Duration
+	droppedChanges     uint64
+	droppedDeliveries  uint64
+	loadCount          uint64
+	validationFailures uint64
+	crossValidators    []CrossValidator
+	schemaValidators   map[string][]ConfigValidator
+	useSchemaValidator bool
+	dynamic            *DynamicConfigManager
+	validationHooks    []func(key string, value interface{}, source string) error
+	lastLoad           time.Time
+	lastLoadErr        error
+	subscribers        map[uint64]*subscription
+	nextSubID          uint64
+	subMu              sync.Mutex
+	fanOutDone         chan struct{}
+	closed             bool
+	ctx                context.Context
+	cancel             context.CancelFunc
+	logger             Logger
+	secretStore        SecretStore
+	secretsDisabled    error
+	markedSecrets      map[string]bool
+	audit              AuditSink
 }
 
 type Logger interface {
@@ -39,27 +101,67 @@ type SecretStore interface {
 	ListSecrets() ([]string, error)
 }
 
-func NewConfigManager(logger Logger, secretStore SecretStore) *ConfigManager {
+func NewConfigManager(logger Logger, secretStore SecretStore, opts ...ManagerOption) *ConfigManager {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &ConfigManager{
+	m := &ConfigManager{
 		sources:     make([]ConfigSources, 0),
 		values:      make(map[string]*ConfigValue),
 		defaults:    make(map[string]interface{}),
+		overrides:   make(map[string]*ConfigValue),
 		validators:  make(map[string][]ConfigValidator),
-		watchers:    make(map[string][]ConfigWatcher),
-		onChange:    make(chan ConfigChange, 100),
-		ctx:         ctx,
-		cancel:      cancel,
-		logger:      logger,
-		secretStore: secretStore,
+		watchers:    make(map[string][]*watcherRegistration),
+		pending:     newChangeQueue(),
+		subscribers: make(map[uint64]*subscription),
+		loader:      NewConfigLoader(),
+		displayKeys: make(map[string]string),
+
+		loadSpellings: make(map[string]keySpelling),
+		sourceData:    make(map[ConfigSources]map[string]interface{}),
+		sourceHealth:  make(map[ConfigSources]*SourceHealth),
+		watches:       make(map[ConfigSources]sourceWatch),
+
+		history:      make(map[string]*changeRing),
+		historyDepth: defaultHistoryDepth,
+
+		aliases:           make(map[string]keyAlias),
+		deprecatedSeen:    make(map[string]string),
+		deprecationWarned: make(map[string]bool),
+		fanOutDone:        make(chan struct{}),
+		ctx:               ctx,
+		cancel:            cancel,
+		logger:            logger,
+		secretStore:       secretStore,
+		markedSecrets:     make(map[string]bool),
+
+		bufferSize:   defaultChangeBufferSize,
+		overflow:     OverflowDrop,
+		blockTimeout: defaultBlockTimeout,
 	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	m.onChange = make(chan ConfigChange, m.bufferSize)
+	m.loader.SetLogger(logger)
+
+	go m.fanOut()
+	return m
 }
 
 func (m *ConfigManager) AddSource(source ConfigSources) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.insertSource(source)
+	return nil
+}
+
+// insertSource adds source keeping m.sources ordered by descending
+// priority. The caller must hold m.mu.
+func (m *ConfigManager) insertSource(source ConfigSources) {
+	if setter, ok := source.(loggerSetter); ok {
+		setter.SetLogger(m.logger)
+	}
 	m.sources = append(m.sources, source)
 
 	for i := len(m.sources) - 1; i > 0; i-- {
@@ -67,12 +169,12 @@ func (m *ConfigManager) AddSource(source ConfigSources) error {
 			m.sources[i], m.sources[i-1] = m.sources[i-1], m.sources[i]
 		}
 	}
-	return nil
 }
 
 func (m *ConfigManager) SetDefault(key string, value interface{}) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	key = m.normalizeAndRemember(key)
 	m.defaults[key] = value
 
 	if _, exists := m.values[key]; !exists {
@@ -87,26 +189,54 @@ func (m *ConfigManager) SetDefault(key string, value interface{}) {
 
 }
 
+// EnableEmptyAsUnset makes empty strings and empty slices from sources count
+// as unset, so a lower-priority or default value is kept instead.
+func (m *ConfigManager) EnableEmptyAsUnset(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.emptyAsUnset = enabled
+}
+
 func (m *ConfigManager) Get(key string) (interface{}, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	return m.GetContext(context.Background(), key)
+}
 
+// GetContext is Get with a context, which carries the caller recorded in
+// audit events for secret keys (see WithAuditCaller).
+func (m *ConfigManager) GetContext(ctx context.Context, key string) (interface{}, error) {
+	m.mu.RLock()
+	key = m.canonicalKey(key)
 	value, exists := m.values[key]
 	if !exists {
+		m.mu.RUnlock()
 		return nil, &ConfigError{
 			Key:     key,
 			Message: "key not found",
 		}
 	}
+	// Callers get their own copy of maps and slices so mutating a result
+	// can't change what other readers see.
+	result, isSecret, stored := deepCopyValue(value.Value), value.IsSecret, isStoredSecret(value)
+	store, disabled := m.secretStore, m.secretsDisabled
+	// Secret stores may be remote, so they are not called under the lock.
+	m.mu.RUnlock()
 
-	if value.IsSecret && m.secretStore != nil {
-		secretValue, err := m.secretStore.GetSecret(key)
+	if isSecret && store != nil {
+		secretValue, err := readSecret(ctx, store, key)
+		m.auditResolve(ctx, key, key, err)
 		if err == nil {
 			return secretValue, nil
 		}
+		if stored {
+			return nil, &ConfigError{Key: key, Message: "failed to get secret", Err: err}
+		}
 		m.logger.Warn("failed to get secret", "key", key, "error", err)
 	}
-	return value.Value, nil
+
+	if hasSecretRef(result) {
+		return m.resolveSecretRefs(ctx, store, disabled, key, result.(string))
+	}
+	return result, nil
 }
 
 func (m *ConfigManager) GetString(key string) (string, error) {
@@ -151,6 +281,44 @@ func (m *ConfigManager) GetInt(key string) (int, error) {
 	}
 }
 
+func (m *ConfigManager) GetInt64(key string) (int64, error) {
+	value, err := m.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return toInt64(key, value)
+}
+
+func (m *ConfigManager) GetUint(key string) (uint, error) {
+	u, err := m.GetUint64(key)
+	if err != nil {
+		return 0, err
+	}
+	if u > math.MaxUint {
+		return 0, &ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("value %d overflows uint", u),
+		}
+	}
+	return uint(u), nil
+}
+
+func (m *ConfigManager) GetUint64(key string) (uint64, error) {
+	value, err := m.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return toUint64(key, value)
+}
+
+func (m *ConfigManager) GetTime(key string) (time.Time, error) {
+	value, err := m.Get(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return toTime(key, value)
+}
+
 func (m *ConfigManager) GetBool(key string) (bool, error) {
 	value, err := m.Get(key)
 	if err != nil {
@@ -171,22 +339,15 @@ func (m *ConfigManager) GetDuration(key string) (time.Duration, error) {
 	if err != nil {
 		return 0, err
 	}
+	return toDuration(key, value)
+}
 
-	switch v := value.(type) {
-	case time.Duration:
-		return v, nil
-	case string:
-		return time.ParseDuration(v)
-	case int:
-		return time.Duration(v) * time.Second, nil
-	case float64:
-		return time.Duration(v) * time.Second, nil
-	default:
-		return 0, &ConfigError{
-			Key:     key,
-			Message: "cannot convert to duration",
-		}
+func (m *ConfigManager) GetSizeBytes(key string) (int64, error) {
+	value, err := m.Get(key)
+	if err != nil {
+		return 0, err
 	}
+	return toSizeBytes(key, value)
 }
 
 func (m *ConfigManager) GetFloat(key string) (float64, error) {
@@ -240,34 +401,227 @@ func (m *ConfigManager) GetStringSlice(key string) ([]string, error) {
 	}
 }
 
+// GetStringMap returns the nested map stored under key, whether it was set
+// as a single map value or only exists as flattened child keys.
+func (m *ConfigManager) GetStringMap(key string) (map[string]interface{}, error) {
+	m.mu.RLock()
+	present := m.hasKeyOrChildren(m.canonicalKey(key))
+	m.mu.RUnlock()
+	if !present {
+		return nil, &ConfigError{
+			Key:     key,
+			Message: "key not found",
+		}
+	}
+
+	result, ok := m.subtree(key).(map[string]interface{})
+	if !ok {
+		return nil, &ConfigError{
+			Key:     key,
+			Message: "value is not a map",
+		}
+	}
+	return result, nil
+}
+
+func (m *ConfigManager) GetStringMapString(key string) (map[string]string, error) {
+	values, err := m.GetStringMap(key)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]string, len(values))
+	for name, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			return nil, &ConfigError{
+				Key:     joinKey(key, name),
+				Message: "map contains non-string",
+			}
+		}
+		result[name] = str
+	}
+	return result, nil
+}
+
+// Set stores value for key unless the key is currently held by a source of
+// higher precedence than source, in which case ErrLowerPriority is returned.
+// Use SetOverride to bypass the precedence check. The value of a secret key
+// is written to the secret store, when there is one, and the store's error
+// is returned if that fails.
 func (m *ConfigManager) Set(key string, value interface{},
 	source ConfigSource, dynamic bool) error {
+	return m.set(key, value, source, dynamic, false, "")
+}
+
+func (m *ConfigManager) SetOverride(key string, value interface{},
+	source ConfigSource, dynamic bool) error {
+	return m.set(key, value, source, dynamic, true, "")
+}
+
+// setStaged is Set with the resulting change marked as part of stage.
+func (m *ConfigManager) setStaged(key string, value interface{},
+	source ConfigSource, dynamic bool, stage ChangeStage) error {
+	return m.set(key, value, source, dynamic, false, stage)
+}
+
+func (m *ConfigManager) set(key string, value interface{},
+	source ConfigSource, dynamic bool, override bool, stage ChangeStage) error {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	if m.closed {
+		m.mu.Unlock()
+		return &ConfigError{Key: key, Message: "cannot set", Err: ErrClosed}
+	}
+	m.normalizeAndRemember(key)
+	key = m.canonicalKey(key)
+
+	if err := m.checkPrecedence(key, source, override); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+
+	if err := m.runSchemaValidators(key, value); err != nil {
+		m.mu.Unlock()
+		return err
+	}
+
+	hooks := m.copyValidationHooks()
+	secretStore, secretValue, storeSecret := m.secretWrite(key, value)
+	if hooks != nil || storeSecret {
+		check := hookCheck{key: key, value: value, source: source, secret: m.isSecretKey(key)}
+		m.mu.Unlock()
+		if err := runValidationHooks(hooks, check); err != nil {
+			return err
+		}
+		if storeSecret {
+			if err := writeSecret(secretStore, key, secretValue); err != nil {
+				return err
+			}
+		}
+
+		// The manager may have changed while the hooks ran or the secret
+		// was written.
+		m.mu.Lock()
+		if m.closed {
+			m.mu.Unlock()
+			return &ConfigError{Key: key, Message: "cannot set", Err: ErrClosed}
+		}
+		if err := m.checkPrecedence(key, source, override); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+	}
+
+	change := m.store(key, value, source, m.runtimePriority(key, source, override))
+	change.Stage = stage
+	if dynamic {
+		m.values[key].IsDynamic = true
+	}
+	m.mu.Unlock()
+
+	// Notified synchronously so an OverflowBlock policy pushes back on the
+	// caller.
+	m.notifyWatchers(change)
+	return nil
+}
+
+// sourcePriorities ranks runtime sets on the same scale as
+// ConfigSources.Priority: InitConfig loads files at 50 and the environment
+// at 75, and configctl's flags sit at 100.
+var sourcePriorities = [...]int{
+	SourceDefault:     0,
+	SourceFile:        50,
+	SourceEnvironment: 75,
+	SourceFlag:        100,
+	SourceDynamic:     200,
+	SourceSecret:      200,
+}
+
+func sourcePriority(source ConfigSource) int {
+	if source < 0 || int(source) >= len(sourcePriorities) {
+		return sourcePriorities[SourceDynamic]
+	}
+	return sourcePriorities[source]
+}
+
+// runtimePriority is the priority a value set from source is stored with.
+// An override takes that of the value it replaces when that is higher, so
+// it keeps winning until something of still higher priority arrives. The
+// caller must hold m.mu.
+func (m *ConfigManager) runtimePriority(key string, source ConfigSource, override bool) int {
+	priority := sourcePriority(source)
+	if oldValue, exists := m.values[key]; exists && override && oldValue.Priority > priority {
+		priority = oldValue.Priority
+	}
+	return priority
+}
+
+func (m *ConfigManager) checkPrecedence(key string, source ConfigSource, override bool) error {
+	oldValue, exists := m.values[key]
+	if exists && !override && !oldValue.IsDefault && sourcePriority(source) < oldValue.Priority {
+		m.logger.Debug("ignoring lower-priority set", "key", key,
+			"source", source, "existing", oldValue.Source)
+		return &ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("cannot set from %s", source),
+			Err:     ErrLowerPriority,
+		}
+	}
+	return nil
+}
 
+// secretWrite reports whether value for key belongs in the secret store
+// rather than in m.values, and returns the store and the string to write.
+// The caller must hold m.mu.
+func (m *ConfigManager) secretWrite(key string, value interface{}) (SecretStore, string, bool) {
+	if m.secretStore == nil || !m.isSecretKey(key) {
+		return nil, "", false
+	}
+	str, ok := value.(string)
+	return m.secretStore, str, ok
+}
+
+// writeSecret stores value for key in store. It must be called without
+// m.mu held, since secret stores may be remote.
+func writeSecret(store SecretStore, key, value string) error {
+	if err := store.SetSecret(key, value); err != nil {
+		return &ConfigError{Key: key, Message: "failed to store secret", Err: err}
+	}
+	return nil
+}
+
+// isStoredSecret reports whether value only marks a secret that was set at
+// runtime and lives in the secret store.
+func isStoredSecret(value *ConfigValue) bool {
+	return value.IsSecret && value.Value == RedactedValue
+}
+
+// store writes a runtime value for key and returns the resulting change.
+// The caller must hold m.mu.
+func (m *ConfigManager) store(key string, value interface{}, source ConfigSource, priority int) ConfigChange {
 	oldValue, exists := m.values[key]
 
 	newValue := &ConfigValue{
-		Value:     value,
+		Value:     deepCopyValue(value),
 		Source:    source,
+		Priority:  priority,
 		IsSet:     true,
 		IsDefault: false,
+		IsDynamic: m.isDynamicKey(key),
 		Timestamp: time.Now(),
 	}
 
 	if m.isSecretKey(key) {
 		newValue.IsSecret = true
-		if m.secretStore != nil {
-			strValue, ok := value.(string)
-			if ok {
-				if err := m.secretStore.SetSecret(key, strValue); err != nil {
-					m.logger.Error("failed to set secret", "key", key, "error", err)
-				}
-			}
+		if _, _, stored := m.secretWrite(key, value); stored {
+			// The caller has written it to the secret store, which Get
+			// reads it from.
+			newValue.Value = RedactedValue
 		}
 	}
 
 	m.values[key] = newValue
+	m.overrides[key] = newValue
 
 	change := ConfigChange{
 		Key:       key,
@@ -280,13 +634,17 @@ func (m *ConfigManager) Set(key string, value interface{},
 	if exists {
 		change.OldValue = oldValue.Value
 	}
-	go m.notifyWatchers(change)
-	return nil
+	if newValue.IsSecret || (exists && oldValue.IsSecret) {
+		change = redactChange(change)
+	}
+	m.recordHistory(change)
+	return change
 }
 
 func (m *ConfigManager) AddDefault(key string, value interface{}) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	key = m.normalizeAndRemember(key)
 	m.defaults[key] = value
 
 	if _, exists := m.values[key]; !exists {
@@ -303,6 +661,9 @@ func (m *ConfigManager) AddDefault(key string, value interface{}) {
 func (m *ConfigManager) AddValidator(key string, validator ConfigValidator) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if m.normalizeKeys {
+		key = normalizeKey(key)
+	}
 
 	if m.validators[key] == nil {
 		m.validators[key] = make([]ConfigValidator, 0)
@@ -310,33 +671,161 @@ func (m *ConfigManager) AddValidator(key string, validator ConfigValidator) {
 	m.validators[key] = append(m.validators[key], validator)
 }
 
-func (m *ConfigManager) AddWatcher(key string, watcher ConfigWatcher) {
+type watcherRegistration struct {
+	id      string
+	key     string
+	owner   string
+	watcher ConfigWatcher
+	active  atomic.Bool
+}
+
+// AddWatcher registers watcher for changes to key and returns an ID that can
+// be passed to RemoveWatcher.
+func (m *ConfigManager) AddWatcher(key string, watcher ConfigWatcher) string {
+	return m.AddOwnedWatcher("", key, watcher)
+}
+
+// AddOwnedWatcher is AddWatcher with an owner tag, so that every watcher
+// registered by one component (e.g. a plugin ID) can be removed at once with
+// RemoveWatchersForOwner.
+func (m *ConfigManager) AddOwnedWatcher(owner, key string, watcher ConfigWatcher) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.normalizeKeys && !strings.HasSuffix(key, ".*") {
+		key = normalizeKey(key)
+	} else if m.normalizeKeys {
+		key = normalizeKey(strings.TrimSuffix(key, ".*")) + ".*"
+	}
+
+	m.nextWatchID++
+	reg := &watcherRegistration{
+		id:      fmt.Sprintf("watcher-%d", m.nextWatchID),
+		key:     key,
+		owner:   owner,
+		watcher: watcher,
+	}
+	reg.active.Store(true)
+
+	m.watchers[key] = append(m.watchers[key], reg)
+	return reg.id
+}
+
+func (m *ConfigManager) RemoveWatcher(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := m.removeWatchers(func(reg *watcherRegistration) bool {
+		return reg.id == id
+	})
+	if removed == 0 {
+		return fmt.Errorf("watcher %s not found", id)
+	}
+	return nil
+}
+
+func (m *ConfigManager) RemoveWatchersForOwner(owner string) int {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if m.watchers[key] == nil {
-		m.watchers[key] = make([]ConfigWatcher, 0)
+	return m.removeWatchers(func(reg *watcherRegistration) bool {
+		return reg.owner == owner
+	})
+}
+
+func (m *ConfigManager) removeWatchers(match func(*watcherRegistration) bool) int {
+	removed := 0
+	for key, regs := range m.watchers {
+		kept := regs[:0]
+		for _, reg := range regs {
+			if match(reg) {
+				reg.active.Store(false)
+				removed++
+				continue
+			}
+			kept = append(kept, reg)
+		}
+		if len(kept) == 0 {
+			delete(m.watchers, key)
+		} else {
+			m.watchers[key] = kept
+		}
 	}
-	m.watchers[key] = append(m.watchers[key], watcher)
+	return removed
 }
 
 func (m *ConfigManager) SetSchema(schema *ConfigSchema) error {
+	patterns, err := compileSchema(schema)
+	if err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	m.schema = schema
+	m.patterns = patterns
+	if m.useSchemaValidator {
+		m.registerSchemaValidators()
+	}
 
-	for key, node := range schema.Properties {
-		if node.Default != nil {
-			m.defaults[key] = node.Default
+	for key, value := range schemaDefaults(schema) {
+		m.defaults[key] = value
+		if _, exists := m.values[key]; !exists {
+			m.values[key] = &ConfigValue{
+				Value:     value,
+				Source:    SourceDefault,
+				IsSet:     true,
+				IsDefault: true,
+				Timestamp: time.Now(),
+			}
 		}
 	}
 	return nil
 }
 
 func (m *ConfigManager) Load(ctx context.Context) error {
+	m.readMu.Lock()
+	defer m.readMu.Unlock()
+
+	m.mu.RLock()
+	closed, sources := m.closed, append([]ConfigSources(nil), m.sources...)
+	m.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	reads := readSources(ctx, sources)
+
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	var loadErr MultiError
+	for _, read := range reads {
+		loadErr.Add(m.recordRead(read))
+	}
+
+	changes, err := m.rebuild(&loadErr, false)
+	m.mu.Unlock()
+
+	for _, change := range changes {
+		m.notifyWatchers(change)
+	}
+	return err
+}
+
+// rebuild recomputes every value from the defaults, the last data read from
+// each source and the runtime overrides, then validates the result. Errors
+// already collected in loadErr are returned alongside validation errors.
+// With rejectInvalid, a result with any error is discarded and the previous
+// values are kept. The caller must hold m.mu and send the returned
+// changes to watchers once it has released it.
+func (m *ConfigManager) rebuild(loadErr *MultiError, rejectInvalid bool) ([]ConfigChange, error) {
+	previous := m.values
+	m.values = make(map[string]*ConfigValue, len(previous))
+	m.deprecatedSeen = make(map[string]string)
+	m.loadSpellings = make(map[string]keySpelling)
 
 	for key, defaultValue := range m.defaults {
 		m.values[key] = &ConfigValue{
@@ -349,90 +838,307 @@ func (m *ConfigManager) Load(ctx context.Context) error {
 	}
 
 	for _, source := range m.sources {
-		config, err := source.Load(ctx)
-		if err != nil {
-			m.logger.Warn("Failed to load from source", "source", source.Name(), "error", err)
+		config, ok := m.sourceData[source]
+		if !ok {
+			continue
+		}
+		if err := m.applyConfig(config, source); err != nil {
+			loadErr.Add(fmt.Errorf("source %s: %w", source.Name(), err))
+		}
+	}
+
+	// Values set at runtime compete by priority like any source, winning
+	// ties since they are the most recent, so a reload of lower-priority
+	// sources keeps them and a higher-priority value that arrives later
+	// hides them.
+	for key, value := range m.overrides {
+		if existing, ok := m.values[key]; ok && !existing.IsDefault && existing.Priority > value.Priority {
 			continue
 		}
-		m.applyConfig(config, source.Priority())
+		m.values[key] = value
 	}
 
-	if err := m.ValidateAll(); err != nil {
-		return fmt.Errorf("configuration validation failed: %w", err)
+	var err error
+	report := m.validateLocked()
+	m.logWarnings(report)
+	if validationErr := report.Err(false); validationErr != nil {
+		m.validationFailures++
+		err = fmt.Errorf("configuration validation failed: %w", validationErr)
+		if loadErr.HasErrors() {
+			loadErr.Add(err)
+		}
 	}
-	return nil
+	if loadErr.HasErrors() {
+		err = loadErr
+	}
+	m.loadCount++
+	if err != nil && rejectInvalid {
+		m.values = previous
+		m.lastLoadErr = err
+		return nil, err
+	}
+
+	changes := diffValues(previous, m.values)
+	m.recordHistory(changes...)
+	m.lastLoadErr = err
+	if err == nil {
+		m.lastLoad = time.Now()
+	}
+	return changes, err
 }
 
-func (m *ConfigManager) applyConfig(config map[string]interface{}, priority int) {
-	var flatten func(prefix string, value interface{})
-	flatten = func(prefix string, value interface{}) {
-		switch v := value.(type) {
-		case map[string]interface{}:
-			for k, val := range v {
-				newPrefix := prefix
+// diffValues returns one ConfigChange per key that was added, removed or
+// changed between old and new. Removed keys carry a nil NewValue, and the
+// values of secret keys are redacted.
+func diffValues(old, new map[string]*ConfigValue) []ConfigChange {
+	now := time.Now()
+	var changes []ConfigChange
 
-				if prefix == "" {
-					newPrefix = k
-				} else {
-					newPrefix = prefix + "." + k
-				}
-				flatten(newPrefix, val)
-			}
-		default:
-			existing, exists := m.values[prefix]
-			if !exists || priority > int(existing.Source) {
-				m.values[prefix] = &ConfigValue{
-					Value:     v,
-					Source:    ConfigSource(priority),
-					IsSet:     true,
-					IsDefault: false,
-					Timestamp: time.Now(),
-				}
-				if m.isSecretKey(prefix) {
-					m.values[prefix].IsSecret = true
-				}
+	for key, newValue := range new {
+		oldValue, exists := old[key]
+		if exists && reflect.DeepEqual(oldValue.Value, newValue.Value) {
+			continue
+		}
+		change := ConfigChange{
+			Key:       key,
+			NewValue:  newValue.Value,
+			Source:    newValue.Source,
+			Timestamp: now,
+		}
+		if exists {
+			change.OldValue = oldValue.Value
+		}
+		if newValue.IsSecret || (exists && oldValue.IsSecret) {
+			change = redactChange(change)
+		}
+		changes = append(changes, change)
+	}
 
-				if m.isDynamicKey(prefix) {
-					m.values[prefix].IsDynamic = true
-				}
-			}
+	for key, oldValue := range old {
+		if _, exists := new[key]; exists {
+			continue
+		}
+		change := ConfigChange{
+			Key:       key,
+			OldValue:  oldValue.Value,
+			Source:    oldValue.Source,
+			Timestamp: now,
+		}
+		if oldValue.IsSecret {
+			change = redactChange(change)
 		}
+		changes = append(changes, change)
 	}
-	flatten("", config)
 
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
 }
 
-func (m *ConfigManager) ValidateAll() error {
-	var multiErr MultiError
-	for key, value := range m.values {
-		if !value.IsSet {
-			continue
+// SourceKinder can be implemented by a source whose Name doesn't match one
+// of the ConfigSource names.
+type SourceKinder interface {
+	Kind() ConfigSource
+}
+
+// SecretKeySource can be implemented by a source that knows some of the
+// keys it provides are secret, whatever the schema says.
+type SecretKeySource interface {
+	IsSecretKey(key string) bool
+}
+
+// sourceKind maps a source to the ConfigSource recorded on its values. The
+// source's Priority is tracked separately on ConfigValue.
+func sourceKind(source ConfigSources) ConfigSource {
+	if kinder, ok := source.(SourceKinder); ok {
+		return kinder.Kind()
+	}
+	for kind, name := range sourceNames {
+		if name == source.Name() {
+			return ConfigSource(kind)
 		}
-		validators := m.validators[key]
-		for _, validator := range validators {
-			if err := validator.Validate(key, value.Value); err != nil {
-				multiErr.Add(&ConfigError{
-					Key:     key,
-					Message: "validation failed",
-					Err:     err,
-				})
+	}
+	return SourceDynamic
+}
+
+func (m *ConfigManager) applyConfig(config map[string]interface{}, source ConfigSources) error {
+	sourceName, kind, priority := source.Name(), sourceKind(source), source.Priority()
+	secrets, _ := source.(SecretKeySource)
+
+	var multiErr MultiError
+	flat := flattenMap(config)
+	if m.normalizeKeys {
+		flat = m.normalizeFlat(flat, sourceName, &multiErr)
+	}
+	m.applyAliases(flat, sourceName)
+
+	for key, v := range flat {
+		if m.expandEnv {
+			expanded, err := expandEnvValues(key, v)
+			if err != nil {
+				multiErr.Add(err)
+				continue
 			}
+			v = expanded
 		}
 
-		if m.schema != nil {
-			if err := m.validateAgainstSchema(key, value.Value); err != nil {
+		node := m.schemaNode(key)
+		if node != nil {
+			coerced, err := coerceValue(key, node, v)
+			if err != nil {
 				multiErr.Add(err)
+				continue
 			}
+			v = coerced
+		}
+
+		if isEmptyValue(v) && (m.emptyAsUnset || (node != nil && node.TreatEmptyAsUnset)) {
+			m.logger.Debug("ignoring empty value", "key", key, "source", sourceName)
+			continue
 		}
 
+		existing, exists := m.values[key]
+		if exists && !existing.IsDefault {
+			// Sources are applied highest priority first, so the existing
+			// array usually wins and this one goes underneath it.
+			base, overlay := v, existing.Value
+			if priority > existing.Priority {
+				base, overlay = existing.Value, v
+			}
+			if merged, ok := mergeArrays(m.arrayStrategyFor(key), base, overlay); ok {
+				existing.Value = merged
+				continue
+			}
+		}
+		if !exists || existing.IsDefault || priority > existing.Priority {
+			m.values[key] = &ConfigValue{
+				Value:     v,
+				Source:    kind,
+				Priority:  priority,
+				IsSet:     true,
+				IsDefault: false,
+				Timestamp: time.Now(),
+			}
+			if m.isSecretKey(key) || (secrets != nil && secrets.IsSecretKey(key)) {
+				m.values[key].IsSecret = true
+			}
+
+			if m.isDynamicKey(key) {
+				m.values[key].IsDynamic = true
+			}
+		}
 	}
+
 	if multiErr.HasErrors() {
 		return &multiErr
 	}
-
 	return nil
 }
 
+// Validate runs the same checks as ValidateAll and returns the warnings
+// along with the errors. Warnings are only reported for values that were
+// set explicitly, not for defaults.
+func (m *ConfigManager) Validate() ValidationReport {
+	m.mu.RLock()
+	report := m.validateLocked()
+	hooks, checks := m.validationHookChecks()
+	store, keys := m.secretRotationTargets()
+	m.mu.RUnlock()
+
+	for _, check := range checks {
+		if err := runValidationHooks(hooks, check); err != nil {
+			report.Errors.Add(err)
+		}
+	}
+	warnSecretRotation(&report, store, keys, m.logger)
+	return report
+}
+
+// validationHookChecks returns the validation hooks and every set value
+// for them to check, sorted by key. The caller must hold m.mu.
+func (m *ConfigManager) validationHookChecks() ([]validationHook, []hookCheck) {
+	hooks := m.copyValidationHooks()
+	if hooks == nil {
+		return nil, nil
+	}
+	checks := make([]hookCheck, 0, len(m.values))
+	for key, value := range m.values {
+		if value.IsSet && !isStoredSecret(value) {
+			checks = append(checks, hookCheck{key: key, value: value.Value, source: value.Source, secret: value.IsSecret || m.isSecretKey(key)})
+		}
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].key < checks[j].key })
+	return hooks, checks
+}
+
+// validateLocked is Validate for callers that already hold m.mu, such as
+// rebuild. It leaves out the validation hooks, which must run unlocked,
+// and the secret rotation warnings, which need a round trip to the secret
+// store per key.
+func (m *ConfigManager) validateLocked() ValidationReport {
+	var report ValidationReport
+	for key, value := range m.values {
+		m.validateValue(&report, key, value)
+	}
+	m.validateCross(&report, nil, m.validationValue)
+	m.validateRequired(&report.Errors)
+	m.validateDeprecations(&report.Errors)
+	return report
+}
+
+// validateValue runs the per-key validators and schema checks for one
+// value. The caller must hold m.mu.
+func (m *ConfigManager) validateValue(report *ValidationReport, key string, value *ConfigValue) {
+	// Secrets set at runtime were validated by Set and only a marker is
+	// kept here.
+	if !value.IsSet || isStoredSecret(value) {
+		return
+	}
+	for _, validator := range m.validators[key] {
+		if err := validator.Validate(key, value.Value); err != nil {
+			if warning, ok := asWarning(err); ok {
+				if !value.IsDefault {
+					message := warning.Message
+					if value.IsSecret {
+						message = "secret value looks suspicious"
+					}
+					report.warn(key, message)
+				}
+				continue
+			}
+			validationErr := validatorError(key, validator, err)
+			if value.IsSecret {
+				report.Errors.Add(secretValidationError(key, validationErr))
+				continue
+			}
+			report.Errors.Add(validationErr)
+		}
+	}
+
+	if m.schema != nil {
+		if err := m.validateAgainstSchema(key, value.Value); err != nil {
+			if value.IsSecret {
+				err = secretValidationError(key, err)
+			}
+			report.Errors.Add(err)
+		}
+		if m.strictSchema && !value.IsDefault {
+			if err := m.validateKnownKey(key, value.Value); err != nil {
+				if value.IsSecret {
+					err = secretValidationError(key, err)
+				}
+				report.Errors.Add(err)
+			}
+		}
+	}
+}
+
+func (m *ConfigManager) ValidateAll() error {
+	report := m.Validate()
+	return report.Err(false)
+}
+
 func (m *ConfigManager) validateAgainstSchema(
 	key string, value interface{},
 ) error {
@@ -447,12 +1153,25 @@ func (m *ConfigManager) validateAgainstSchema(
 			return nil
 		}
 		if i == len(parts)-1 {
-			return m.validateNode(node, value)
+			err := m.validateNode(node, value)
+			if configErr, ok := err.(*ConfigError); ok && configErr.Key == "" {
+				configErr.Key = key
+			}
+			if err != nil {
+				return err
+			}
+			return runNamedValidators(key, node, value)
 		}
 		if node.Properties == nil {
+			if node.Type == "object" || node.AdditionalProperties != nil {
+				// Free-form objects and additionalProperties are checked
+				// by validateKnownKey in strict mode.
+				return nil
+			}
 			return &ConfigError{
 				Key:     key,
 				Message: "schema mismatch: expected object",
+				Code:    CodeTypeMismatch,
 			}
 
 		}
@@ -463,50 +1182,101 @@ func (m *ConfigManager) validateAgainstSchema(
 
 func (m *ConfigManager) validateNode(node *SchemaNode, value interface{}) error {
 	valueType := reflect.TypeOf(value)
+	if valueType == nil {
+		return &ConfigError{
+			Message: fmt.Sprintf("expected %s, got null", node.Type),
+			Code:    CodeTypeMismatch,
+		}
+	}
 	switch node.Type {
 	case "string":
 		if valueType.Kind() != reflect.String {
 			return &ConfigError{
 				Message: fmt.Sprintf("expected string, got %s", valueType.Kind()),
+				Code:    CodeTypeMismatch,
 			}
 		}
-		// strValue := value.(string)
-		if node.Pattern != "" {
-
+		if regex := m.patterns[node]; regex != nil && !regex.MatchString(reflect.ValueOf(value).String()) {
+			return &ConfigError{
+				Message: fmt.Sprintf("value %q does not match pattern %s", value, node.Pattern),
+				Code:    CodePatternMismatch,
+			}
+		}
+		length := utf8.RuneCountInString(reflect.ValueOf(value).String())
+		if node.MinLength != nil && length < *node.MinLength {
+			return &ConfigError{
+				Message: fmt.Sprintf("length %d is less than minLength %d", length, *node.MinLength),
+				Code:    CodeInvalidLength,
+			}
+		}
+		if node.MaxLength != nil && length > *node.MaxLength {
+			return &ConfigError{
+				Message: fmt.Sprintf("length %d is greater than maxLength %d", length, *node.MaxLength),
+				Code:    CodeInvalidLength,
+			}
 		}
-	case "integer":
-		if valueType.Kind() != reflect.Int && valueType.Kind() != reflect.Float64 {
+	case "integer", "number":
+		num, ok := toNumber(value)
+		if !ok {
 			return &ConfigError{
-				Message: fmt.Sprintf("expected integer, got %s", valueType.Kind()),
+				Message: fmt.Sprintf("expected %s, got %T", node.Type, value),
+				Code:    CodeTypeMismatch,
+			}
+		}
+		if node.Type == "integer" && !num.isInt {
+			return &ConfigError{
+				Message: fmt.Sprintf("expected integer, got %v", value),
+				Code:    CodeTypeMismatch,
 			}
 		}
 		if node.Min != nil {
-			min, _ := node.Min.(float64)
-			if value.(float64) < min {
+			min, ok := toNumber(node.Min)
+			if !ok {
 				return &ConfigError{
-					Message: fmt.Sprintf("value %f is less than min %f", value.(float64), min),
+					Message: fmt.Sprintf("schema min %v is not numeric", node.Min),
+					Code:    CodeInvalidSchema,
+				}
+			}
+			if num.compare(min) < 0 {
+				return &ConfigError{
+					Message: fmt.Sprintf("value %v is less than min %v", value, node.Min),
+					Code:    CodeOutOfRange,
 				}
 			}
 		}
 		if node.Max != nil {
-			max, _ := node.Max.(float64)
-			if value.(float64) > max {
+			max, ok := toNumber(node.Max)
+			if !ok {
 				return &ConfigError{
-					Message: fmt.Sprintf("value %v is greater then max %v", value, max),
+					Message: fmt.Sprintf("schema max %v is not numeric", node.Max),
+					Code:    CodeInvalidSchema,
 				}
 			}
-
-		}
-	case "number":
-		if valueType.Kind() != reflect.Float64 && valueType.Kind() != reflect.Int {
-			return &ConfigError{
-				Message: fmt.Sprintf("expected number, got %s", valueType.Kind()),
+			if num.compare(max) > 0 {
+				return &ConfigError{
+					Message: fmt.Sprintf("value %v is greater than max %v", value, node.Max),
+					Code:    CodeOutOfRange,
+				}
 			}
 		}
 	case "array":
 		if valueType.Kind() != reflect.Slice && valueType.Kind() != reflect.Array {
 			return &ConfigError{
-				Message: fmt.Sprint("expected array, got %s", valueType.Kind()),
+				Message: fmt.Sprintf("expected array, got %s", valueType.Kind()),
+				Code:    CodeTypeMismatch,
+			}
+		}
+		items := reflect.ValueOf(value).Len()
+		if node.MinItems != nil && items < *node.MinItems {
+			return &ConfigError{
+				Message: fmt.Sprintf("%d items is fewer than minItems %d", items, *node.MinItems),
+				Code:    CodeInvalidLength,
+			}
+		}
+		if node.MaxItems != nil && items > *node.MaxItems {
+			return &ConfigError{
+				Message: fmt.Sprintf("%d items is more than maxItems %d", items, *node.MaxItems),
+				Code:    CodeInvalidLength,
 			}
 		}
 		if node.Items != nil {
@@ -515,103 +1285,171 @@ func (m *ConfigManager) validateNode(node *SchemaNode, value interface{}) error
 				if err := m.validateNode(node.Items, slice.Index(i).Interface()); err != nil {
 					return &ConfigError{
 						Message: fmt.Sprintf("item %d: %v", i, err),
+						Code:    errorCode(err),
 					}
 				}
 			}
 		}
+	case "duration":
+		if _, err := toDuration("", value); err != nil {
+			return &ConfigError{
+				Message: fmt.Sprintf("expected duration, got %v", value),
+				Code:    CodeTypeMismatch,
+			}
+		}
 	case "boolean":
 		if valueType.Kind() != reflect.Bool {
 			return &ConfigError{
 				Message: fmt.Sprintf("expected boolean, got %s", valueType.Kind()),
+				Code:    CodeTypeMismatch,
 			}
 		}
 	case "object":
 		if valueType.Kind() != reflect.Map {
 			return &ConfigError{
 				Message: fmt.Sprintf("expected object, got %s", valueType.Kind()),
+				Code:    CodeTypeMismatch,
 			}
 		}
+	}
 
-		if len(node.Enum) > 0 {
-			found := false
-			for _, enumValue := range node.Enum {
-				if reflect.DeepEqual(enumValue, value) {
-					found = true
-					break
-				}
-			}
-			if !found {
-				return &ConfigError{
-					Message: fmt.Sprintf("value %v is not in enum values", value),
-				}
-			}
+	if len(node.Enum) > 0 && !enumContains(node.Enum, value) {
+		return &ConfigError{
+			Message: fmt.Sprintf("value %v is not one of %v", value, node.Enum),
+			Code:    CodeNotAllowed,
 		}
 	}
-	return nil
+	return checkFormat(node, value)
 
 }
 
 func (m *ConfigManager) notifyWatchers(change ConfigChange) {
 	m.mu.RLock()
-	watchers := m.watchers[change.Key]
+	if m.closed {
+		m.mu.RUnlock()
+		return
+	}
+	// Changes are redacted where they are built; this also covers keys
+	// marked secret after the change was made.
+	if m.isSecretKey(change.Key) {
+		change = redactChange(change)
+	}
+	regs := m.watchersFor(change.Key)
 	m.mu.RUnlock()
-	for _, watcher := range watchers {
-		go watcher.OnConfigChange(change)
+
+	for _, reg := range regs {
+		go func(reg *watcherRegistration) {
+			// The watcher may have been removed after this notification
+			// was dispatched.
+			if reg.active.Load() {
+				reg.watcher.OnConfigChange(change)
+			}
+		}(reg)
 	}
 
-	select {
-	case m.onChange <- change:
-	default:
-		m.logger.Warn("Config change channel full, dropping change", "key", change.Key)
+	m.publish(change)
+}
+
+// Close stops all source watchers, delivers any pending change notifications,
+// closes every Watch subscription and leaves the manager read-only. Calling
+// Close more than once is safe.
+func (m *ConfigManager) Close() error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.closed = true
+	sources := append([]ConfigSources(nil), m.sources...)
+	secretStore := m.secretStore
+	m.mu.Unlock()
+
+	m.publishMu.Lock()
+	m.publishClosed = true
+	m.publishMu.Unlock()
+
+	m.cancel()
+	<-m.fanOutDone
+	close(m.onChange)
+
+	err := closeSources(sources)
+	if closer, ok := secretStore.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil {
+			var multiErr MultiError
+			multiErr.Add(err)
+			multiErr.Add(fmt.Errorf("failed to close secret store: %w", closeErr))
+			return &multiErr
+		}
+	}
+	return err
+}
+
+// MarkSecret treats key as secret from now on, as a Secret schema node
+// would: reads go through the secret store and exports redact it.
+func (m *ConfigManager) MarkSecret(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key = m.canonicalKey(key)
+	m.markedSecrets[key] = true
+	if value, exists := m.values[key]; exists {
+		value.IsSecret = true
 	}
 }
 
-func (m *ConfigManager) Watch() <-chan ConfigChange {
-	return m.onChange
+// secretValidationError replaces err, a validation error for a secret key
+// whose message may quote the value. Only its code is kept.
+func secretValidationError(key string, err error) error {
+	return &ConfigError{Key: key, Message: "secret value is not valid", Code: errorCode(err)}
 }
 
 func (m *ConfigManager) isSecretKey(key string) bool {
-	if m.schema == nil {
-		return false
+	if m.markedSecrets[key] {
+		return true
 	}
-	parts := strings.Split(key, ".")
-	currentNode := m.schema.Properties
+	node := m.schemaNode(key)
+	return node != nil && node.Secret
+}
 
-	for i, part := range parts {
-		node, exists := currentNode[part]
-		if !exists {
-			return false
-		}
-		if i == len(parts)-1 {
-			return node.Secret
-		}
-		if node.Properties == nil {
-			return false
-		}
+// IsDynamic reports whether key may change at runtime: its schema node is
+// marked Dynamic or its current value was set as dynamic.
+func (m *ConfigManager) IsDynamic(key string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key = m.canonicalKey(key)
+	if value, ok := m.values[key]; ok && value.IsDynamic {
+		return true
 	}
-	return false
-
+	return m.isDynamicKey(key)
 }
 
 func (m *ConfigManager) isDynamicKey(key string) bool {
+	node := m.schemaNode(key)
+	return node != nil && node.Dynamic
+}
+
+// schemaNode returns the schema node describing key, following
+// AdditionalProperties for undeclared children, or nil if there is none.
+func (m *ConfigManager) schemaNode(key string) *SchemaNode {
 	if m.schema == nil {
-		return false
+		return nil
 	}
 	parts := strings.Split(key, ".")
 	currentNode := m.schema.Properties
+	var parent *SchemaNode
 
 	for i, part := range parts {
 		node, exists := currentNode[part]
 		if !exists {
-			return false
+			if parent == nil || parent.AdditionalProperties == nil {
+				return nil
+			}
+			node = parent.AdditionalProperties
 		}
 		if i == len(parts)-1 {
-			return node.Dynamic
-		}
-		if node.Properties == nil {
-			return false
+			return node
 		}
+		parent = node
 		currentNode = node.Properties
 	}
-	return false
+	return nil
 }
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ArrayMergeStrategy decides how an array supplied by a later file or a
+// higher-priority source combines with one that is already present.
+type ArrayMergeStrategy int
+
+const (
+	// ArrayReplace keeps only the winning array.
+	ArrayReplace ArrayMergeStrategy = iota
+	// ArrayAppend keeps the lower-precedence elements first, followed by
+	// the winning ones.
+	ArrayAppend
+	// ArrayUnion is ArrayAppend without duplicate elements.
+	ArrayUnion
+)
+
+var arrayStrategyNames = map[string]ArrayMergeStrategy{
+	"replace": ArrayReplace,
+	"append":  ArrayAppend,
+	"union":   ArrayUnion,
+}
+
+func (s ArrayMergeStrategy) String() string {
+	for name, strategy := range arrayStrategyNames {
+		if strategy == s {
+			return name
+		}
+	}
+	return fmt.Sprintf("strategy(%d)", int(s))
+}
+
+func ParseArrayMergeStrategy(name string) (ArrayMergeStrategy, error) {
+	strategy, ok := arrayStrategyNames[name]
+	if !ok {
+		return ArrayReplace, fmt.Errorf("unknown array merge strategy %q", name)
+	}
+	return strategy, nil
+}
+
+// SetArrayMergeStrategy sets how arrays from different sources combine for
+// keys whose schema node doesn't set mergeStrategy.
+func (m *ConfigManager) SetArrayMergeStrategy(strategy ArrayMergeStrategy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.arrayStrategy = strategy
+}
+
+// arrayStrategyFor returns the strategy for key. The caller must hold m.mu.
+func (m *ConfigManager) arrayStrategyFor(key string) ArrayMergeStrategy {
+	if node := m.schemaNode(key); node != nil && node.MergeStrategy != "" {
+		if strategy, err := ParseArrayMergeStrategy(node.MergeStrategy); err == nil {
+			return strategy
+		}
+	}
+	return m.arrayStrategy
+}
+
+// mergeMapsWith is mergeMaps with arrays combined by strategy instead of
+// replaced.
+func mergeMapsWith(dst, src map[string]interface{}, strategy ArrayMergeStrategy) map[string]interface{} {
+	if dst == nil {
+		dst = make(map[string]interface{})
+	}
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			dst[k] = mergeMapsWith(dstMap, srcMap, strategy)
+			continue
+		}
+		if merged, ok := mergeArrays(strategy, dst[k], v); ok {
+			dst[k] = merged
+			continue
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// mergeArrays combines base and overlay when both are arrays and strategy
+// isn't ArrayReplace. ok is false when the overlay should simply win.
+func mergeArrays(strategy ArrayMergeStrategy, base, overlay interface{}) ([]interface{}, bool) {
+	if strategy == ArrayReplace {
+		return nil, false
+	}
+	baseItems, baseOK := toInterfaceSlice(base)
+	overlayItems, overlayOK := toInterfaceSlice(overlay)
+	if !baseOK || !overlayOK {
+		return nil, false
+	}
+
+	merged := make([]interface{}, 0, len(baseItems)+len(overlayItems))
+	for _, item := range append(baseItems, overlayItems...) {
+		if strategy == ArrayUnion && containsValue(merged, item) {
+			continue
+		}
+		merged = append(merged, item)
+	}
+	return merged, true
+}
+
+func toInterfaceSlice(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case []interface{}:
+		return append([]interface{}(nil), v...), true
+	case []string:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = item
+		}
+		return items, true
+	default:
+		return nil, false
+	}
+}
+
+func containsValue(items []interface{}, value interface{}) bool {
+	for _, item := range items {
+		if reflect.DeepEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,266 @@
+package config
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// FuncValidator adapts a plain function to ConfigValidator.
+type FuncValidator struct {
+	Name string
+	fn   func(key string, value interface{}) error
+}
+
+func NewFuncValidator(name string, fn func(key string, value interface{}) error) *FuncValidator {
+	return &FuncValidator{Name: name, fn: fn}
+}
+
+func (v *FuncValidator) Validate(key string, value interface{}) error {
+	return v.fn(key, value)
+}
+
+var (
+	namedValidatorsMu sync.RWMutex
+	namedValidators   = map[string]ConfigValidator{
+		"pem-certificate":    NewFuncValidator("pem-certificate", validatePEMCertificate),
+		"writable-directory": NewFuncValidator("writable-directory", validateWritableDirectory),
+		"cron-expression":    NewFuncValidator("cron-expression", validateCronExpression),
+	}
+)
+
+// RegisterNamedValidator makes validator available to schema nodes that
+// list name in their "validators". Names must be unique.
+func RegisterNamedValidator(name string, validator ConfigValidator) error {
+	if name == "" {
+		return fmt.Errorf("validator name is empty")
+	}
+	if validator == nil {
+		return fmt.Errorf("validator %q is nil", name)
+	}
+	namedValidatorsMu.Lock()
+	defer namedValidatorsMu.Unlock()
+	if _, exists := namedValidators[name]; exists {
+		return fmt.Errorf("validator %q is already registered", name)
+	}
+	namedValidators[name] = validator
+	return nil
+}
+
+func GetNamedValidator(name string) (ConfigValidator, bool) {
+	namedValidatorsMu.RLock()
+	defer namedValidatorsMu.RUnlock()
+	validator, ok := namedValidators[name]
+	return validator, ok
+}
+
+// NamedValidators returns the registered validator names in sorted order.
+func NamedValidators() []string {
+	namedValidatorsMu.RLock()
+	defer namedValidatorsMu.RUnlock()
+	names := make([]string, 0, len(namedValidators))
+	for name := range namedValidators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// runNamedValidators checks value against the validators node lists by
+// name. Warnings are left to validators registered with AddValidator.
+func runNamedValidators(key string, node *SchemaNode, value interface{}) error {
+	for _, name := range node.Validators {
+		validator, ok := GetNamedValidator(name)
+		if !ok {
+			return &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("unknown validator %q", name),
+				Code:    CodeInvalidSchema,
+			}
+		}
+		if err := validator.Validate(key, value); err != nil {
+			if _, ok := asWarning(err); ok {
+				continue
+			}
+			return &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("validator %s failed", name),
+				Err:     err,
+				Code:    validatorCode(validator, err),
+			}
+		}
+	}
+	return nil
+}
+
+// validatePEMCertificate accepts either PEM text or the path of a PEM file
+// holding at least one parseable certificate. Empty values are left to
+// required checks.
+func validatePEMCertificate(key string, value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s: expected string, got %T", key, value)
+	}
+	if str == "" {
+		return nil
+	}
+	data := []byte(str)
+	if !strings.Contains(str, "-----BEGIN") {
+		var err error
+		if data, err = os.ReadFile(str); err != nil {
+			return fmt.Errorf("%s: cannot read certificate: %w", key, err)
+		}
+	}
+
+	found := false
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return fmt.Errorf("%s: invalid certificate: %w", key, err)
+		}
+		found = true
+	}
+	if !found {
+		return fmt.Errorf("%s: no PEM certificate found", key)
+	}
+	return nil
+}
+
+// validateWritableDirectory checks that value names an existing directory
+// a file can be created in.
+func validateWritableDirectory(key string, value interface{}) error {
+	dir, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s: expected string, got %T", key, value)
+	}
+	if dir == "" {
+		return nil
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: %s is not a directory", key, dir)
+	}
+	probe, err := os.CreateTemp(dir, ".bindxdb-write-check-*")
+	if err != nil {
+		return fmt.Errorf("%s: directory %s is not writable: %w", key, dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    []string
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12,
+		names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7,
+		names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+var cronDescriptors = map[string]bool{
+	"@yearly": true, "@annually": true, "@monthly": true, "@weekly": true,
+	"@daily": true, "@midnight": true, "@hourly": true,
+}
+
+// validateCronExpression accepts the five-field cron syntax with lists,
+// ranges, steps and month or weekday names, plus descriptors such as
+// @daily and "@every <duration>".
+func validateCronExpression(key string, value interface{}) error {
+	expr, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s: expected string, got %T", key, value)
+	}
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@") {
+		if every, ok := strings.CutPrefix(expr, "@every "); ok {
+			if _, err := toDuration(key, strings.TrimSpace(every)); err != nil {
+				return fmt.Errorf("%s: invalid @every interval: %w", key, err)
+			}
+			return nil
+		}
+		if !cronDescriptors[strings.ToLower(expr)] {
+			return fmt.Errorf("%s: unknown cron descriptor %q", key, expr)
+		}
+		return nil
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return fmt.Errorf("%s: cron expression needs %d fields, got %d", key, len(cronFields), len(fields))
+	}
+	for i, field := range fields {
+		if err := cronFields[i].check(field); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (f cronField) check(field string) error {
+	for _, item := range strings.Split(field, ",") {
+		rangePart, step, hasStep := strings.Cut(item, "/")
+		if hasStep {
+			n, err := strconv.Atoi(step)
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid step %q in %s field", step, f.name)
+			}
+		}
+		if rangePart == "*" {
+			continue
+		}
+		low, high, isRange := strings.Cut(rangePart, "-")
+		start, err := f.value(low)
+		if err != nil {
+			return err
+		}
+		if isRange {
+			end, err := f.value(high)
+			if err != nil {
+				return err
+			}
+			if end < start {
+				return fmt.Errorf("range %s is backwards in %s field", rangePart, f.name)
+			}
+		}
+	}
+	return nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q in %s field", s, f.name)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("%d is out of range [%d, %d] in %s field", n, f.min, f.max, f.name)
+	}
+	return n, nil
+}
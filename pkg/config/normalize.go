@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+type keySpelling struct {
+	key    string
+	source string
+}
+
+// EnableKeyNormalization makes keys case-insensitive and tolerant of "/"
+// and ":" as separators: every key is stored and looked up in lower case
+// with dots. The first spelling seen for a key is kept for Export. Keys
+// already registered are re-keyed when normalization is turned on.
+func (m *ConfigManager) EnableKeyNormalization(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.normalizeKeys = enabled
+	if !enabled {
+		return
+	}
+
+	defaults := make(map[string]interface{}, len(m.defaults))
+	for key, value := range m.defaults {
+		defaults[m.normalizeAndRemember(key)] = value
+	}
+	m.defaults = defaults
+
+	values := make(map[string]*ConfigValue, len(m.values))
+	for key, value := range m.values {
+		values[m.normalizeAndRemember(key)] = value
+	}
+	m.values = values
+
+	overrides := make(map[string]*ConfigValue, len(m.overrides))
+	for key, value := range m.overrides {
+		overrides[normalizeKey(key)] = value
+	}
+	m.overrides = overrides
+
+	validators := make(map[string][]ConfigValidator, len(m.validators))
+	for key, list := range m.validators {
+		normalized := normalizeKey(key)
+		validators[normalized] = append(validators[normalized], list...)
+	}
+	m.validators = validators
+	if m.useSchemaValidator {
+		m.registerSchemaValidators()
+	}
+
+	aliases := make(map[string]keyAlias, len(m.aliases))
+	for oldKey, alias := range m.aliases {
+		alias.newKey = normalizeKey(alias.newKey)
+		aliases[normalizeKey(oldKey)] = alias
+	}
+	m.aliases = aliases
+}
+
+func normalizeKey(key string) string {
+	key = strings.ToLower(strings.TrimSpace(key))
+	key = strings.NewReplacer("/", ".", ":", ".").Replace(key)
+	parts := strings.Split(key, ".")
+	kept := parts[:0]
+	for _, part := range parts {
+		if part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, ".")
+}
+
+// normalizeAndRemember normalizes key when normalization is enabled and
+// records its original spelling for display. The caller must hold m.mu.
+func (m *ConfigManager) normalizeAndRemember(key string) string {
+	if !m.normalizeKeys {
+		return key
+	}
+	normalized := normalizeKey(key)
+	if _, seen := m.displayKeys[normalized]; !seen && normalized != key {
+		m.displayKeys[normalized] = key
+	}
+	return normalized
+}
+
+// displayKey returns the spelling key was first written with.
+func (m *ConfigManager) displayKey(key string) string {
+	if original, ok := m.displayKeys[key]; ok {
+		return original
+	}
+	return key
+}
+
+// normalizeFlat re-keys a flattened source map. Keys that only differ in
+// case or separators, within this source or against an earlier source in
+// the same Load, are reported instead of silently overwriting each other.
+func (m *ConfigManager) normalizeFlat(flat map[string]interface{}, sourceName string,
+	errs *MultiError) map[string]interface{} {
+	normalized := make(map[string]interface{}, len(flat))
+	for key, value := range flat {
+		canonical := m.normalizeAndRemember(key)
+		if previous, seen := m.loadSpellings[canonical]; seen && previous.key != key {
+			errs.Add(&ConfigError{
+				Key: canonical,
+				Message: fmt.Sprintf("key %q from %s conflicts with %q from %s",
+					key, sourceName, previous.key, previous.source),
+			})
+			continue
+		}
+		m.loadSpellings[canonical] = keySpelling{key: key, source: sourceName}
+		normalized[canonical] = value
+	}
+	return normalized
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultChangeBufferSize = 100
+	defaultBlockTimeout     = time.Second
+)
+
+// OverflowPolicy decides what happens to a change notification when the
+// change channel is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop discards the change and counts it in DroppedChanges.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock makes the writer wait up to the block timeout for room
+	// before dropping the change.
+	OverflowBlock
+	// OverflowCoalesce keeps only the latest pending change per key until
+	// the reader catches up. Nothing is dropped.
+	OverflowCoalesce
+)
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowDrop:
+		return "drop"
+	case OverflowBlock:
+		return "block"
+	case OverflowCoalesce:
+		return "coalesce"
+	default:
+		return "unknown"
+	}
+}
+
+type ManagerOption func(*ConfigManager)
+
+// WithChangeBufferSize sets the capacity of the change channel and of each
+// Watch subscription.
+func WithChangeBufferSize(size int) ManagerOption {
+	return func(m *ConfigManager) {
+		if size > 0 {
+			m.bufferSize = size
+		}
+	}
+}
+
+func WithOverflowPolicy(policy OverflowPolicy) ManagerOption {
+	return func(m *ConfigManager) {
+		m.overflow = policy
+	}
+}
+
+// WithBlockTimeout bounds how long OverflowBlock waits for room.
+func WithBlockTimeout(timeout time.Duration) ManagerOption {
+	return func(m *ConfigManager) {
+		if timeout > 0 {
+			m.blockTimeout = timeout
+		}
+	}
+}
+
+// changeQueue holds changes waiting to be coalesced, in first-seen order.
+type changeQueue struct {
+	mu      sync.Mutex
+	order   []string
+	changes map[string]ConfigChange
+	signal  chan struct{}
+}
+
+func newChangeQueue() *changeQueue {
+	return &changeQueue{
+		changes: make(map[string]ConfigChange),
+		signal:  make(chan struct{}, 1),
+	}
+}
+
+// push replaces any pending change for the same key, keeping the oldest
+// OldValue so the reader still sees the full transition.
+func (q *changeQueue) push(change ConfigChange) {
+	q.mu.Lock()
+	if pending, ok := q.changes[change.Key]; ok {
+		change.OldValue = pending.OldValue
+	} else {
+		q.order = append(q.order, change.Key)
+	}
+	q.changes[change.Key] = change
+	q.mu.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (q *changeQueue) drain() []ConfigChange {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	drained := make([]ConfigChange, 0, len(q.order))
+	for _, key := range q.order {
+		drained = append(drained, q.changes[key])
+	}
+	q.order = nil
+	q.changes = make(map[string]ConfigChange)
+	return drained
+}
+
+// publish hands change to the fan-out goroutine according to the overflow
+// policy. The caller must not hold m.mu, since OverflowBlock may wait for
+// room and writers, or a subscriber calling Get, would wait with it.
+func (m *ConfigManager) publish(change ConfigChange) {
+	m.publishMu.RLock()
+	defer m.publishMu.RUnlock()
+	if m.publishClosed {
+		return
+	}
+
+	switch m.overflow {
+	case OverflowCoalesce:
+		m.pending.push(change)
+		return
+	case OverflowBlock:
+		select {
+		case m.onChange <- change:
+			return
+		default:
+		}
+		timer := time.NewTimer(m.blockTimeout)
+		defer timer.Stop()
+		select {
+		case m.onChange <- change:
+			return
+		case <-timer.C:
+		}
+	default:
+		select {
+		case m.onChange <- change:
+			return
+		default:
+		}
+	}
+
+	atomic.AddUint64(&m.droppedChanges, 1)
+	m.logger.Warn("Config change channel full, dropping change", "key", change.Key)
+}
@@ -0,0 +1,31 @@
+package config
+
+import "bindxdb/pkg/plugin"
+
+// The plugin registry and lifecycle manager find these by type assertion on
+// their config provider: StopPlugin drops a stopped plugin's watchers via
+// RemoveWatchersForOwner.
+var (
+	_ plugin.ConfigProvider          = (*ConfigManager)(nil)
+	_ plugin.WatcherRemover          = (*ConfigManager)(nil)
+	_ plugin.ValidationHookRegistrar = (*ConfigManager)(nil)
+)
+
+// pluginConfigPrefix is where each plugin's settings live, matching
+// PluginConfig.Configs: plugins.configs.<plugin id>.
+const pluginConfigPrefix = "plugins.configs"
+
+// GetPluginConfig returns the settings under plugins.configs.<pluginID>,
+// or an empty map if the plugin has none. With it the manager can be
+// passed to plugin.NewPluginRegistry as its config provider, which also
+// registers the registry's config.validate hooks with AddValidationHook.
+func (m *ConfigManager) GetPluginConfig(pluginID string) (map[string]interface{}, error) {
+	key := joinKey(pluginConfigPrefix, pluginID)
+	m.mu.RLock()
+	present := m.hasKeyOrChildren(m.canonicalKey(key))
+	m.mu.RUnlock()
+	if !present {
+		return make(map[string]interface{}), nil
+	}
+	return m.GetStringMap(key)
+}
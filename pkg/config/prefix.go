@@ -0,0 +1,73 @@
+package config
+
+import (
+	"context"
+	"io"
+	"strings"
+)
+
+// AddSourceWithPrefix adds source with every key it produces mounted under
+// prefix, so a shared source's "logging.level" is read as
+// "shared.logging.level" and can't collide with the manager's own keys.
+func (m *ConfigManager) AddSourceWithPrefix(source ConfigSources, prefix string) error {
+	prefix = strings.Trim(prefix, ".")
+	if prefix == "" {
+		return m.AddSource(source)
+	}
+	return m.AddSource(&prefixedSource{ConfigSources: source, prefix: prefix})
+}
+
+// prefixedSource nests the data of the source it wraps under prefix. It
+// keeps the wrapped source's name so it can still be reloaded, replaced or
+// removed by that name.
+type prefixedSource struct {
+	ConfigSources
+	prefix string
+}
+
+func (p *prefixedSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	config, err := p.ConfigSources.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.mount(config), nil
+}
+
+func (p *prefixedSource) Watch(ctx context.Context, onChange func(ConfigChange)) error {
+	return p.ConfigSources.Watch(ctx, func(change ConfigChange) {
+		if data, ok := change.NewValue.(map[string]interface{}); ok {
+			change.NewValue = p.mount(data)
+		} else {
+			change.Key = joinKey(p.prefix, change.Key)
+		}
+		onChange(change)
+	})
+}
+
+func (p *prefixedSource) Kind() ConfigSource {
+	return sourceKind(p.ConfigSources)
+}
+
+func (p *prefixedSource) IsSecretKey(key string) bool {
+	secrets, ok := p.ConfigSources.(SecretKeySource)
+	if !ok || !strings.HasPrefix(key, p.prefix+".") {
+		return false
+	}
+	return secrets.IsSecretKey(strings.TrimPrefix(key, p.prefix+"."))
+}
+
+func (p *prefixedSource) Close() error {
+	if closer, ok := p.ConfigSources.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (p *prefixedSource) mount(config map[string]interface{}) map[string]interface{} {
+	if config == nil {
+		return nil
+	}
+	result := make(map[string]interface{})
+	setNestedValue(result, p.prefix, config)
+	return result
+}
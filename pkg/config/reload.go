@@ -0,0 +1,282 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SourceHealth describes the most recent reads of one source.
+type SourceHealth struct {
+	Name        string
+	Priority    int
+	Loads       uint64
+	Failures    uint64
+	LastSuccess time.Time
+	LastError   error
+	// LastDuration is how long the most recent read took.
+	LastDuration time.Duration
+	// WatchError is the last error from watching the source, cleared when
+	// the watch next reports a change.
+	WatchError error
+}
+
+// healthOf returns the health record of source, creating it on first use.
+// The caller must hold m.mu.
+func (m *ConfigManager) healthOf(source ConfigSources) *SourceHealth {
+	health, ok := m.sourceHealth[source]
+	if !ok {
+		health = &SourceHealth{Name: source.Name(), Priority: source.Priority()}
+		m.sourceHealth[source] = health
+	}
+	return health
+}
+
+// sourceRead is the result of loading one source.
+type sourceRead struct {
+	source   ConfigSources
+	config   map[string]interface{}
+	err      error
+	duration time.Duration
+}
+
+// readSources loads each of sources. It is called without m.mu, since a
+// remote backend can take a while and Get must not wait on it; m.readMu
+// keeps reads from being recorded out of order.
+func readSources(ctx context.Context, sources []ConfigSources) []sourceRead {
+	reads := make([]sourceRead, 0, len(sources))
+	for _, source := range sources {
+		start := time.Now()
+		config, err := source.Load(ctx)
+		reads = append(reads, sourceRead{source: source, config: config, err: err, duration: time.Since(start)})
+	}
+	return reads
+}
+
+// recordRead caches read's data for rebuild and updates the source's
+// health. A failed read keeps the data from the last successful one so a
+// flaky source doesn't make its keys disappear. Reads of sources detached
+// while they were being read are dropped. The caller must hold m.mu.
+func (m *ConfigManager) recordRead(read sourceRead) error {
+	if !m.attached(read.source) {
+		return nil
+	}
+	health := m.healthOf(read.source)
+	health.LastDuration = read.duration
+	health.Loads++
+	if read.err != nil {
+		health.Failures++
+		health.LastError = read.err
+		return fmt.Errorf("source %s: %w", read.source.Name(), read.err)
+	}
+
+	health.LastError = nil
+	health.LastSuccess = time.Now()
+	m.sourceData[read.source] = read.config
+	return nil
+}
+
+// attached reports whether source is one of the manager's sources. The
+// caller must hold m.mu.
+func (m *ConfigManager) attached(source ConfigSources) bool {
+	for _, existing := range m.sources {
+		if existing == source {
+			return true
+		}
+	}
+	return false
+}
+
+// sourcesNamed returns the sources called name. The caller must hold m.mu.
+func (m *ConfigManager) sourcesNamed(name string) []ConfigSources {
+	var sources []ConfigSources
+	for _, source := range m.sources {
+		if source.Name() == name {
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// ReloadSource re-reads only the sources called name and recomputes the
+// effective configuration from the data last read from the others.
+func (m *ConfigManager) ReloadSource(ctx context.Context, name string) error {
+	m.readMu.Lock()
+	defer m.readMu.Unlock()
+
+	m.mu.RLock()
+	closed, sources := m.closed, m.sourcesNamed(name)
+	m.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("source %s not found", name)
+	}
+	reads := readSources(ctx, sources)
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	var loadErr MultiError
+	for _, read := range reads {
+		loadErr.Add(m.recordRead(read))
+	}
+	changes, err := m.rebuild(&loadErr, false)
+	m.mu.Unlock()
+
+	for _, change := range changes {
+		m.notifyWatchers(change)
+	}
+	return err
+}
+
+// SourceStatus returns the health of every source, highest priority first.
+// Sources that have not been read yet report zero counts.
+func (m *ConfigManager) SourceStatus() []SourceHealth {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := make([]SourceHealth, 0, len(m.sources))
+	for _, source := range m.sources {
+		if health, ok := m.sourceHealth[source]; ok {
+			status = append(status, *health)
+			continue
+		}
+		status = append(status, SourceHealth{Name: source.Name(), Priority: source.Priority()})
+	}
+	return status
+}
+
+// RemoveSource detaches every source called name and recomputes the
+// effective configuration without it. Keys only that source supplied fall
+// back to their defaults or disappear. Removed sources that implement
+// io.Closer are closed.
+func (m *ConfigManager) RemoveSource(name string) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+
+	removed := m.detachSources(name)
+	if len(removed) == 0 {
+		m.mu.Unlock()
+		return fmt.Errorf("source %s not found", name)
+	}
+
+	var loadErr MultiError
+	changes, err := m.rebuild(&loadErr, false)
+	m.mu.Unlock()
+
+	for _, change := range changes {
+		m.notifyWatchers(change)
+	}
+	closeSources(removed)
+	return err
+}
+
+// ReplaceSource swaps the sources called name for source in one step. The
+// new source is read first; if that fails the old sources stay in place.
+// If the old sources were being watched, the new one is watched too.
+func (m *ConfigManager) ReplaceSource(ctx context.Context, name string, source ConfigSources) error {
+	m.readMu.Lock()
+	defer m.readMu.Unlock()
+
+	m.mu.RLock()
+	closed, found := m.closed, len(m.sourcesNamed(name)) > 0
+	m.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	if !found {
+		return fmt.Errorf("source %s not found", name)
+	}
+
+	read := readSources(ctx, []ConfigSources{source})[0]
+	if read.err != nil {
+		return fmt.Errorf("replacement for %s not loaded: %w", name, read.err)
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	var parent context.Context
+	for _, old := range m.sourcesNamed(name) {
+		if watch, ok := m.watches[old]; ok {
+			parent = watch.parent
+			break
+		}
+	}
+	removed := m.detachSources(name)
+	if len(removed) == 0 {
+		m.mu.Unlock()
+		return fmt.Errorf("source %s not found", name)
+	}
+	m.insertSource(source)
+	m.recordRead(read)
+	var watchCtx context.Context
+	if parent != nil && parent.Err() == nil {
+		watchCtx = m.addWatch(parent, source)
+	}
+
+	var loadErr MultiError
+	changes, err := m.rebuild(&loadErr, false)
+	m.mu.Unlock()
+
+	for _, change := range changes {
+		m.notifyWatchers(change)
+	}
+	closeSources(removed)
+	if watchCtx != nil {
+		if watchErr := m.watchSource(watchCtx, source); watchErr != nil {
+			if err == nil {
+				return watchErr
+			}
+			return &MultiError{Errors: []error{err, watchErr}}
+		}
+	}
+	return err
+}
+
+// detachSources removes the sources called name along with their cached
+// data. The caller must hold m.mu.
+func (m *ConfigManager) detachSources(name string) []ConfigSources {
+	var removed []ConfigSources
+	kept := m.sources[:0]
+	for _, source := range m.sources {
+		if source.Name() != name {
+			kept = append(kept, source)
+			continue
+		}
+		removed = append(removed, source)
+		delete(m.sourceData, source)
+		delete(m.sourceHealth, source)
+		if watch, ok := m.watches[source]; ok {
+			watch.cancel()
+			delete(m.watches, source)
+		}
+	}
+	m.sources = kept
+	return removed
+}
+
+func closeSources(sources []ConfigSources) error {
+	var multiErr MultiError
+	for _, source := range sources {
+		if closer, ok := source.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				multiErr.Add(fmt.Errorf("failed to close source %s: %w", source.Name(), err))
+			}
+		}
+	}
+	if multiErr.HasErrors() {
+		return &multiErr
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"sort"
+	"strings"
+)
+
+// validateRequired reports every key listed in schema.Required, and every
+// property marked Required whose parent object is present, that has no value.
+// The caller must hold m.mu.
+func (m *ConfigManager) validateRequired(multiErr *MultiError) {
+	if m.schema == nil {
+		return
+	}
+
+	missing := make(map[string]bool)
+	for _, key := range m.schema.Required {
+		if !m.hasKeyOrChildren(key) {
+			missing[key] = true
+		}
+	}
+
+	var walk func(parent string, props map[string]*SchemaNode)
+	walk = func(parent string, props map[string]*SchemaNode) {
+		for name, node := range props {
+			path := joinKey(parent, name)
+			present := m.hasKeyOrChildren(path)
+			if node.Required && !present {
+				missing[path] = true
+			}
+			if present && node.Properties != nil {
+				walk(path, node.Properties)
+			}
+		}
+	}
+	walk("", m.schema.Properties)
+
+	keys := make([]string, 0, len(missing))
+	for key := range missing {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		multiErr.Add(&ConfigError{
+			Key:     key,
+			Message: "required key is not set",
+			Code:    CodeRequiredMissing,
+		})
+	}
+}
+
+func (m *ConfigManager) hasKeyOrChildren(key string) bool {
+	if value, exists := m.values[key]; exists && value.IsSet {
+		return true
+	}
+	for k, value := range m.values {
+		if value.IsSet && strings.HasPrefix(k, key+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,577 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// compileSchema checks schema for errors that can be detected without any
+// values and compiles every node pattern once.
+func compileSchema(schema *ConfigSchema) (map[*SchemaNode]*regexp.Regexp, error) {
+	patterns := make(map[*SchemaNode]*regexp.Regexp)
+	var multiErr MultiError
+
+	var walk func(path string, node *SchemaNode)
+	walkProps := func(parent string, props map[string]*SchemaNode) {
+		names := make([]string, 0, len(props))
+		for name := range props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			walk(joinKey(parent, name), props[name])
+		}
+	}
+	walk = func(path string, node *SchemaNode) {
+		if node == nil {
+			return
+		}
+		if node.Pattern != "" {
+			switch node.Type {
+			case "integer", "number":
+				multiErr.Add(&ConfigError{
+					Key:     path,
+					Message: fmt.Sprintf("pattern is not supported on %s nodes", node.Type),
+				})
+			default:
+				regex, err := regexp.Compile(node.Pattern)
+				if err != nil {
+					multiErr.Add(&ConfigError{
+						Key:     path,
+						Message: fmt.Sprintf("invalid pattern %q", node.Pattern),
+						Err:     err,
+					})
+				} else {
+					patterns[node] = regex
+				}
+			}
+		}
+		if _, ok := schemaFormats[node.Format]; node.Format != "" && !ok {
+			multiErr.Add(&ConfigError{
+				Key:     path,
+				Message: fmt.Sprintf("unknown format %q", node.Format),
+			})
+		}
+		if !validSchemaTypes[node.Type] {
+			multiErr.Add(&ConfigError{
+				Key:     path,
+				Message: fmt.Sprintf("unknown schema type %q", node.Type),
+			})
+		}
+		if node.MergeStrategy != "" {
+			if _, err := ParseArrayMergeStrategy(node.MergeStrategy); err != nil {
+				multiErr.Add(&ConfigError{Key: path, Message: err.Error()})
+			} else if node.Type != "array" {
+				multiErr.Add(&ConfigError{
+					Key:     path,
+					Message: fmt.Sprintf("mergeStrategy is only allowed on array nodes, not %s", node.Type),
+				})
+			}
+		}
+		checkBounds(&multiErr, path, node, "string", "minLength", node.MinLength, "maxLength", node.MaxLength)
+		checkBounds(&multiErr, path, node, "array", "minItems", node.MinItems, "maxItems", node.MaxItems)
+		if node.Items != nil && node.Type != "array" {
+			multiErr.Add(&ConfigError{
+				Key:     path,
+				Message: fmt.Sprintf("items is only allowed on array nodes, not %s", node.Type),
+			})
+		}
+		for _, enumValue := range node.Enum {
+			if !matchesSchemaType(node.Type, enumValue) {
+				multiErr.Add(&ConfigError{
+					Key:     path,
+					Message: fmt.Sprintf("enum value %v does not match node type %s", enumValue, node.Type),
+				})
+			}
+		}
+		for _, name := range node.Validators {
+			if _, ok := GetNamedValidator(name); !ok {
+				multiErr.Add(&ConfigError{
+					Key:     path,
+					Message: fmt.Sprintf("unknown validator %q", name),
+				})
+			}
+		}
+		walkProps(path, node.Properties)
+		walk(path+"[]", node.Items)
+		walk(path+".*", node.AdditionalProperties)
+	}
+	walkProps("", schema.Properties)
+
+	if multiErr.HasErrors() {
+		return nil, &multiErr
+	}
+
+	// Defaults can only be checked once every pattern has compiled.
+	checker := &ConfigManager{patterns: patterns}
+	var checkDefaults func(parent string, props map[string]*SchemaNode)
+	checkDefaults = func(parent string, props map[string]*SchemaNode) {
+		for name, node := range props {
+			path := joinKey(parent, name)
+			if node.Default != nil && node.Type != "" {
+				if err := checker.validateNode(node, node.Default); err != nil {
+					multiErr.Add(&ConfigError{
+						Key:     path,
+						Message: "default value violates its own schema node",
+						Err:     err,
+					})
+				}
+			}
+			checkDefaults(path, node.Properties)
+		}
+	}
+	checkDefaults("", schema.Properties)
+
+	if multiErr.HasErrors() {
+		return nil, &multiErr
+	}
+	return patterns, nil
+}
+
+// checkBounds reports length bounds that are negative, inverted or set on
+// a node whose type they don't apply to.
+func checkBounds(multiErr *MultiError, path string, node *SchemaNode, nodeType string,
+	minName string, min *int, maxName string, max *int) {
+	if min == nil && max == nil {
+		return
+	}
+	if node.Type != nodeType {
+		multiErr.Add(&ConfigError{
+			Key:     path,
+			Message: fmt.Sprintf("%s and %s are only allowed on %s nodes, not %s", minName, maxName, nodeType, node.Type),
+		})
+		return
+	}
+	if min != nil && *min < 0 {
+		multiErr.Add(&ConfigError{Key: path, Message: fmt.Sprintf("%s %d is negative", minName, *min)})
+	}
+	if max != nil && *max < 0 {
+		multiErr.Add(&ConfigError{Key: path, Message: fmt.Sprintf("%s %d is negative", maxName, *max)})
+	}
+	if min != nil && max != nil && *min > *max {
+		multiErr.Add(&ConfigError{
+			Key:     path,
+			Message: fmt.Sprintf("%s %d is greater than %s %d", minName, *min, maxName, *max),
+		})
+	}
+}
+
+// schemaFormats are the checks behind a node's "format".
+var schemaFormats = map[string]ConfigValidator{
+	"hostname": &HostValidator{},
+	"ipv4":     &IPValidator{AllowIPv4: true},
+	"ipv6":     &IPValidator{AllowIPv6: true},
+	"uri":      &URLValidator{RequireScheme: true},
+	"email":    &EmailValidator{},
+	"duration": &DurationValidator{},
+	"bytes":    &SizeValidator{},
+	"path":     &FileValidator{},
+	"regex":    &RegexValidator{},
+	"uuid":     &UUIDValidator{},
+}
+
+// checkFormat checks value against the node's format. Messages from the
+// validators start with the key, which validateNode doesn't have.
+func checkFormat(node *SchemaNode, value interface{}) error {
+	validator, ok := schemaFormats[node.Format]
+	if node.Format == "" || !ok {
+		return nil
+	}
+	if err := validator.Validate("", value); err != nil {
+		return &ConfigError{
+			Message: fmt.Sprintf("value does not match format %s", node.Format),
+			Code:    CodeInvalidFormat,
+			Err:     errors.New(strings.TrimPrefix(err.Error(), ": ")),
+		}
+	}
+	return nil
+}
+
+var validSchemaTypes = map[string]bool{
+	"":         true,
+	"string":   true,
+	"integer":  true,
+	"number":   true,
+	"boolean":  true,
+	"duration": true,
+	"array":    true,
+	"object":   true,
+}
+
+func LoadSchemaFromFile(path string) (*ConfigSchema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema file: %w", err)
+	}
+
+	loader := NewConfigLoader()
+	format := loader.detectFormat(path)
+	if format == nil {
+		return nil, fmt.Errorf("unsupported schema format: %s", path)
+	}
+
+	schema, err := LoadSchemaFromBytes(data, format.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load schema %s: %w", path, err)
+	}
+	return schema, nil
+}
+
+// LoadSchemaFromBytes parses data in the named format and checks the result
+// the same way SetSchema does.
+func LoadSchemaFromBytes(data []byte, format string) (*ConfigSchema, error) {
+	configFormat := NewConfigLoader().Format(format)
+	if configFormat == nil {
+		return nil, fmt.Errorf("unsupported schema format: %s", format)
+	}
+
+	raw, err := configFormat.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Go through JSON so both formats share ConfigSchema's json tags.
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to normalize schema: %w", err)
+	}
+	var schema ConfigSchema
+	if err := json.Unmarshal(encoded, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema structure: %w", err)
+	}
+
+	if _, err := compileSchema(&schema); err != nil {
+		return nil, err
+	}
+	return &schema, nil
+}
+
+func (m *ConfigManager) LoadSchemaFile(path string) error {
+	schema, err := LoadSchemaFromFile(path)
+	if err != nil {
+		return err
+	}
+	return m.SetSchema(schema)
+}
+
+// ValidateConfigFile loads the config file at path against schema in a
+// throwaway manager and returns every violation, including keys the schema
+// does not declare.
+func ValidateConfigFile(schema *ConfigSchema, path string) error {
+	config, err := NewConfigLoader().LoadFile(path)
+	if err != nil {
+		return err
+	}
+
+	m := NewConfigManager(&DefaultLogger{}, nil)
+	defer m.Close()
+
+	if err := m.SetSchema(schema); err != nil {
+		return err
+	}
+	m.EnableStrictSchema(true)
+	if err := m.AddSource(&staticSource{name: "file", config: config}); err != nil {
+		return err
+	}
+	return m.Load(context.Background())
+}
+
+type staticSource struct {
+	name   string
+	config map[string]interface{}
+}
+
+func (s *staticSource) Name() string { return s.name }
+
+func (s *staticSource) Priority() int { return 50 }
+
+func (s *staticSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	return s.config, nil
+}
+
+func (s *staticSource) Watch(ctx context.Context, onChange func(ConfigChange)) error {
+	return nil
+}
+
+// enumContains reports whether value is in allowed, treating numbers of
+// different Go types as equal when they have the same value.
+func enumContains(allowed []interface{}, value interface{}) bool {
+	num, isNum := toNumber(value)
+	for _, candidate := range allowed {
+		if isNum {
+			if other, ok := toNumber(candidate); ok && num.compare(other) == 0 {
+				return true
+			}
+			continue
+		}
+		if reflect.DeepEqual(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesSchemaType(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "integer":
+		num, ok := toNumber(value)
+		return ok && num.isInt
+	case "number":
+		_, ok := toNumber(value)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "duration":
+		_, err := toDuration("", value)
+		return err == nil
+	case "array":
+		kind := reflect.TypeOf(value)
+		return kind != nil && (kind.Kind() == reflect.Slice || kind.Kind() == reflect.Array)
+	case "object":
+		kind := reflect.TypeOf(value)
+		return kind != nil && kind.Kind() == reflect.Map
+	default:
+		return true
+	}
+}
+
+func (m *ConfigManager) EnableStrictSchema(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.strictSchema = enabled
+}
+
+// validateKnownKey reports key if the schema neither declares it nor allows
+// it through an AdditionalProperties node. Values admitted by an
+// AdditionalProperties node must also validate against it.
+func (m *ConfigManager) validateKnownKey(key string, value interface{}) error {
+	parts := strings.Split(key, ".")
+	props := m.schema.Properties
+	var parent *SchemaNode
+
+	for i, part := range parts {
+		node, exists := props[part]
+		if !exists {
+			if parent == nil || parent.AdditionalProperties == nil {
+				return m.unknownKeyError(key)
+			}
+			node = parent.AdditionalProperties
+		}
+		if i == len(parts)-1 {
+			if !exists {
+				if err := m.validateNode(node, value); err != nil {
+					message := err.Error()
+					if configErr, ok := err.(*ConfigError); ok {
+						message = configErr.Message
+					}
+					return &ConfigError{
+						Key:     key,
+						Message: "value not allowed by additionalProperties: " + message,
+						Code:    errorCode(err),
+					}
+				}
+			}
+			return nil
+		}
+		if node.Properties == nil && node.AdditionalProperties == nil {
+			// An object without declared properties is free-form.
+			return nil
+		}
+		parent = node
+		props = node.Properties
+	}
+	return nil
+}
+
+func (m *ConfigManager) unknownKeyError(key string) error {
+	message := "key is not declared in the schema"
+	if suggestion := closestKey(key, schemaKeys(m.schema)); suggestion != "" {
+		message = fmt.Sprintf("%s, did you mean %s?", message, suggestion)
+	}
+	return &ConfigError{Key: key, Message: message, Code: CodeUnknownKey}
+}
+
+func schemaKeys(schema *ConfigSchema) []string {
+	var keys []string
+	var walk func(parent string, props map[string]*SchemaNode)
+	walk = func(parent string, props map[string]*SchemaNode) {
+		for name, node := range props {
+			path := joinKey(parent, name)
+			keys = append(keys, path)
+			walk(path, node.Properties)
+		}
+	}
+	walk("", schema.Properties)
+	sort.Strings(keys)
+	return keys
+}
+
+// closestKey returns the candidate nearest to key by edit distance, or "" if
+// none is close enough to be a plausible typo.
+func closestKey(key string, candidates []string) string {
+	best := ""
+	bestDistance := len(key)/3 + 2
+	for _, candidate := range candidates {
+		if d := levenshtein(key, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// coerceValue converts a loaded value to the type its schema node declares,
+// so that e.g. environment strings become ints, bools and durations. Values
+// that already have a suitable type are returned unchanged.
+func coerceValue(key string, node *SchemaNode, value interface{}) (interface{}, error) {
+	coerceErr := func(err error) error {
+		return &ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("cannot coerce %v to %s", value, node.Type),
+			Err:     err,
+		}
+	}
+
+	switch node.Type {
+	case "integer":
+		if str, ok := value.(string); ok {
+			i, err := strconv.ParseInt(strings.TrimSpace(str), 10, 64)
+			if err != nil {
+				return nil, coerceErr(err)
+			}
+			if int64(int(i)) == i {
+				return int(i), nil
+			}
+			return i, nil
+		}
+	case "number":
+		if str, ok := value.(string); ok {
+			f, err := strconv.ParseFloat(strings.TrimSpace(str), 64)
+			if err != nil {
+				return nil, coerceErr(err)
+			}
+			return f, nil
+		}
+	case "boolean":
+		if str, ok := value.(string); ok {
+			b, err := strconv.ParseBool(strings.TrimSpace(str))
+			if err != nil {
+				return nil, coerceErr(err)
+			}
+			return b, nil
+		}
+	case "duration":
+		if _, ok := value.(time.Duration); !ok {
+			d, err := toDuration(key, value)
+			if err != nil {
+				return nil, coerceErr(err)
+			}
+			return d, nil
+		}
+	case "array":
+		kind := reflect.TypeOf(value)
+		if kind == nil {
+			return value, nil
+		}
+		if kind.Kind() != reflect.Slice && kind.Kind() != reflect.Array {
+			value = []interface{}{value}
+		}
+		if node.Items == nil {
+			return value, nil
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			return value, nil
+		}
+		coerced := make([]interface{}, len(items))
+		for i, item := range items {
+			c, err := coerceValue(fmt.Sprintf("%s[%d]", key, i), node.Items, item)
+			if err != nil {
+				return nil, err
+			}
+			coerced[i] = c
+		}
+		return coerced, nil
+	case "object":
+		// Objects only reach here as array elements; fill in the defaults
+		// their item schema declares.
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return value, nil
+		}
+		filled := make(map[string]interface{}, len(obj))
+		for name, propValue := range obj {
+			filled[name] = propValue
+			if prop, ok := node.Properties[name]; ok {
+				c, err := coerceValue(joinKey(key, name), prop, propValue)
+				if err != nil {
+					return nil, err
+				}
+				filled[name] = c
+			}
+		}
+		for name, prop := range node.Properties {
+			if _, exists := filled[name]; !exists && prop.Default != nil {
+				filled[name] = deepCopyValue(prop.Default)
+			}
+		}
+		return filled, nil
+	}
+	return value, nil
+}
+
+// schemaDefaults collects the Default of every schema node, keyed by its
+// dotted path.
+func schemaDefaults(schema *ConfigSchema) map[string]interface{} {
+	defaults := make(map[string]interface{})
+	if schema == nil {
+		return defaults
+	}
+	var walk func(parent string, props map[string]*SchemaNode)
+	walk = func(parent string, props map[string]*SchemaNode) {
+		for name, node := range props {
+			if node == nil {
+				continue
+			}
+			path := joinKey(parent, name)
+			if node.Default != nil {
+				defaults[path] = node.Default
+			}
+			walk(path, node.Properties)
+		}
+	}
+	walk("", schema.Properties)
+	return defaults
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"regexp"
+)
+
+// BuildValidatorsFromSchema turns the pattern, min/max, length, enum,
+// required and named-validator constraints of every schema property into validators, keyed by
+// the property's dotted path. Nodes under Items and AdditionalProperties
+// have no fixed key and are left to schema validation. Invalid patterns
+// are skipped; SetSchema rejects them before this is called.
+func BuildValidatorsFromSchema(schema *ConfigSchema) map[string][]ConfigValidator {
+	validators := make(map[string][]ConfigValidator)
+	if schema == nil {
+		return validators
+	}
+
+	var walk func(path string, node *SchemaNode)
+	walk = func(path string, node *SchemaNode) {
+		if node == nil {
+			return
+		}
+		if node.Required {
+			validators[path] = append(validators[path], &RequiredValidator{Key: path})
+		}
+		if node.Pattern != "" && node.Type != "integer" && node.Type != "number" {
+			if regex, err := regexp.Compile(node.Pattern); err == nil {
+				validators[path] = append(validators[path], &PatternValidator{Pattern: node.Pattern, regex: regex})
+			}
+		}
+		if rng := rangeFromSchema(node); rng != nil {
+			validators[path] = append(validators[path], rng)
+		}
+		if length := lengthFromSchema(node); length != nil {
+			validators[path] = append(validators[path], length)
+		}
+		if len(node.Enum) > 0 {
+			validators[path] = append(validators[path], &EnumValidator{Allowed: node.Enum})
+		}
+		for _, name := range node.Validators {
+			if validator, ok := GetNamedValidator(name); ok {
+				validators[path] = append(validators[path], validator)
+			}
+		}
+		for name, child := range node.Properties {
+			walk(joinKey(path, name), child)
+		}
+	}
+	for name, node := range schema.Properties {
+		walk(name, node)
+	}
+	for _, key := range schema.Required {
+		if !hasRequiredValidator(validators[key]) {
+			validators[key] = append(validators[key], &RequiredValidator{Key: key})
+		}
+	}
+	return validators
+}
+
+func rangeFromSchema(node *SchemaNode) *RangeValidator {
+	if node.Min == nil && node.Max == nil {
+		return nil
+	}
+	rng := &RangeValidator{}
+	if node.Min != nil {
+		min, ok := toNumber(node.Min)
+		if !ok {
+			return nil
+		}
+		rng.Min, rng.HasMin = min.f, true
+	}
+	if node.Max != nil {
+		max, ok := toNumber(node.Max)
+		if !ok {
+			return nil
+		}
+		rng.Max, rng.HasMax = max.f, true
+	}
+	return rng
+}
+
+// lengthFromSchema maps minLength/maxLength or minItems/maxItems onto a
+// LengthValidator. A maximum of 0 cannot be expressed, since
+// LengthValidator treats it as no limit, so it is left to schema
+// validation.
+func lengthFromSchema(node *SchemaNode) *LengthValidator {
+	min, max := node.MinLength, node.MaxLength
+	if node.Type == "array" {
+		min, max = node.MinItems, node.MaxItems
+	}
+	if min == nil && (max == nil || *max == 0) {
+		return nil
+	}
+	length := &LengthValidator{}
+	if min != nil {
+		length.Min = *min
+	}
+	if max != nil {
+		length.Max = *max
+	}
+	return length
+}
+
+func hasRequiredValidator(validators []ConfigValidator) bool {
+	for _, validator := range validators {
+		if _, ok := validator.(*RequiredValidator); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// EnableSchemaValidators makes Set check values against validators built
+// from the schema with BuildValidatorsFromSchema, so a value that breaks a
+// schema constraint is rejected when it is set rather than at the next
+// ValidateAll. The validators are rebuilt on every SetSchema.
+func (m *ConfigManager) EnableSchemaValidators(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.useSchemaValidator = enabled
+	if !enabled {
+		m.schemaValidators = nil
+		return
+	}
+	m.registerSchemaValidators()
+}
+
+// registerSchemaValidators replaces the schema validators with ones built
+// from the current schema. The caller must hold m.mu.
+func (m *ConfigManager) registerSchemaValidators() {
+	m.schemaValidators = make(map[string][]ConfigValidator)
+	for key, validators := range BuildValidatorsFromSchema(m.schema) {
+		canonical := m.canonicalKey(key)
+		m.schemaValidators[canonical] = append(m.schemaValidators[canonical], validators...)
+	}
+}
+
+// runSchemaValidators checks value against the schema validators for key.
+// SetBatch and ValidateAll validate against the schema directly and do not
+// use them. The caller must hold m.mu.
+func (m *ConfigManager) runSchemaValidators(key string, value interface{}) error {
+	var multiErr MultiError
+	for _, validator := range m.schemaValidators[key] {
+		if err := validator.Validate(key, value); err != nil {
+			validationErr := validatorError(key, validator, err)
+			if m.isSecretKey(key) {
+				return secretValidationError(key, validationErr)
+			}
+			multiErr.Add(validationErr)
+		}
+	}
+	switch len(multiErr.Errors) {
+	case 0:
+		return nil
+	case 1:
+		return multiErr.Errors[0]
+	}
+	return &multiErr
+}
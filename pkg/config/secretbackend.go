@@ -0,0 +1,477 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretBackendConfig selects and configures a secret store. Backend is one
+// of file, vault, aws, env, memory or chain; a chain queries the backends listed
+// in Chain in order.
+type SecretBackendConfig struct {
+	Backend       string
+	Chain         []string
+	Dir           string
+	EncryptionKey string
+	// EncryptionKeyFile is read with KeyFromFile and takes precedence over
+	// EncryptionKey.
+	EncryptionKeyFile string
+	// EncryptionAlgorithm is a name ParseSecretAlgorithm accepts. Secrets
+	// written with the other algorithms stay readable.
+	EncryptionAlgorithm string
+	// DecryptionKeys are earlier encryption keys the file backend can still
+	// read secrets written with.
+	DecryptionKeys []string
+	EnvPrefix      string
+	// AllowInsecurePermissions lets the file backend use a directory that
+	// group or others can read.
+	AllowInsecurePermissions bool
+	Vault                    VaultConfig
+	AWS                      AWSOptions
+}
+
+// VaultConfig configures a VaultSecretStore. AuthMethod is token (the
+// default), approle or kubernetes; AuthMount overrides the mount path of the
+// auth method, which defaults to the method name.
+type VaultConfig struct {
+	Address   string
+	Token     string
+	MountPath string
+
+	AuthMethod string
+	AuthMount  string
+	RoleID     string
+	SecretID   string
+	Role       string
+	JWTPath    string
+}
+
+// secretBackendEnvVars are the variables SecretBackendConfigFromEnv reads.
+// InitConfig keeps them out of the environment source, so credentials such
+// as BINDXDB_VAULT_TOKEN don't also turn up as plain vault.token, and the
+// env backend doesn't list them as secrets.
+var secretBackendEnvVars = []string{
+	"BINDXDB_SECRET_BACKEND",
+	"BINDXDB_SECRET_DIR",
+	"BINDXDB_SECRET_PREFIX",
+	"BINDXDB_SECRET_CHAIN",
+	"BINDXDB_SECRET_ALLOW_INSECURE_PERMISSIONS",
+	"BINDXDB_ENCRYPTION_KEY",
+	"BINDXDB_ENCRYPTION_KEY_FILE",
+	"BINDXDB_ENCRYPTION_ALGORITHM",
+	"BINDXDB_DECRYPTION_KEYS",
+	"BINDXDB_VAULT_ADDR",
+	"BINDXDB_VAULT_TOKEN",
+	"BINDXDB_VAULT_MOUNT",
+	"BINDXDB_VAULT_AUTH",
+	"BINDXDB_VAULT_AUTH_MOUNT",
+	"BINDXDB_VAULT_ROLE_ID",
+	"BINDXDB_VAULT_SECRET_ID",
+	"BINDXDB_VAULT_ROLE",
+	"BINDXDB_VAULT_JWT_PATH",
+	"BINDXDB_AWS_SECRET_MODE",
+	"BINDXDB_AWS_SECRET_PREFIX",
+	"BINDXDB_AWS_ENDPOINT",
+}
+
+// SecretBackendConfigFromEnv is the bootstrap configuration used before any
+// config file has been read, taken from BINDXDB_SECRET_BACKEND and friends.
+func SecretBackendConfigFromEnv() SecretBackendConfig {
+	cfg := SecretBackendConfig{
+		Backend:       os.Getenv("BINDXDB_SECRET_BACKEND"),
+		Dir:           os.Getenv("BINDXDB_SECRET_DIR"),
+		EncryptionKey: os.Getenv("BINDXDB_ENCRYPTION_KEY"),
+
+		EncryptionKeyFile:   os.Getenv("BINDXDB_ENCRYPTION_KEY_FILE"),
+		EncryptionAlgorithm: os.Getenv("BINDXDB_ENCRYPTION_ALGORITHM"),
+		EnvPrefix:           os.Getenv("BINDXDB_SECRET_PREFIX"),
+		Vault: VaultConfig{
+			Address:   firstNonEmpty(os.Getenv("BINDXDB_VAULT_ADDR"), os.Getenv("VAULT_ADDR")),
+			Token:     firstNonEmpty(os.Getenv("BINDXDB_VAULT_TOKEN"), os.Getenv("VAULT_TOKEN")),
+			MountPath: os.Getenv("BINDXDB_VAULT_MOUNT"),
+
+			AuthMethod: os.Getenv("BINDXDB_VAULT_AUTH"),
+			AuthMount:  os.Getenv("BINDXDB_VAULT_AUTH_MOUNT"),
+			RoleID:     os.Getenv("BINDXDB_VAULT_ROLE_ID"),
+			SecretID:   os.Getenv("BINDXDB_VAULT_SECRET_ID"),
+			Role:       os.Getenv("BINDXDB_VAULT_ROLE"),
+			JWTPath:    os.Getenv("BINDXDB_VAULT_JWT_PATH"),
+		},
+		AWS: AWSOptions{
+			Mode:     AWSSecretMode(os.Getenv("BINDXDB_AWS_SECRET_MODE")),
+			Prefix:   os.Getenv("BINDXDB_AWS_SECRET_PREFIX"),
+			Endpoint: os.Getenv("BINDXDB_AWS_ENDPOINT"),
+		},
+	}
+	if chain := os.Getenv("BINDXDB_SECRET_CHAIN"); chain != "" {
+		cfg.Chain = strings.Split(chain, ",")
+	}
+	if allow, err := strconv.ParseBool(os.Getenv("BINDXDB_SECRET_ALLOW_INSECURE_PERMISSIONS")); err == nil {
+		cfg.AllowInsecurePermissions = allow
+	}
+	if keys := os.Getenv("BINDXDB_DECRYPTION_KEYS"); keys != "" {
+		cfg.DecryptionKeys = strings.Split(keys, ",")
+	}
+	return cfg
+}
+
+// SecretBackendConfigFromManager reads the secrets.* keys from a loaded
+// manager, keeping base for anything not set there. Values such as
+// secrets.vault.token may themselves be secrets served by the bootstrap
+// store.
+func SecretBackendConfigFromManager(m *ConfigManager, base SecretBackendConfig) SecretBackendConfig {
+	cfg := base
+	cfg.Backend = m.GetStringOrDefault("secrets.backend", cfg.Backend)
+	cfg.Dir = m.GetStringOrDefault("secrets.dir", cfg.Dir)
+	cfg.EncryptionKey = m.GetStringOrDefault("secrets.encryption_key", cfg.EncryptionKey)
+	cfg.EncryptionKeyFile = m.GetStringOrDefault("secrets.encryption_key_file", cfg.EncryptionKeyFile)
+	cfg.EncryptionAlgorithm = m.GetStringOrDefault("secrets.encryption_algorithm", cfg.EncryptionAlgorithm)
+	cfg.AllowInsecurePermissions = m.GetBoolOrDefault("secrets.allow_insecure_permissions", cfg.AllowInsecurePermissions)
+	cfg.EnvPrefix = m.GetStringOrDefault("secrets.env.prefix", cfg.EnvPrefix)
+	cfg.Vault.Address = m.GetStringOrDefault("secrets.vault.address", cfg.Vault.Address)
+	cfg.Vault.Token = m.GetStringOrDefault("secrets.vault.token", cfg.Vault.Token)
+	cfg.Vault.MountPath = m.GetStringOrDefault("secrets.vault.mount_path", cfg.Vault.MountPath)
+	cfg.Vault.AuthMethod = m.GetStringOrDefault("secrets.vault.auth.method", cfg.Vault.AuthMethod)
+	cfg.Vault.AuthMount = m.GetStringOrDefault("secrets.vault.auth.mount", cfg.Vault.AuthMount)
+	cfg.Vault.RoleID = m.GetStringOrDefault("secrets.vault.auth.role_id", cfg.Vault.RoleID)
+	cfg.Vault.SecretID = m.GetStringOrDefault("secrets.vault.auth.secret_id", cfg.Vault.SecretID)
+	cfg.Vault.Role = m.GetStringOrDefault("secrets.vault.auth.role", cfg.Vault.Role)
+	cfg.Vault.JWTPath = m.GetStringOrDefault("secrets.vault.auth.jwt_path", cfg.Vault.JWTPath)
+	cfg.AWS.Mode = AWSSecretMode(m.GetStringOrDefault("secrets.aws.mode", string(cfg.AWS.Mode)))
+	cfg.AWS.Region = m.GetStringOrDefault("secrets.aws.region", cfg.AWS.Region)
+	cfg.AWS.Prefix = m.GetStringOrDefault("secrets.aws.prefix", cfg.AWS.Prefix)
+	cfg.AWS.Endpoint = m.GetStringOrDefault("secrets.aws.endpoint", cfg.AWS.Endpoint)
+	if chain, err := m.GetStringSlice("secrets.chain"); err == nil && len(chain) > 0 {
+		cfg.Chain = chain
+	}
+	if keys, err := m.GetStringSlice("secrets.decryption_keys"); err == nil && len(keys) > 0 {
+		cfg.DecryptionKeys = keys
+	}
+	return cfg
+}
+
+// NewSecretStore builds the store described by cfg.
+func NewSecretStore(cfg SecretBackendConfig, logger Logger) (SecretStore, error) {
+	switch backend := strings.TrimSpace(cfg.Backend); backend {
+	case "", "file":
+		key := []byte(cfg.EncryptionKey)
+		if cfg.EncryptionKeyFile != "" {
+			var err error
+			if key, err = KeyFromFile(cfg.EncryptionKeyFile); err != nil {
+				return nil, err
+			}
+		}
+		alg, err := ParseSecretAlgorithm(cfg.EncryptionAlgorithm)
+		if err != nil {
+			return nil, err
+		}
+		encryption, err := NewEncryption(alg, key)
+		if err != nil {
+			return nil, err
+		}
+		dir := cfg.Dir
+		if dir == "" {
+			dir = "/etc/bindxdb/secrets"
+		}
+		var opts []FileSecretStoreOption
+		if cfg.AllowInsecurePermissions {
+			opts = append(opts, AllowInsecurePermissions())
+		}
+		store, err := NewFileSecretStore(dir, encryption, logger, opts...)
+		if err != nil {
+			return nil, err
+		}
+		// Every key can read what any algorithm wrote with it, so switching
+		// algorithms needs no migration.
+		decryptionKeys := append([]string{string(key)}, cfg.DecryptionKeys...)
+		for i, previousKey := range decryptionKeys {
+			for _, previousAlg := range secretAlgorithms {
+				if i == 0 && previousAlg == alg {
+					continue
+				}
+				previous, err := NewEncryption(previousAlg, []byte(previousKey))
+				if err != nil {
+					return nil, err
+				}
+				if err := store.AddDecryptionKey("", previous); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return store, nil
+	case "vault":
+		if cfg.Vault.Address == "" {
+			return nil, fmt.Errorf("vault secret backend requires an address")
+		}
+		return NewVaultSecretStoreFromConfig(cfg.Vault, logger)
+	case "aws":
+		return NewAWSSecretStore(cfg.AWS, logger)
+	case "env":
+		prefix, _ := cfg.envSecretPrefix()
+		store := NewEnvSecretStore(prefix)
+		store.reserved = make(map[string]bool, len(secretBackendEnvVars))
+		for _, name := range secretBackendEnvVars {
+			store.reserved[name] = true
+		}
+		return store, nil
+	case "memory":
+		return NewMemorySecretStore(), nil
+	case "chain":
+		if len(cfg.Chain) == 0 {
+			return nil, fmt.Errorf("chain secret backend requires at least one backend")
+		}
+		stores := make([]SecretStore, 0, len(cfg.Chain))
+		for _, name := range cfg.Chain {
+			name = strings.TrimSpace(name)
+			if name == "chain" {
+				return nil, fmt.Errorf("chain secret backend cannot contain itself")
+			}
+			member := cfg
+			member.Backend = name
+			store, err := NewSecretStore(member, logger)
+			if errors.Is(err, ErrNoEncryptionKey) && len(cfg.Chain) > 1 {
+				if logger != nil {
+					logger.Warn("skipping secret backend without an encryption key", "backend", name)
+				}
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("secret backend %s: %w", name, err)
+			}
+			stores = append(stores, store)
+		}
+		return NewChainSecretStore(stores...), nil
+	default:
+		return nil, fmt.Errorf("unknown secret backend %q", backend)
+	}
+}
+
+// envSecretPrefix returns the variable prefix of the env backend when cfg
+// uses it, directly or as part of a chain.
+func (cfg SecretBackendConfig) envSecretPrefix() (string, bool) {
+	uses := cfg.Backend == "env"
+	if cfg.Backend == "chain" {
+		for _, name := range cfg.Chain {
+			if strings.TrimSpace(name) == "env" {
+				uses = true
+			}
+		}
+	}
+	if cfg.EnvPrefix == "" {
+		return "BINDXDB_SECRET_", uses
+	}
+	return cfg.EnvPrefix, uses
+}
+
+// SecretStore returns the store secret values are read from, or nil when
+// secrets are disabled.
+func (m *ConfigManager) SecretStore() SecretStore {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.secretStore
+}
+
+// SetSecretStore replaces the store secret values are read from, for
+// example once the loaded config names a different backend than the one
+// used to bootstrap.
+func (m *ConfigManager) SetSecretStore(store SecretStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.secretStore = store
+	m.secretsDisabled = nil
+	if setter, ok := store.(auditSetter); ok && m.audit != nil {
+		setter.SetAuditSink(m.audit)
+	}
+}
+
+// SecretStoreHealth is what a secret store that authenticates against a
+// remote service reports about its credentials.
+type SecretStoreHealth struct {
+	Backend   string
+	Healthy   bool
+	LastError error
+	LastAuth  time.Time
+	// Expires is when the current credentials run out, if they do.
+	Expires time.Time
+}
+
+// SecretHealthReporter is implemented by secret stores that can report
+// SecretStoreHealth.
+type SecretHealthReporter interface {
+	Health() SecretStoreHealth
+}
+
+// secretStoreHealth collects the health of store and, for a chain, of every
+// store in it.
+func secretStoreHealth(store SecretStore) []SecretStoreHealth {
+	switch s := store.(type) {
+	case *ChainSecretStore:
+		var health []SecretStoreHealth
+		for _, member := range s.stores {
+			health = append(health, secretStoreHealth(member)...)
+		}
+		return health
+	case SecretHealthReporter:
+		return []SecretStoreHealth{s.Health()}
+	}
+	return nil
+}
+
+var ErrSecretsDisabled = errors.New("secrets are disabled")
+
+// SecretsDisabled returns why the manager has no secret store, or nil when
+// secrets are enabled or were never configured.
+func (m *ConfigManager) SecretsDisabled() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.secretsDisabled
+}
+
+func (m *ConfigManager) disableSecrets(reason error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.secretStore = nil
+	m.secretsDisabled = reason
+}
+
+// SecretStoreHealth reports the health of the manager's secret store, for
+// a MonitoringPlugin's HealthCheck. Stores that don't authenticate aren't
+// listed.
+func (m *ConfigManager) SecretStoreHealth() []SecretStoreHealth {
+	m.mu.RLock()
+	store, disabled := m.secretStore, m.secretsDisabled
+	m.mu.RUnlock()
+	if disabled != nil {
+		return []SecretStoreHealth{{Backend: "disabled", LastError: disabled}}
+	}
+	return secretStoreHealth(store)
+}
+
+// MemorySecretStore keeps secrets in memory, for tests and local runs.
+type MemorySecretStore struct {
+	secrets map[string]string
+	mu      sync.RWMutex
+}
+
+func NewMemorySecretStore() *MemorySecretStore {
+	return &MemorySecretStore{secrets: make(map[string]string)}
+}
+
+func (s *MemorySecretStore) GetSecret(key string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	value, ok := s.secrets[key]
+	if !ok {
+		return "", fmt.Errorf("secret %s not found", key)
+	}
+	return value, nil
+}
+
+func (s *MemorySecretStore) SetSecret(key string, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.secrets[key] = value
+	return nil
+}
+
+func (s *MemorySecretStore) DeleteSecret(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.secrets, key)
+	return nil
+}
+
+func (s *MemorySecretStore) ListSecrets() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]string, 0, len(s.secrets))
+	for key := range s.secrets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ChainSecretStore reads a secret from the first store that has it and
+// writes to the first store that isn't read-only.
+type ChainSecretStore struct {
+	stores []SecretStore
+}
+
+func NewChainSecretStore(stores ...SecretStore) *ChainSecretStore {
+	return &ChainSecretStore{stores: stores}
+}
+
+func (s *ChainSecretStore) GetSecret(key string) (string, error) {
+	return s.GetSecretContext(context.Background(), key)
+}
+
+func (s *ChainSecretStore) SetSecret(key string, value string) error {
+	return s.SetSecretContext(context.Background(), key, value)
+}
+
+// DeleteSecret removes key from every writable store, so a lower store
+// can't keep serving a deleted secret.
+func (s *ChainSecretStore) DeleteSecret(key string) error {
+	return s.DeleteSecretContext(context.Background(), key)
+}
+
+func (s *ChainSecretStore) SetAuditSink(sink AuditSink) {
+	for _, store := range s.stores {
+		if setter, ok := store.(auditSetter); ok {
+			setter.SetAuditSink(sink)
+		}
+	}
+}
+
+// Close closes every store in the chain that holds resources.
+func (s *ChainSecretStore) Close() error {
+	var multiErr MultiError
+	for _, store := range s.stores {
+		if closer, ok := store.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				multiErr.Add(err)
+			}
+		}
+	}
+	if multiErr.HasErrors() {
+		return &multiErr
+	}
+	return nil
+}
+
+func (s *ChainSecretStore) ListSecrets() ([]string, error) {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, store := range s.stores {
+		listed, err := store.ListSecrets()
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range listed {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
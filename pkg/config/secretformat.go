@@ -0,0 +1,250 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// SecretAlgorithm identifies the cipher a secret file was encrypted with.
+type SecretAlgorithm uint8
+
+const (
+	// AlgorithmUnknown marks files written by an Encryption that doesn't
+	// report its algorithm.
+	AlgorithmUnknown           SecretAlgorithm = 0
+	AlgorithmAES256GCM         SecretAlgorithm = 1
+	AlgorithmXChaCha20Poly1305 SecretAlgorithm = 2
+)
+
+func (a SecretAlgorithm) String() string {
+	switch a {
+	case AlgorithmAES256GCM:
+		return "aes-256-gcm"
+	case AlgorithmXChaCha20Poly1305:
+		return "xchacha20-poly1305"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(a))
+	}
+}
+
+// ParseSecretAlgorithm accepts the names String returns. An empty name is
+// AES-256-GCM.
+func ParseSecretAlgorithm(name string) (SecretAlgorithm, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "aes", "aes-256-gcm":
+		return AlgorithmAES256GCM, nil
+	case "chacha20", "chacha20-poly1305", "xchacha20-poly1305":
+		return AlgorithmXChaCha20Poly1305, nil
+	default:
+		return AlgorithmUnknown, fmt.Errorf("unknown encryption algorithm %q", name)
+	}
+}
+
+// NewEncryption returns the Encryption for alg with key.
+func NewEncryption(alg SecretAlgorithm, key []byte) (Encryption, error) {
+	switch alg {
+	case AlgorithmAES256GCM:
+		return NewAESEncryption(key)
+	case AlgorithmXChaCha20Poly1305:
+		return NewChaCha20Encryption(key)
+	default:
+		return nil, fmt.Errorf("unsupported encryption algorithm %s", alg)
+	}
+}
+
+// secretAlgorithms lists every algorithm NewEncryption supports.
+var secretAlgorithms = []SecretAlgorithm{AlgorithmAES256GCM, AlgorithmXChaCha20Poly1305}
+
+// secretFileMagic starts every secret file written since the header was
+// introduced. Files without it are legacy bare ciphertext.
+var secretFileMagic = []byte("BXSF")
+
+// Version 2 files authenticate their header and secret key as associated
+// data, so a ciphertext copied to another file no longer decrypts. Version 1
+// is still written by encryptions without ContextEncryption.
+const (
+	secretFileVersionNoAAD = 1
+	secretFileVersion      = 2
+)
+
+// secretHeader precedes the ciphertext in a secret file:
+//
+//	magic "BXSF" | version (1 byte) | algorithm (1 byte) | key ID length (1 byte) | key ID
+type secretHeader struct {
+	Version   uint8
+	Algorithm SecretAlgorithm
+	KeyID     string
+}
+
+func (h secretHeader) encode() []byte {
+	buf := make([]byte, 0, len(secretFileMagic)+3+len(h.KeyID))
+	buf = append(buf, secretFileMagic...)
+	buf = append(buf, h.Version, byte(h.Algorithm), byte(len(h.KeyID)))
+	return append(buf, h.KeyID...)
+}
+
+// parseSecretHeader splits data into its header and ciphertext. ok is false
+// for legacy files without a header.
+func parseSecretHeader(data []byte) (header secretHeader, ciphertext []byte, ok bool, err error) {
+	if !bytes.HasPrefix(data, secretFileMagic) {
+		return secretHeader{}, data, false, nil
+	}
+	rest := data[len(secretFileMagic):]
+	if len(rest) < 3 {
+		return secretHeader{}, nil, true, fmt.Errorf("truncated secret header")
+	}
+	header.Version = rest[0]
+	header.Algorithm = SecretAlgorithm(rest[1])
+	idLen := int(rest[2])
+	rest = rest[3:]
+	if header.Version != secretFileVersion && header.Version != secretFileVersionNoAAD {
+		return secretHeader{}, nil, true, fmt.Errorf("unsupported secret file version %d", header.Version)
+	}
+	if len(rest) < idLen {
+		return secretHeader{}, nil, true, fmt.Errorf("truncated secret header")
+	}
+	header.KeyID = string(rest[:idLen])
+	return header, rest[idLen:], true, nil
+}
+
+// encryptionKeyID returns the ID enc reports through a KeyID method, or
+// "default".
+func encryptionKeyID(enc Encryption) string {
+	if keyed, ok := enc.(interface{ KeyID() string }); ok {
+		return keyed.KeyID()
+	}
+	return "default"
+}
+
+func encryptionAlgorithm(enc Encryption) SecretAlgorithm {
+	if alg, ok := enc.(interface{ Algorithm() SecretAlgorithm }); ok {
+		return alg.Algorithm()
+	}
+	return AlgorithmUnknown
+}
+
+// KeyID is a fingerprint of the key, so files can name the key that
+// encrypted them without revealing it.
+func (e *AESEncryption) KeyID() string {
+	sum := sha256.Sum256(append([]byte("bindxdb-key-id:"), e.key...))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (e *AESEncryption) Algorithm() SecretAlgorithm {
+	return AlgorithmAES256GCM
+}
+
+// AddDecryptionKey lets the store read files encrypted with enc, for
+// example the previous key after a rotation. The key ID defaults to the
+// one enc reports.
+func (s *FileSecretStore) AddDecryptionKey(id string, enc Encryption) error {
+	if id == "" {
+		id = encryptionKeyID(enc)
+	}
+	if len(id) > 255 {
+		return fmt.Errorf("key ID %q is longer than 255 bytes", id)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[id] = enc
+	return nil
+}
+
+// SetEncryptionKey makes enc the key new secrets are written with. The
+// previous key stays available for reading.
+func (s *FileSecretStore) SetEncryptionKey(id string, enc Encryption) error {
+	if id == "" {
+		id = encryptionKeyID(enc)
+	}
+	if err := s.AddDecryptionKey(id, enc); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encryption = enc
+	s.keyID = id
+	return nil
+}
+
+// secretAAD is the associated data of a version 2 file: its header and the
+// key the secret was set with.
+func secretAAD(header secretHeader, key string) []byte {
+	return append(header.encode(), key...)
+}
+
+func (s *FileSecretStore) seal(key string, plaintext []byte) ([]byte, error) {
+	s.mu.RLock()
+	enc, keyID := s.encryption, s.keyID
+	s.mu.RUnlock()
+
+	header := secretHeader{
+		Version:   secretFileVersionNoAAD,
+		Algorithm: encryptionAlgorithm(enc),
+		KeyID:     keyID,
+	}
+	var ciphertext []byte
+	var err error
+	if ctxEnc, ok := enc.(ContextEncryption); ok {
+		header.Version = secretFileVersion
+		ciphertext, err = ctxEnc.EncryptWithContext(plaintext, secretAAD(header, key))
+	} else {
+		ciphertext, err = enc.Encrypt(plaintext)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(header.encode(), ciphertext...), nil
+}
+
+// open decrypts the contents of the file holding key. Legacy files don't
+// say which key they were written with, so every known key is tried, the
+// current one first.
+func (s *FileSecretStore) open(key string, data []byte) ([]byte, error) {
+	header, ciphertext, ok, err := parseSecretHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	current := s.encryption
+	keys := make(map[string]Encryption, len(s.keys))
+	for id, enc := range s.keys {
+		keys[id] = enc
+	}
+	s.mu.RUnlock()
+
+	if ok {
+		enc, known := keys[header.KeyID]
+		if !known {
+			return nil, fmt.Errorf("secret was encrypted with unknown key %s", header.KeyID)
+		}
+		if alg := encryptionAlgorithm(enc); alg != header.Algorithm {
+			return nil, fmt.Errorf("secret was encrypted with %s but key %s is %s", header.Algorithm, header.KeyID, alg)
+		}
+		if header.Version == secretFileVersionNoAAD {
+			return enc.Decrypt(ciphertext)
+		}
+		ctxEnc, ok := enc.(ContextEncryption)
+		if !ok {
+			return nil, fmt.Errorf("key %s cannot decrypt secrets with associated data", header.KeyID)
+		}
+		return ctxEnc.DecryptWithContext(ciphertext, secretAAD(header, key))
+	}
+
+	plaintext, err := current.Decrypt(ciphertext)
+	if err == nil {
+		return plaintext, nil
+	}
+	for _, enc := range keys {
+		if enc == current {
+			continue
+		}
+		if plaintext, legacyErr := enc.Decrypt(ciphertext); legacyErr == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, err
+}
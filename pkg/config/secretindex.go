@@ -0,0 +1,170 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// secretIndexFile maps the sanitized file names of a FileSecretStore back to
+// the keys they were set with.
+const secretIndexFile = ".index.json"
+
+type secretIndexEntry struct {
+	Key string `json:"key"`
+	// Inferred marks entries migrated from a store written before the
+	// index existed, whose key is only the file name. The real key
+	// replaces it the first time it is used.
+	Inferred bool `json:"inferred,omitempty"`
+
+	Created        time.Time     `json:"created,omitzero"`
+	Updated        time.Time     `json:"updated,omitzero"`
+	RotationPeriod time.Duration `json:"rotation_period,omitempty"`
+	Expires        time.Time     `json:"expires,omitzero"`
+}
+
+// loadIndex reads the index on first use. The caller must hold s.indexMu.
+func (s *FileSecretStore) loadIndex() error {
+	if s.index != nil {
+		return nil
+	}
+	index := make(map[string]secretIndexEntry)
+	data, err := os.ReadFile(filepath.Join(s.basePath, secretIndexFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read secret index: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &index); err != nil {
+			return fmt.Errorf("failed to parse secret index: %w", err)
+		}
+	}
+	s.index = index
+	return nil
+}
+
+// saveIndex writes the index. The caller must hold s.indexMu.
+func (s *FileSecretStore) saveIndex() error {
+	data, err := json.MarshalIndent(s.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeSecretFile(filepath.Join(s.basePath, secretIndexFile), data); err != nil {
+		return fmt.Errorf("failed to write secret index: %w", err)
+	}
+	return nil
+}
+
+// claimFileName returns the file name for key and records it in the index
+// as updated now. Two keys that sanitize to the same name can't both be
+// stored.
+func (s *FileSecretStore) claimFileName(key string, now time.Time) (string, error) {
+	name := sanitizeKey(key)
+	if err := s.loadIndex(); err != nil {
+		return "", err
+	}
+	entry, exists := s.index[name]
+	if exists && !entry.Inferred && entry.Key != key {
+		return "", &ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("secret file name %s is already used by %s", name, entry.Key),
+		}
+	}
+	entry.Key = key
+	entry.Inferred = false
+	if entry.Created.IsZero() {
+		entry.Created = now
+	}
+	entry.Updated = now
+	s.index[name] = entry
+	if err := s.saveIndex(); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// learnKey records the original key of a file read before the index knew
+// about it.
+func (s *FileSecretStore) learnKey(key string) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	if err := s.loadIndex(); err != nil {
+		return
+	}
+	name := sanitizeKey(key)
+	entry, exists := s.index[name]
+	if exists && !entry.Inferred {
+		return
+	}
+	entry.Key = key
+	entry.Inferred = false
+	s.index[name] = entry
+	if err := s.saveIndex(); err != nil && s.logger != nil {
+		s.logger.Warn("failed to update secret index", "key", key, "error", err)
+	}
+}
+
+func (s *FileSecretStore) forgetKey(key string) error {
+	if err := s.loadIndex(); err != nil {
+		return err
+	}
+	name := sanitizeKey(key)
+	if _, exists := s.index[name]; !exists {
+		return nil
+	}
+	delete(s.index, name)
+	return s.saveIndex()
+}
+
+// listIndexed returns the original keys of every secret file. Files the
+// index doesn't know yet are added under their file name.
+func (s *FileSecretStore) listIndexed(names []string) ([]string, error) {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	if err := s.loadIndex(); err != nil {
+		return nil, err
+	}
+
+	present := make(map[string]bool, len(names))
+	changed := false
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		present[name] = true
+		entry, exists := s.index[name]
+		if !exists {
+			entry = secretIndexEntry{Key: name, Inferred: true}
+			s.index[name] = entry
+			changed = true
+		}
+		keys = append(keys, entry.Key)
+	}
+	// Drop entries whose files were removed behind the store's back.
+	for name := range s.index {
+		if !present[name] {
+			delete(s.index, name)
+			changed = true
+		}
+	}
+	if changed {
+		if err := s.saveIndex(); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func secretFileNames(entries []os.DirEntry) []string {
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".enc") {
+			names = append(names, strings.TrimSuffix(entry.Name(), ".enc"))
+		}
+	}
+	return names
+}
@@ -0,0 +1,242 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// SecretMetadata describes when a secret was written and when it should be
+// replaced. Zero values mean unknown or not set.
+type SecretMetadata struct {
+	Created time.Time
+	Updated time.Time
+	// RotationPeriod is how long a value may be used before it is due for
+	// rotation, counted from Updated.
+	RotationPeriod time.Duration
+	// Expires is when the secret itself stops working, such as the end of
+	// a certificate's validity.
+	Expires time.Time
+}
+
+// RotationDue returns when the secret should next be rotated, or the zero
+// time when it has no rotation period.
+func (m SecretMetadata) RotationDue() time.Time {
+	if m.RotationPeriod <= 0 || m.Updated.IsZero() {
+		return time.Time{}
+	}
+	return m.Updated.Add(m.RotationPeriod)
+}
+
+// SecretStoreWithMetadata is implemented by secret stores that track
+// SecretMetadata. Setting a secret refreshes Created and Updated;
+// SetSecretMetadata only changes RotationPeriod and Expires.
+type SecretStoreWithMetadata interface {
+	SecretStore
+	GetSecretMetadata(key string) (SecretMetadata, error)
+	SetSecretMetadata(key string, meta SecretMetadata) error
+}
+
+// secretExpiryWarning is how far ahead of Expires ValidateAll starts
+// warning.
+const secretExpiryWarning = 7 * 24 * time.Hour
+
+// secretRotationTargets returns the secret keys in sorted order and the
+// store to check them against, or a nil store when it keeps no metadata.
+// The caller must hold m.mu.
+func (m *ConfigManager) secretRotationTargets() (SecretStoreWithMetadata, []string) {
+	store, ok := m.secretStore.(SecretStoreWithMetadata)
+	if !ok {
+		return nil, nil
+	}
+	keys := make([]string, 0, len(m.values))
+	for key, value := range m.values {
+		if value.IsSecret {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return store, keys
+}
+
+// warnSecretRotation warns about every one of keys that is past its
+// rotation due date or close to expiring. It calls the store once per key,
+// so it must not be called with m.mu held.
+func warnSecretRotation(report *ValidationReport, store SecretStoreWithMetadata, keys []string, logger Logger) {
+	if store == nil {
+		return
+	}
+	now := time.Now()
+	for _, key := range keys {
+		meta, err := store.GetSecretMetadata(key)
+		if err != nil {
+			logger.Debug("no metadata for secret", "key", key, "error", err)
+			continue
+		}
+		if due := meta.RotationDue(); !due.IsZero() && now.After(due) {
+			report.warn(key, fmt.Sprintf("secret was due for rotation at %s", due.Format(time.RFC3339)))
+		}
+		if !meta.Expires.IsZero() && now.Add(secretExpiryWarning).After(meta.Expires) {
+			report.warn(key, fmt.Sprintf("secret expires at %s", meta.Expires.Format(time.RFC3339)))
+		}
+	}
+}
+
+func (s *FileSecretStore) GetSecretMetadata(key string) (SecretMetadata, error) {
+	if err := s.checkPermissions(); err != nil {
+		return SecretMetadata{}, err
+	}
+	name := sanitizeKey(key)
+	info, err := os.Stat(filepath.Join(s.basePath, name+".enc"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return SecretMetadata{}, fmt.Errorf("secret %s not found", key)
+		}
+		return SecretMetadata{}, fmt.Errorf("failed to stat secret file: %w", err)
+	}
+
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if err := s.loadIndex(); err != nil {
+		return SecretMetadata{}, err
+	}
+	entry := s.index[name]
+	meta := SecretMetadata{
+		Created:        entry.Created,
+		Updated:        entry.Updated,
+		RotationPeriod: entry.RotationPeriod,
+		Expires:        entry.Expires,
+	}
+	// Secrets written before the index kept timestamps only have the
+	// file's modification time.
+	if meta.Updated.IsZero() {
+		meta.Updated = info.ModTime()
+	}
+	return meta, nil
+}
+
+func (s *FileSecretStore) SetSecretMetadata(key string, meta SecretMetadata) error {
+	if err := s.checkPermissions(); err != nil {
+		return err
+	}
+	name := sanitizeKey(key)
+	if _, err := os.Stat(filepath.Join(s.basePath, name+".enc")); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("secret %s not found", key)
+		}
+		return fmt.Errorf("failed to stat secret file: %w", err)
+	}
+
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if err := s.loadIndex(); err != nil {
+		return err
+	}
+	entry, exists := s.index[name]
+	if exists && !entry.Inferred && entry.Key != key {
+		return &ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("secret file name %s is already used by %s", name, entry.Key),
+		}
+	}
+	entry.Key = key
+	entry.Inferred = false
+	entry.RotationPeriod = meta.RotationPeriod
+	entry.Expires = meta.Expires
+	s.index[name] = entry
+	return s.saveIndex()
+}
+
+// Custom metadata fields the Vault store keeps next to each secret.
+const (
+	vaultRotationPeriodField = "rotation_period"
+	vaultExpiresField        = "expires"
+)
+
+func (s *VaultSecretStore) GetSecretMetadata(key string) (SecretMetadata, error) {
+	secret, err := s.do(func() (*vault.Secret, error) {
+		return s.client.Logical().Read(fmt.Sprintf("%s/metadata/%s", s.mountPath, key))
+	})
+	if err != nil {
+		return SecretMetadata{}, fmt.Errorf("failed to read metadata from Vault: %w", err)
+	}
+	if secret == nil || secret.Data == nil {
+		return SecretMetadata{}, fmt.Errorf("secret %s not found", key)
+	}
+
+	var meta SecretMetadata
+	if created, ok := secret.Data["created_time"].(string); ok {
+		meta.Created, _ = time.Parse(time.RFC3339Nano, created)
+	}
+	if updated, ok := secret.Data["updated_time"].(string); ok {
+		meta.Updated, _ = time.Parse(time.RFC3339Nano, updated)
+	}
+	custom, _ := secret.Data["custom_metadata"].(map[string]interface{})
+	if period, ok := custom[vaultRotationPeriodField].(string); ok {
+		if meta.RotationPeriod, err = time.ParseDuration(period); err != nil {
+			return SecretMetadata{}, fmt.Errorf("invalid %s for secret %s: %w", vaultRotationPeriodField, key, err)
+		}
+	}
+	if expires, ok := custom[vaultExpiresField].(string); ok {
+		if meta.Expires, err = time.Parse(time.RFC3339, expires); err != nil {
+			return SecretMetadata{}, fmt.Errorf("invalid %s for secret %s: %w", vaultExpiresField, key, err)
+		}
+	}
+	return meta, nil
+}
+
+// SetSecretMetadata stores RotationPeriod and Expires as custom metadata.
+// Vault keeps the timestamps itself.
+func (s *VaultSecretStore) SetSecretMetadata(key string, meta SecretMetadata) error {
+	custom := map[string]interface{}{}
+	if meta.RotationPeriod > 0 {
+		custom[vaultRotationPeriodField] = meta.RotationPeriod.String()
+	}
+	if !meta.Expires.IsZero() {
+		custom[vaultExpiresField] = meta.Expires.UTC().Format(time.RFC3339)
+	}
+	data := map[string]interface{}{"custom_metadata": custom}
+
+	_, err := s.do(func() (*vault.Secret, error) {
+		return s.client.Logical().Write(fmt.Sprintf("%s/metadata/%s", s.mountPath, key), data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write metadata to Vault: %w", err)
+	}
+	return nil
+}
+
+// GetSecretMetadata returns the metadata of the first store that has the
+// secret and tracks metadata.
+func (s *ChainSecretStore) GetSecretMetadata(key string) (SecretMetadata, error) {
+	var multiErr MultiError
+	for _, store := range s.stores {
+		metaStore, ok := store.(SecretStoreWithMetadata)
+		if !ok {
+			continue
+		}
+		meta, err := metaStore.GetSecretMetadata(key)
+		if err == nil {
+			return meta, nil
+		}
+		multiErr.Add(err)
+	}
+	if !multiErr.HasErrors() {
+		return SecretMetadata{}, fmt.Errorf("no store keeps metadata for secret %s", key)
+	}
+	return SecretMetadata{}, fmt.Errorf("metadata for secret %s not found in any store: %w", key, &multiErr)
+}
+
+// SetSecretMetadata writes to the first store that tracks metadata.
+func (s *ChainSecretStore) SetSecretMetadata(key string, meta SecretMetadata) error {
+	for _, store := range s.stores {
+		if metaStore, ok := store.(SecretStoreWithMetadata); ok {
+			return metaStore.SetSecretMetadata(key, meta)
+		}
+	}
+	return fmt.Errorf("no store keeps metadata for secret %s", key)
+}
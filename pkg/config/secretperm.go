@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileSecretStoreOption configures a FileSecretStore.
+type FileSecretStoreOption func(*FileSecretStore)
+
+// AllowInsecurePermissions lets the store run on a directory that group or
+// others can read, logging a warning instead of refusing. Containers that
+// mount secrets through a volume with a fixed mode may need it.
+func AllowInsecurePermissions() FileSecretStoreOption {
+	return func(s *FileSecretStore) {
+		s.allowInsecure = true
+	}
+}
+
+// checkPermissions refuses a base directory that group or others can
+// access, unless AllowInsecurePermissions was given.
+func (s *FileSecretStore) checkPermissions() error {
+	info, err := os.Stat(s.basePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat secret store directory: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("secret store path %s is not a directory", s.basePath)
+	}
+	perm := info.Mode().Perm()
+	if perm&0077 == 0 {
+		return nil
+	}
+	if !s.allowInsecure {
+		return fmt.Errorf("secret store directory %s has mode %04o, it must not be accessible by group or others (use 0700)", s.basePath, perm)
+	}
+	s.insecureWarning.Do(func() {
+		if s.logger != nil {
+			s.logger.Warn("secret store directory is accessible by group or others", "path", s.basePath, "mode", fmt.Sprintf("%04o", perm))
+		}
+	})
+	return nil
+}
+
+// removeStaleTempFiles deletes temporary files left behind by a Set that
+// was interrupted before its rename.
+func (s *FileSecretStore) removeStaleTempFiles() {
+	matches, err := filepath.Glob(filepath.Join(s.basePath, ".*.tmp*"))
+	if err != nil {
+		return
+	}
+	for _, path := range matches {
+		if strings.Contains(filepath.Base(path), ".enc.tmp") || strings.Contains(filepath.Base(path), secretIndexFile+".tmp") {
+			os.Remove(path)
+		}
+	}
+}
+
+// writeSecretFile replaces path atomically and makes sure it ends up 0600,
+// whatever the mode of the file it replaces.
+func writeSecretFile(path string, data []byte) error {
+	if err := os.Chmod(path, 0600); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	return writeFileAtomicPerm(path, data, 0600)
+}
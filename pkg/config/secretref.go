@@ -0,0 +1,60 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const secretRefPrefix = "${secret:"
+
+func hasSecretRef(value interface{}) bool {
+	str, ok := value.(string)
+	return ok && strings.Contains(str, secretRefPrefix)
+}
+
+// resolveSecretRefs replaces every ${secret:name} reference in a string value
+// with the named secret. The resolved plaintext is returned to the caller
+// only and never stored back into the manager. store and disabled are the
+// manager's secretStore and secretsDisabled, read by the caller so that m.mu
+// need not be held while the store is called.
+func (m *ConfigManager) resolveSecretRefs(ctx context.Context, store SecretStore, disabled error, key string, value string) (string, error) {
+	var b strings.Builder
+	rest := value
+	for {
+		start := strings.Index(rest, secretRefPrefix)
+		if start < 0 {
+			b.WriteString(rest)
+			return b.String(), nil
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return "", &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("unterminated secret reference in %q", value),
+			}
+		}
+
+		secretKey := rest[start+len(secretRefPrefix) : start+end]
+		if store == nil {
+			return "", &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("cannot resolve secret %s: no secret store configured", secretKey),
+				Err:     disabled,
+			}
+		}
+		secret, err := readSecret(ctx, store, secretKey)
+		m.auditResolve(ctx, key, secretKey, err)
+		if err != nil {
+			return "", &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("failed to resolve secret %s", secretKey),
+				Err:     err,
+			}
+		}
+
+		b.WriteString(rest[:start])
+		b.WriteString(secret)
+		rest = rest[start+end+1:]
+	}
+}
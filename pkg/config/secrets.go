@@ -1,16 +1,19 @@
 package config
 
 import (
+	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -28,9 +31,18 @@ type SecretStores interface {
 type FileSecretStore struct {
 	basePath   string
 	encryption Encryption
+	keyID      string
+	keys       map[string]Encryption
 	cache      map[string]cachedSecret
 	mu         sync.RWMutex
 	logger     Logger
+
+	index   map[string]secretIndexEntry
+	indexMu sync.Mutex
+	audit   AuditSink
+
+	allowInsecure   bool
+	insecureWarning sync.Once
 }
 
 type cachedSecret struct {
@@ -43,21 +55,60 @@ type Encryption interface {
 	Decrypt(ciphertext []byte) ([]byte, error)
 }
 
+// ContextEncryption is implemented by encryptions that can authenticate
+// associated data with the ciphertext, which then only decrypts with the
+// same data. FileSecretStore binds each file to its key this way.
+type ContextEncryption interface {
+	Encryption
+	EncryptWithContext(plaintext, aad []byte) ([]byte, error)
+	DecryptWithContext(ciphertext, aad []byte) ([]byte, error)
+}
+
 type AESEncryption struct {
 	key []byte
 }
 
+// MinEncryptionKeyLength is the shortest key NewAESEncryption accepts.
+// Keys that aren't exactly 32 bytes are stretched with SHA-256, which makes
+// a short or empty key trivially guessable.
+const MinEncryptionKeyLength = 16
+
+var ErrNoEncryptionKey = errors.New("no encryption key configured")
+
 func NewAESEncryption(key []byte) (*AESEncryption, error) {
-	if len(key) != 32 {
-		hash := sha256.Sum256(key)
-		key = hash[:]
+	key, err := deriveKey(key)
+	if err != nil {
+		return nil, err
 	}
 	return &AESEncryption{
 		key: key,
 	}, nil
 }
 
+// deriveKey checks key and stretches it to 32 bytes.
+func deriveKey(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrNoEncryptionKey
+	}
+	if len(key) < MinEncryptionKeyLength {
+		return nil, fmt.Errorf("encryption key must be at least %d bytes, got %d", MinEncryptionKeyLength, len(key))
+	}
+	if len(key) != 32 {
+		hash := sha256.Sum256(key)
+		key = hash[:]
+	}
+	return key, nil
+}
+
 func (e *AESEncryption) Encrypt(plaintext []byte) ([]byte, error) {
+	return e.EncryptWithContext(plaintext, nil)
+}
+
+func (e *AESEncryption) Decrypt(ciphertext []byte) ([]byte, error) {
+	return e.DecryptWithContext(ciphertext, nil)
+}
+
+func (e *AESEncryption) EncryptWithContext(plaintext, aad []byte) ([]byte, error) {
 	block, err := aes.NewCipher(e.key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cipher: %w", err)
@@ -66,15 +117,10 @@ func (e *AESEncryption) Encrypt(plaintext []byte) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate nonce: %w", err)
 	}
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, fmt.Errorf("failed to generate nonce: %w", err)
-	}
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
-	return ciphertext, nil
+	return sealAEAD(gcm, plaintext, aad)
 }
 
-func (e *AESEncryption) Decrypt(ciphertext []byte) ([]byte, error) {
+func (e *AESEncryption) DecryptWithContext(ciphertext, aad []byte) ([]byte, error) {
 	block, err := aes.NewCipher(e.key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create cipher: %w", err)
@@ -83,32 +129,78 @@ func (e *AESEncryption) Decrypt(ciphertext []byte) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
-	if len(ciphertext) < gcm.NonceSize() {
+	return openAEAD(gcm, ciphertext, aad)
+}
+
+// sealAEAD encrypts plaintext under a random nonce, which is prepended to
+// the result.
+func sealAEAD(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openAEAD(aead cipher.AEAD, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < aead.NonceSize() {
 		return nil, fmt.Errorf("ciphertext too short")
 	}
-	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, aad)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt: %w", err)
 	}
 	return plaintext, nil
+}
 
+// KeyFromFile reads an encryption key from path, which must not be
+// readable by group or others. Surrounding whitespace, such as the trailing
+// newline most editors add, is dropped.
+func KeyFromFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat key file: %w", err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return nil, fmt.Errorf("key file %s has mode %04o, it must not be accessible by group or others (use 0400)", path, perm)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) == 0 {
+		return nil, fmt.Errorf("key file %s is empty: %w", path, ErrNoEncryptionKey)
+	}
+	return key, nil
 }
 
-func NewFileSecretStore(basePath string, encryption Encryption, logger Logger) (*FileSecretStore, error) {
+func NewFileSecretStore(basePath string, encryption Encryption, logger Logger, opts ...FileSecretStoreOption) (*FileSecretStore, error) {
 	if err := os.MkdirAll(basePath, 0700); err != nil {
 		return nil, fmt.Errorf("failed to create secret store directory: %w", err)
 	}
 
-	return &FileSecretStore{
+	keyID := encryptionKeyID(encryption)
+	store := &FileSecretStore{
 		basePath:   basePath,
 		encryption: encryption,
+		keyID:      keyID,
+		keys:       map[string]Encryption{keyID: encryption},
 		cache:      make(map[string]cachedSecret),
 		logger:     logger,
-	}, nil
+	}
+	for _, opt := range opts {
+		opt(store)
+	}
+	if err := store.checkPermissions(); err != nil {
+		return nil, err
+	}
+	store.removeStaleTempFiles()
+	return store, nil
 }
 
-func (s *FileSecretStore) GetSecret(key string) (string, error) {
+func (s *FileSecretStore) getSecret(key string) (string, error) {
 	s.mu.RLock()
 	cached, exists := s.cache[key]
 	s.mu.RUnlock()
@@ -117,8 +209,11 @@ func (s *FileSecretStore) GetSecret(key string) (string, error) {
 		return cached.value, nil
 	}
 
+	if err := s.checkPermissions(); err != nil {
+		return "", err
+	}
 	filePath := filepath.Join(s.basePath, sanitizeKey(key)+".enc")
-	data, err := ioutil.ReadFile(filePath)
+	data, err := os.ReadFile(filePath)
 
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -134,11 +229,12 @@ func (s *FileSecretStore) GetSecret(key string) (string, error) {
 	}
 	ciphertext = ciphertext[:n]
 
-	plaintext, err := s.encryption.Decrypt(ciphertext)
+	plaintext, err := s.open(key, ciphertext)
 	if err != nil {
 		return "", fmt.Errorf("failed to decrypt secret: %w", err)
 	}
 	value := string(plaintext)
+	s.learnKey(key)
 
 	s.mu.Lock()
 	s.cache[key] = cachedSecret{
@@ -150,8 +246,8 @@ func (s *FileSecretStore) GetSecret(key string) (string, error) {
 	return value, nil
 }
 
-func (s *FileSecretStore) SetSecret(key string, value string) error {
-	ciphertext, err := s.encryption.Encrypt([]byte(value))
+func (s *FileSecretStore) setSecret(key string, value string) error {
+	ciphertext, err := s.seal(key, []byte(value))
 	if err != nil {
 		return fmt.Errorf("failed to encrypt secret: %w", err)
 	}
@@ -159,8 +255,17 @@ func (s *FileSecretStore) SetSecret(key string, value string) error {
 	encoded := make([]byte, base64.StdEncoding.EncodedLen(len(ciphertext)))
 	base64.StdEncoding.Encode(encoded, ciphertext)
 
-	filePath := filepath.Join(s.basePath, sanitizeKey(key)+".enc")
-	if err := ioutil.WriteFile(filePath, encoded, 0600); err != nil {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if err := s.checkPermissions(); err != nil {
+		return err
+	}
+	name, err := s.claimFileName(key, time.Now())
+	if err != nil {
+		return err
+	}
+	filePath := filepath.Join(s.basePath, name+".enc")
+	if err := writeSecretFile(filePath, encoded); err != nil {
 		return fmt.Errorf("failed to write secret file: %w", err)
 	}
 
@@ -174,15 +279,23 @@ func (s *FileSecretStore) SetSecret(key string, value string) error {
 	return nil
 }
 
-func (s *FileSecretStore) DeleteSecret(key string) error {
+func (s *FileSecretStore) deleteSecret(key string) error {
 	filePath := filepath.Join(s.basePath, sanitizeKey(key)+".enc")
 
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	if err := s.checkPermissions(); err != nil {
+		return err
+	}
 	if err := os.Remove(filePath); err != nil {
 		if os.IsNotExist(err) {
 			return fmt.Errorf("secret %s not found", key)
 		}
 		return fmt.Errorf("failed to delete secret file: %w", err)
 	}
+	if err := s.forgetKey(key); err != nil {
+		return err
+	}
 	s.mu.Lock()
 	delete(s.cache, key)
 	s.mu.Unlock()
@@ -190,21 +303,17 @@ func (s *FileSecretStore) DeleteSecret(key string) error {
 	return nil
 }
 
+// ListSecrets returns the keys secrets were set with, which may differ from
+// their sanitized file names.
 func (s *FileSecretStore) ListSecrets() ([]string, error) {
-	files, err := ioutil.ReadDir(s.basePath)
+	if err := s.checkPermissions(); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(s.basePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read secret store directory: %w", err)
 	}
-
-	var secrets []string
-	for _, file := range files {
-		if !file.IsDir() && filepath.Ext(file.Name()) == ".enc" {
-			key := file.Name()[:len(file.Name())-4]
-			secrets = append(secrets, key)
-		}
-	}
-
-	return secrets, nil
+	return s.listIndexed(secretFileNames(entries))
 }
 
 func sanitizeKey(key string) string {
@@ -228,25 +337,26 @@ type VaultSecretStore struct {
 	cache     map[string]cachedSecret
 	mu        sync.RWMutex
 	logger    Logger
+
+	auth        VaultConfig
+	authMu      sync.Mutex
+	lastAuth    time.Time
+	authErr     error
+	tokenExpiry time.Time
+	cancel      context.CancelFunc
+	audit       AuditSink
+	done        chan struct{}
 }
 
 func NewVaultSecretStore(address, token, mountPath string, logger Logger) (*VaultSecretStore, error) {
-	config := vault.DefaultConfig()
-	config.Address = address
-	client, err := vault.NewClient(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Vault client: %w", err)
-	}
-	client.SetToken(token)
-	return &VaultSecretStore{
-		client:    client,
-		mountPath: mountPath,
-		cache:     make(map[string]cachedSecret),
-		logger:    logger,
-	}, nil
+	return NewVaultSecretStoreFromConfig(VaultConfig{
+		Address:   address,
+		Token:     token,
+		MountPath: mountPath,
+	}, logger)
 }
 
-func (s *VaultSecretStore) GetSecret(key string) (string, error) {
+func (s *VaultSecretStore) getSecret(key string) (string, error) {
 	s.mu.RLock()
 	cached, exists := s.cache[key]
 	s.mu.RUnlock()
@@ -254,7 +364,9 @@ func (s *VaultSecretStore) GetSecret(key string) (string, error) {
 		return cached.value, nil
 	}
 
-	secret, err := s.client.Logical().Read(fmt.Sprintf("%s/data/%s", s.mountPath, key))
+	secret, err := s.do(func() (*vault.Secret, error) {
+		return s.client.Logical().Read(fmt.Sprintf("%s/data/%s", s.mountPath, key))
+	})
 	if err != nil {
 		return "", fmt.Errorf("failed to read from Vault: %w", err)
 	}
@@ -278,14 +390,16 @@ func (s *VaultSecretStore) GetSecret(key string) (string, error) {
 	return value, nil
 }
 
-func (s *VaultSecretStore) SetSecret(key string, value string) error {
+func (s *VaultSecretStore) setSecret(key string, value string) error {
 	data := map[string]interface{}{
 		"data": map[string]interface{}{
 			"value": value,
 		},
 	}
 
-	_, err := s.client.Logical().Write(fmt.Sprintf("%s/data/%s", s.mountPath, key), data)
+	_, err := s.do(func() (*vault.Secret, error) {
+		return s.client.Logical().Write(fmt.Sprintf("%s/data/%s", s.mountPath, key), data)
+	})
 	if err != nil {
 		return fmt.Errorf("failed to write to Vault: %w", err)
 	}
@@ -299,8 +413,10 @@ func (s *VaultSecretStore) SetSecret(key string, value string) error {
 	return nil
 }
 
-func (s *VaultSecretStore) DeleteSecret(key string) error {
-	_, err := s.client.Logical().Delete(fmt.Sprintf("%s/data/%s", s.mountPath, key))
+func (s *VaultSecretStore) deleteSecret(key string) error {
+	_, err := s.do(func() (*vault.Secret, error) {
+		return s.client.Logical().Delete(fmt.Sprintf("%s/data/%s", s.mountPath, key))
+	})
 	if err != nil {
 		return fmt.Errorf("failed to delete from Vault: %w", err)
 	}
@@ -311,7 +427,9 @@ func (s *VaultSecretStore) DeleteSecret(key string) error {
 }
 
 func (s *VaultSecretStore) ListSecrets() ([]string, error) {
-	secret, err := s.client.Logical().List(fmt.Sprintf("%s/metadata", s.mountPath))
+	secret, err := s.do(func() (*vault.Secret, error) {
+		return s.client.Logical().List(fmt.Sprintf("%s/metadata", s.mountPath))
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list secrets from Vault: %w", err)
 	}
@@ -332,3 +450,57 @@ func (s *VaultSecretStore) ListSecrets() ([]string, error) {
 	return result, nil
 
 }
+
+var ErrReadOnlyStore = errors.New("secret store is read-only")
+
+// EnvSecretStore reads secrets from environment variables, for deployments
+// where the orchestrator injects them. The key database.password is read
+// from <prefix>DATABASE_PASSWORD. The store is read-only.
+type EnvSecretStore struct {
+	prefix string
+	// reserved holds variables under the prefix that configure bindxdb
+	// rather than hold a secret.
+	reserved map[string]bool
+}
+
+func NewEnvSecretStore(prefix string) *EnvSecretStore {
+	return &EnvSecretStore{prefix: prefix}
+}
+
+func (s *EnvSecretStore) envName(key string) string {
+	replacer := strings.NewReplacer(".", "_", "-", "_")
+	return s.prefix + strings.ToUpper(replacer.Replace(key))
+}
+
+func (s *EnvSecretStore) GetSecret(key string) (string, error) {
+	value, ok := os.LookupEnv(s.envName(key))
+	if !ok {
+		return "", fmt.Errorf("secret %s not found", key)
+	}
+	return value, nil
+}
+
+func (s *EnvSecretStore) SetSecret(key string, value string) error {
+	return fmt.Errorf("cannot set secret %s: %w", key, ErrReadOnlyStore)
+}
+
+func (s *EnvSecretStore) DeleteSecret(key string) error {
+	return fmt.Errorf("cannot delete secret %s: %w", key, ErrReadOnlyStore)
+}
+
+// ListSecrets returns a key for every variable carrying the prefix. Names
+// don't record whether an underscore was a dot, so every underscore is
+// read back as one.
+func (s *EnvSecretStore) ListSecrets() ([]string, error) {
+	var keys []string
+	for _, entry := range os.Environ() {
+		name, _, _ := strings.Cut(entry, "=")
+		if !strings.HasPrefix(name, s.prefix) || name == s.prefix || s.reserved[name] {
+			continue
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, s.prefix))
+		keys = append(keys, strings.ReplaceAll(key, "_", "."))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
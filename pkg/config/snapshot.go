@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+type ConfigSnapshot struct {
+	TakenAt time.Time                `json:"taken_at"`
+	Values  map[string]SnapshotEntry `json:"values"`
+}
+
+// SnapshotEntry is a point-in-time copy of a ConfigValue. Secret values are
+// never copied; SecretRef names the secret store key to resolve instead.
+type SnapshotEntry struct {
+	Value     interface{}  `json:"value,omitempty"`
+	SecretRef string       `json:"secret_ref,omitempty"`
+	Source    ConfigSource `json:"source"`
+	Priority  int          `json:"priority"`
+	IsDefault bool         `json:"is_default,omitempty"`
+	IsDynamic bool         `json:"is_dynamic,omitempty"`
+	Override  bool         `json:"override,omitempty"`
+	Timestamp time.Time    `json:"timestamp"`
+}
+
+func (m *ConfigManager) Snapshot() ConfigSnapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snapshot := ConfigSnapshot{
+		TakenAt: time.Now(),
+		Values:  make(map[string]SnapshotEntry, len(m.values)),
+	}
+	for key, value := range m.values {
+		entry := SnapshotEntry{
+			Source:    value.Source,
+			Priority:  value.Priority,
+			IsDefault: value.IsDefault,
+			IsDynamic: value.IsDynamic,
+			Timestamp: value.Timestamp,
+		}
+		if value.IsSecret {
+			entry.SecretRef = key
+		} else {
+			entry.Value = deepCopyValue(value.Value)
+		}
+		if _, ok := m.overrides[key]; ok {
+			entry.Override = true
+		}
+		snapshot.Values[key] = entry
+	}
+	return snapshot
+}
+
+// Restore replaces the current configuration with snapshot. If the restored
+// state fails validation the previous state is kept and the validation error
+// is returned; otherwise watchers are notified of every key that differs.
+func (m *ConfigManager) Restore(snapshot ConfigSnapshot) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+
+	values := make(map[string]*ConfigValue, len(snapshot.Values))
+	overrides := make(map[string]*ConfigValue)
+	for key, entry := range snapshot.Values {
+		value := &ConfigValue{
+			Value:     deepCopyValue(entry.Value),
+			Source:    entry.Source,
+			Priority:  entry.Priority,
+			IsSet:     true,
+			IsDefault: entry.IsDefault,
+			IsDynamic: entry.IsDynamic,
+			Timestamp: entry.Timestamp,
+		}
+		if defaultValue, ok := m.defaults[key]; ok && entry.IsDefault {
+			// Defaults come from code; prefer the typed value over whatever
+			// a JSON round trip turned it into.
+			value.Value = defaultValue
+		}
+		if entry.SecretRef != "" {
+			value.IsSecret = true
+			if current, ok := m.values[entry.SecretRef]; ok {
+				value.Value = current.Value
+			}
+		}
+		values[key] = value
+		if entry.Override && value.Priority == 0 {
+			// Snapshots taken before runtime sets had a priority.
+			value.Priority = sourcePriority(value.Source)
+		}
+		if entry.Override {
+			overrides[key] = value
+		}
+	}
+
+	previous, previousOverrides := m.values, m.overrides
+	m.values, m.overrides = values, overrides
+
+	report := m.validateLocked()
+	if err := report.Err(false); err != nil {
+		m.values, m.overrides = previous, previousOverrides
+		m.mu.Unlock()
+		return fmt.Errorf("restored configuration is invalid: %w", err)
+	}
+
+	changes := diffValues(previous, values)
+	m.recordHistory(changes...)
+	m.mu.Unlock()
+
+	for _, change := range changes {
+		m.notifyWatchers(change)
+	}
+	return nil
+}
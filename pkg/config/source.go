@@ -2,10 +2,16 @@ package config
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,15 +26,31 @@ type ConfigSources interface {
 type FileSource struct {
 	paths    []string
 	priority int
-	watcher  FileWatcher
+	loader   *ConfigLoader
+	watcher  sourceWatcher
 	lastLoad time.Time
+
+	// hashes holds the content hash of each file as of the last Load,
+	// keyed by absolute path.
+	hashMu sync.Mutex
+	hashes map[string]string
+}
+
+// NewFileSource reads paths in order, later files overriding earlier ones.
+// opts configure the watcher Watch uses, such as its debounce.
+func NewFileSource(paths []string, priority int, opts ...FileWatcherOption) *FileSource {
+	return NewFileSourceWithLoader(paths, priority, NewConfigLoader(), opts...)
 }
 
-func NewFileSource(paths []string, priority int) *FileSource {
+// NewFileSourceWithLoader is NewFileSource with a caller-supplied loader, for
+// files in formats registered beyond the built-in JSON and YAML.
+func NewFileSourceWithLoader(paths []string, priority int, loader *ConfigLoader, opts ...FileWatcherOption) *FileSource {
 	return &FileSource{
 		paths:    paths,
 		priority: priority,
-		watcher:  NewFileWatcher(),
+		loader:   loader,
+		watcher:  sourceWatcher{opts: opts},
+		hashes:   make(map[string]string),
 	}
 }
 
@@ -42,6 +64,7 @@ func (f *FileSource) Priority() int {
 
 func (f *FileSource) Load(ctx context.Context) (map[string]interface{}, error) {
 	result := make(map[string]interface{})
+	hashes := make(map[string]string, len(f.paths))
 
 	for _, path := range f.paths {
 		data, err := os.ReadFile(path)
@@ -51,22 +74,46 @@ func (f *FileSource) Load(ctx context.Context) (map[string]interface{}, error) {
 			}
 			return nil, fmt.Errorf("failed to read file %s: %w", path, err)
 		}
-		var config map[string]interface{}
-		if err := json.Unmarshal(data, &config); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal file %s: %w", path, err)
+		if absPath, err := filepath.Abs(path); err == nil {
+			sum := sha256.Sum256(data)
+			hashes[absPath] = hex.EncodeToString(sum[:])
 		}
-		result = mergeMaps(result, config)
+		config, err := f.loader.Parse(path, data)
+		if err != nil {
+			return nil, err
+		}
+		result = mergeMapsWith(result, config, f.loader.ArrayStrategy)
 
 	}
+	f.hashMu.Lock()
+	f.hashes = hashes
+	f.hashMu.Unlock()
 	f.lastLoad = time.Now()
 	return result, nil
 }
 
+// loaded reports whether the last Load read path with content hash, in
+// which case loading again would give the same result.
+func (f *FileSource) loaded(path, hash string) bool {
+	f.hashMu.Lock()
+	defer f.hashMu.Unlock()
+	loadedHash, ok := f.hashes[path]
+	if !ok {
+		return hash == ""
+	}
+	return loadedHash == hash
+}
+
 func (f *FileSource) Watch(ctx context.Context, onChange func(ConfigChange)) error {
+	watcher := f.watcher.start()
 	for _, path := range f.paths {
-		if err := f.watcher.Watch(path, func() {
+		if err := watcher.Watch(path, func(changed, hash string) {
+			if ctx.Err() != nil || f.loaded(changed, hash) {
+				return
+			}
 			config, err := f.Load(ctx)
 			if err != nil {
+				watcher.reportError(fmt.Errorf("rejected change to %s, keeping the current values: %w", changed, err))
 				return
 			}
 
@@ -77,33 +124,246 @@ func (f *FileSource) Watch(ctx context.Context, onChange func(ConfigChange)) err
 				Timestamp: time.Now(),
 			})
 		}); err != nil {
+			watcher.Stop()
 			return fmt.Errorf("failed to watch file %s: %w", path, err)
 		}
 	}
+	if err := watcher.Start(); err != nil {
+		return fmt.Errorf("failed to start file watcher: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		watcher.Stop()
+	}()
+	return nil
+}
+
+// WatchErrors delivers the errors the current watch runs into after Watch
+// has returned, including changes rejected because the file no longer
+// parses. It is nil before the first Watch.
+func (f *FileSource) WatchErrors() <-chan error {
+	return f.watcher.errors()
+}
+
+// SetLogger sets the logger for the file watcher and the loader.
+func (f *FileSource) SetLogger(logger Logger) {
+	f.watcher.setLogger(logger)
+	f.loader.SetLogger(logger)
+}
+
+func (f *FileSource) Close() error {
+	f.watcher.stop()
 	return nil
 }
 
-type EnironmentSource struct {
-	prefix   string
+// DirSource loads every config file in a directory (a conf.d layout),
+// merging them in lexical filename order. Files added or removed at runtime
+// are picked up through Watch.
+type DirSource struct {
+	dir      string
 	priority int
+	loader   *ConfigLoader
+	watcher  sourceWatcher
+}
+
+func NewDirSource(dir string, priority int, opts ...FileWatcherOption) *DirSource {
+	return NewDirSourceWithLoader(dir, priority, NewConfigLoader(), opts...)
+}
+
+func NewDirSourceWithLoader(dir string, priority int, loader *ConfigLoader, opts ...FileWatcherOption) *DirSource {
+	return &DirSource{
+		dir:      dir,
+		priority: priority,
+		loader:   loader,
+		watcher:  sourceWatcher{opts: opts},
+	}
+}
+
+func (d *DirSource) Name() string {
+	return "directory"
+}
+
+func (d *DirSource) Kind() ConfigSource {
+	return SourceFile
+}
+
+func (d *DirSource) Priority() int {
+	return d.priority
+}
+
+func (d *DirSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	if _, err := os.Stat(d.dir); os.IsNotExist(err) {
+		return make(map[string]interface{}), nil
+	}
+	return d.loader.LoadDir(d.dir)
 }
 
-func NewEnvironmentSource(prefix string, priority int) *EnironmentSource {
-	return &EnironmentSource{
+func (d *DirSource) Watch(ctx context.Context, onChange func(ConfigChange)) error {
+	watcher := d.watcher.start()
+	if err := watcher.WatchDir(d.dir, func() {
+		if ctx.Err() != nil {
+			return
+		}
+		config, err := d.Load(ctx)
+		if err != nil {
+			watcher.reportError(fmt.Errorf("rejected change to %s, keeping the current values: %w", d.dir, err))
+			return
+		}
+
+		onChange(ConfigChange{
+			Key:       "directory",
+			NewValue:  config,
+			Source:    SourceFile,
+			Timestamp: time.Now(),
+		})
+	}); err != nil {
+		watcher.Stop()
+		return fmt.Errorf("failed to watch directory %s: %w", d.dir, err)
+	}
+	if err := watcher.Start(); err != nil {
+		return fmt.Errorf("failed to start file watcher: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		watcher.Stop()
+	}()
+	return nil
+}
+
+func (d *DirSource) WatchErrors() <-chan error {
+	return d.watcher.errors()
+}
+
+func (d *DirSource) SetLogger(logger Logger) {
+	d.watcher.setLogger(logger)
+	d.loader.SetLogger(logger)
+}
+
+func (d *DirSource) Close() error {
+	d.watcher.stop()
+	return nil
+}
+
+// sourceWatcher holds the FileWatcher of a file or directory source. A
+// FileWatcher can't be started again once stopped, so each Watch gets a
+// new one and a watch can be stopped and started again.
+type sourceWatcher struct {
+	opts    []FileWatcherOption
+	mu      sync.Mutex
+	logger  Logger
+	current *FileWatcher
+}
+
+// start replaces the current FileWatcher, stopping it, with a new one.
+func (s *sourceWatcher) start() *FileWatcher {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != nil {
+		s.current.Stop()
+	}
+	s.current = NewFileWatcher(s.opts...)
+	s.current.SetLogger(s.logger)
+	return s.current
+}
+
+func (s *sourceWatcher) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current != nil {
+		s.current.Stop()
+	}
+}
+
+func (s *sourceWatcher) errors() <-chan error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.current == nil {
+		return nil
+	}
+	return s.current.Errors()
+}
+
+func (s *sourceWatcher) setLogger(logger Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger = logger
+	if s.current != nil {
+		s.current.SetLogger(logger)
+	}
+}
+
+// EnvironmentSource reads variables starting with prefix. After the prefix
+// the name is lower-cased and "__" separates nesting levels, so
+// BINDXDB_DATABASE__MAX_CONNECTIONS sets database.max_connections. Names
+// that don't fit the convention can be mapped with BindEnv.
+type EnvironmentSource struct {
+	prefix       string
+	priority     int
+	bindings     map[string]string
+	ignored      []string
+	pollInterval time.Duration
+	mu           sync.RWMutex
+}
+
+// Deprecated: use EnvironmentSource.
+type EnironmentSource = EnvironmentSource
+
+func NewEnvironmentSource(prefix string, priority int) *EnvironmentSource {
+	return &EnvironmentSource{
 		prefix:   prefix,
 		priority: priority,
+		bindings: make(map[string]string),
+	}
+}
+
+// BindEnv maps the environment variable envVar onto key, whether or not it
+// carries the source's prefix. A bound variable is not also loaded under
+// its derived name.
+func (e *EnvironmentSource) BindEnv(key, envVar string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.bindings[envVar] = key
+}
+
+// IgnorePrefix skips variables starting with prefix, such as secrets
+// injected for an EnvSecretStore that must not also be loaded as plain
+// config values.
+func (e *EnvironmentSource) IgnorePrefix(prefix string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.ignored = append(e.ignored, prefix)
+}
+
+func (e *EnvironmentSource) isIgnored(name string) bool {
+	for _, prefix := range e.ignored {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
 	}
+	return false
 }
 
-func (e *EnironmentSource) Name() string {
+// SetPollInterval makes Watch re-read the environment every interval and
+// report keys that were added, changed or removed. Zero, the default,
+// disables polling.
+func (e *EnvironmentSource) SetPollInterval(interval time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pollInterval = interval
+}
+
+func (e *EnvironmentSource) Name() string {
 	return "environment"
 }
 
-func (e *EnironmentSource) Priority() int {
+func (e *EnvironmentSource) Priority() int {
 	return e.priority
 }
 
-func (e *EnironmentSource) Load(ctx context.Context) (map[string]interface{}, error) {
+func (e *EnvironmentSource) Load(ctx context.Context) (map[string]interface{}, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
 	result := make(map[string]interface{})
 	for _, env := range os.Environ() {
 		parts := strings.SplitN(env, "=", 2)
@@ -112,28 +372,92 @@ func (e *EnironmentSource) Load(ctx context.Context) (map[string]interface{}, er
 		}
 		key := parts[0]
 		value := parts[1]
+		if _, bound := e.bindings[key]; bound {
+			continue
+		}
 		if e.prefix != "" && !strings.HasPrefix(key, e.prefix) {
 			continue
 		}
+		if e.isIgnored(key) {
+			continue
+		}
 
-		configKey := strings.ToLower(strings.TrimPrefix(key, e.prefix))
-		configKey = strings.ReplaceAll(configKey, "_", ".")
-
-		parsedValue := parseEnvValue(value)
+		configKey := envToKey(strings.TrimPrefix(key, e.prefix))
+		if configKey == "" {
+			continue
+		}
 
-		setNestedValue(result, configKey, parsedValue)
+		setNestedValue(result, configKey, parseEnvValue(value))
+	}
 
+	for envVar, configKey := range e.bindings {
+		if value, ok := os.LookupEnv(envVar); ok {
+			setNestedValue(result, configKey, parseEnvValue(value))
+		}
 	}
 	return result, nil
 }
 
-func (e *EnironmentSource) Watch(ctx context.Context, onChange func(ConfigChange)) error {
+// envToKey turns DATABASE__MAX_CONNECTIONS into database.max_connections.
+func envToKey(name string) string {
+	segments := strings.Split(strings.ToLower(name), "__")
+	kept := segments[:0]
+	for _, segment := range segments {
+		if segment != "" {
+			kept = append(kept, segment)
+		}
+	}
+	return strings.Join(kept, ".")
+}
+
+func (e *EnvironmentSource) Watch(ctx context.Context, onChange func(ConfigChange)) error {
+	e.mu.RLock()
+	interval := e.pollInterval
+	e.mu.RUnlock()
+	if interval <= 0 {
+		return nil
+	}
+
+	current, err := e.Load(ctx)
+	if err != nil {
+		return err
+	}
+	previous := flattenMap(current)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				current, err := e.Load(ctx)
+				if err != nil {
+					continue
+				}
+				snapshot := flattenMap(current)
+				for _, change := range diffFlat(previous, snapshot) {
+					if ctx.Err() != nil {
+						return
+					}
+					change.Source = SourceEnvironment
+					onChange(change)
+				}
+				previous = snapshot
+			}
+		}
+	}()
 	return nil
 }
 
 type FlagSource struct {
 	args     map[string]interface{}
 	priority int
+	flags    *flag.FlagSet
+	sets     *setFlag
+	skip     map[string]bool
 }
 
 func NewFlagSource(args map[string]interface{}, priority int) *FlagSource {
@@ -143,6 +467,34 @@ func NewFlagSource(args map[string]interface{}, priority int) *FlagSource {
 	}
 }
 
+// NewFlagSourceFromFlagSet registers a repeatable -set key=value flag on fs
+// and binds every flag the user sets to a config key, so "database-port"
+// becomes "database.port". Values are parsed as JSON where possible. Load
+// must be called after fs.Parse.
+func NewFlagSourceFromFlagSet(fs *flag.FlagSet, priority int) *FlagSource {
+	sets := &setFlag{}
+	fs.Var(sets, "set", "Set a config value as key=value (repeatable)")
+
+	return &FlagSource{
+		priority: priority,
+		flags:    fs,
+		sets:     sets,
+		skip:     map[string]bool{"set": true},
+	}
+}
+
+// Skip stops the named flags from being bound to config keys, for flags
+// that configure the binary rather than the config.
+func (f *FlagSource) Skip(names ...string) *FlagSource {
+	if f.skip == nil {
+		f.skip = make(map[string]bool)
+	}
+	for _, name := range names {
+		f.skip[name] = true
+	}
+	return f
+}
+
 func (f *FlagSource) Name() string {
 	return "flag"
 }
@@ -152,13 +504,69 @@ func (f *FlagSource) Priority() int {
 }
 
 func (f *FlagSource) Load(ctx context.Context) (map[string]interface{}, error) {
-	return f.args, nil
+	if f.flags == nil {
+		return f.args, nil
+	}
+	if !f.flags.Parsed() {
+		return nil, fmt.Errorf("flag set %s has not been parsed", f.flags.Name())
+	}
+
+	result := make(map[string]interface{})
+	f.flags.Visit(func(fl *flag.Flag) {
+		if f.skip[fl.Name] {
+			return
+		}
+		key := strings.ReplaceAll(fl.Name, "-", ".")
+		setNestedValue(result, key, parseRawValue(fl.Value.String()))
+	})
+	for _, entry := range f.sets.entries {
+		setNestedValue(result, entry.key, parseRawValue(entry.value))
+	}
+	return result, nil
 }
 
 func (f *FlagSource) Watch(ctx context.Context, onChange func(ConfigChange)) error {
 	return nil
 }
 
+type setEntry struct {
+	key   string
+	value string
+}
+
+// setFlag collects repeated -set key=value flags in the order given.
+type setFlag struct {
+	entries []setEntry
+}
+
+func (s *setFlag) String() string {
+	parts := make([]string, 0, len(s.entries))
+	for _, entry := range s.entries {
+		parts = append(parts, entry.key+"="+entry.value)
+	}
+	return strings.Join(parts, ",")
+}
+
+func (s *setFlag) Set(raw string) error {
+	key, value, ok := strings.Cut(raw, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", raw)
+	}
+	s.entries = append(s.entries, setEntry{key: key, value: value})
+	return nil
+}
+
+// parseRawValue decodes value as JSON so numbers, booleans, arrays and
+// objects keep their type, falling back to the raw string.
+func parseRawValue(value string) interface{} {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return value
+	}
+	return parsed
+}
+
 type DynamicSource struct {
 	backend  DynamicBackend
 	priority int
@@ -172,6 +580,39 @@ type DynamicBackend interface {
 	Put(ctx context.Context, key string, value []byte) error
 }
 
+const (
+	dynamicPrefix         = "/config/"
+	dynamicCoalesceWindow = 50 * time.Millisecond
+
+	backendRetryBackoff    = 100 * time.Millisecond
+	backendMaxRetryBackoff = 30 * time.Second
+)
+
+// sleepBackoff waits for *backoff and then doubles it up to
+// backendMaxRetryBackoff. It returns false if ctx is done first.
+func sleepBackoff(ctx context.Context, backoff *time.Duration) bool {
+	timer := time.NewTimer(*backoff)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+	}
+	*backoff *= 2
+	if *backoff > backendMaxRetryBackoff {
+		*backoff = backendMaxRetryBackoff
+	}
+	return true
+}
+
+func NewDynamicSource(backend DynamicBackend, priority int) *DynamicSource {
+	return &DynamicSource{
+		backend:  backend,
+		priority: priority,
+	}
+}
+
 func (d *DynamicSource) Name() string {
 	return "dynamic"
 }
@@ -182,7 +623,7 @@ func (d *DynamicSource) Priority() int {
 }
 
 func (d *DynamicSource) Load(ctx context.Context) (map[string]interface{}, error) {
-	kvPairs, err := d.backend.List(ctx, "/config/")
+	kvPairs, err := d.backend.List(ctx, dynamicPrefix)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list dynamic config: %w", err)
 	}
@@ -190,37 +631,102 @@ func (d *DynamicSource) Load(ctx context.Context) (map[string]interface{}, error
 	result := make(map[string]interface{})
 
 	for key, value := range kvPairs {
-		configKey := strings.TrimPrefix(key, "/config/")
-		var parsed interface{}
-		if err := json.Unmarshal(value, &parsed); err != nil {
-			parsed = string(value)
-		}
-		setNestedValue(result, configKey, parsed)
+		configKey := strings.ReplaceAll(strings.TrimPrefix(key, dynamicPrefix), "/", ".")
+		setNestedValue(result, configKey, parseRawValue(string(value)))
 	}
 	return result, nil
 }
 
+// Watch follows the backend's watch on the dynamic prefix. Notifications
+// that arrive within dynamicCoalesceWindow of each other are handled as one
+// batch: the prefix is listed again and a change is reported for every key
+// that was added, modified or removed. If the backend closes its channel
+// the watch is re-established with exponential backoff and resynced.
 func (d *DynamicSource) Watch(ctx context.Context, onChange func(ConfigChange)) error {
-	go func() {
-		watchCh, err := d.backend.Watch(ctx, "/config/")
-		if err != nil {
-			return
+	current, err := d.Load(ctx)
+	if err != nil {
+		return err
+	}
+	watchCh, err := d.backend.Watch(ctx, dynamicPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to watch dynamic config: %w", err)
+	}
+
+	go d.watchLoop(ctx, watchCh, flattenMap(current), onChange)
+	return nil
+}
+
+func (d *DynamicSource) watchLoop(ctx context.Context, watchCh <-chan []byte, previous map[string]interface{}, onChange func(ConfigChange)) {
+	var (
+		timer   *time.Timer
+		flush   <-chan time.Time
+		backoff = backendRetryBackoff
+	)
+	defer func() {
+		if timer != nil {
+			timer.Stop()
 		}
+	}()
 
-		for {
-			select {
-			case <-ctx.Done():
+	schedule := func() {
+		if flush == nil {
+			timer = time.NewTimer(dynamicCoalesceWindow)
+			flush = timer.C
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-watchCh:
+			if ok {
+				backoff = backendRetryBackoff
+				schedule()
+				continue
+			}
+			watchCh = d.reconnect(ctx, &backoff)
+			if watchCh == nil {
 				return
-			case data := <-watchCh:
-				onChange(ConfigChange{
-					Key:       "dynamic",
-					NewValue:  string(data),
-					Source:    SourceDynamic,
-					Timestamp: time.Now(),
-				})
 			}
+			// Updates may have been missed while disconnected.
+			schedule()
+		case <-flush:
+			flush = nil
+			current, err := d.Load(ctx)
+			if err != nil {
+				continue
+			}
+			snapshot := flattenMap(current)
+			for _, change := range diffFlat(previous, snapshot) {
+				if ctx.Err() != nil {
+					return
+				}
+				change.Source = SourceDynamic
+				onChange(change)
+			}
+			previous = snapshot
 		}
+	}
+}
 
-	}()
+// reconnect re-opens the backend watch, backing off between attempts. It
+// returns nil once ctx is done.
+func (d *DynamicSource) reconnect(ctx context.Context, backoff *time.Duration) <-chan []byte {
+	for {
+		if !sleepBackoff(ctx, backoff) {
+			return nil
+		}
+		if watchCh, err := d.backend.Watch(ctx, dynamicPrefix); err == nil {
+			return watchCh
+		}
+	}
+}
+
+// Close closes the backend if it holds a connection.
+func (d *DynamicSource) Close() error {
+	if closer, ok := d.backend.(io.Closer); ok {
+		return closer.Close()
+	}
 	return nil
 }
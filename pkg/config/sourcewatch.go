@@ -0,0 +1,194 @@
+package config
+
+import (
+	"context"
+	"fmt"
+)
+
+// watchErrorSource is implemented by sources whose watch can fail after
+// Watch has returned, such as the file and directory sources.
+type watchErrorSource interface {
+	WatchErrors() <-chan error
+}
+
+// sourceWatch is a running watch on a source. parent is the context
+// StartWatching was given, so a replacement source can be watched the same
+// way.
+type sourceWatch struct {
+	parent context.Context
+	cancel context.CancelFunc
+}
+
+// StartWatching calls Watch on every source that isn't watched yet. When a
+// source reports a change its data is refreshed and the configuration is
+// rebuilt, once per burst of changes; a result that fails validation is rejected and logged, leaving
+// the current values untouched. Watches stop when ctx is cancelled, the
+// source is removed or the manager is closed.
+func (m *ConfigManager) StartWatching(ctx context.Context) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return ErrClosed
+	}
+	type pending struct {
+		source ConfigSources
+		ctx    context.Context
+	}
+	var started []pending
+	for _, source := range m.sources {
+		if _, watching := m.watches[source]; watching {
+			continue
+		}
+		started = append(started, pending{source: source, ctx: m.addWatch(ctx, source)})
+	}
+	m.mu.Unlock()
+
+	var multiErr MultiError
+	for _, p := range started {
+		multiErr.Add(m.watchSource(p.ctx, p.source))
+	}
+	if multiErr.HasErrors() {
+		return &multiErr
+	}
+	return nil
+}
+
+// addWatch records a watch on source under parent and returns its context.
+// The caller must hold m.mu and then call watchSource.
+func (m *ConfigManager) addWatch(parent context.Context, source ConfigSources) context.Context {
+	watchCtx, cancel := context.WithCancel(parent)
+	m.watches[source] = sourceWatch{parent: parent, cancel: cancel}
+	return watchCtx
+}
+
+// watchSource calls Watch on source, which must have been recorded with
+// addWatch. It must be called without m.mu held.
+func (m *ConfigManager) watchSource(ctx context.Context, source ConfigSources) error {
+	go func() {
+		select {
+		case <-m.ctx.Done():
+		case <-ctx.Done():
+		}
+		m.stopWatching(source)
+	}()
+
+	refresh := make(chan struct{}, 1)
+	go m.refreshOnSignal(ctx, source, refresh)
+
+	err := source.Watch(ctx, func(change ConfigChange) {
+		if _, ok := change.NewValue.(map[string]interface{}); ok {
+			m.onSourceChange(ctx, source, change)
+			return
+		}
+		select {
+		case refresh <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		m.stopWatching(source)
+		return fmt.Errorf("failed to watch source %s: %w", source.Name(), err)
+	}
+	if reporter, ok := source.(watchErrorSource); ok {
+		go m.drainWatchErrors(ctx, source, reporter.WatchErrors())
+	}
+	return nil
+}
+
+// drainWatchErrors records the errors from source's watch in its health
+// until ctx is done.
+func (m *ConfigManager) drainWatchErrors(ctx context.Context, source ConfigSources, errs <-chan error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case err := <-errs:
+			m.mu.Lock()
+			m.healthOf(source).WatchError = err
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *ConfigManager) stopWatching(source ConfigSources) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if watch, ok := m.watches[source]; ok {
+		watch.cancel()
+		delete(m.watches, source)
+	}
+}
+
+// refreshOnSignal reads source again each time refresh is signalled, until
+// ctx is done. Sources such as the dynamic and environment sources report
+// one change per key; the signal is buffered once, so a burst of changes
+// results in a single reload and rebuild instead of one per key.
+func (m *ConfigManager) refreshOnSignal(ctx context.Context, source ConfigSources, refresh <-chan struct{}) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-refresh:
+			m.onSourceChange(ctx, source, ConfigChange{Key: source.Name()})
+		}
+	}
+}
+
+// onSourceChange refreshes source after it reported a change. Sources that
+// send their whole document as the change value are used as-is; others are
+// read again.
+func (m *ConfigManager) onSourceChange(ctx context.Context, source ConfigSources, change ConfigChange) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	m.readMu.Lock()
+	defer m.readMu.Unlock()
+
+	data, ok := change.NewValue.(map[string]interface{})
+	var read sourceRead
+	if !ok {
+		read = readSources(ctx, []ConfigSources{source})[0]
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	if _, attached := m.watches[source]; !attached {
+		m.mu.Unlock()
+		return
+	}
+	if health, ok := m.sourceHealth[source]; ok {
+		health.WatchError = nil
+	}
+
+	previousData, hadData := m.sourceData[source]
+	var loadErr MultiError
+	if ok {
+		m.sourceData[source] = data
+	} else if err := m.recordRead(read); err != nil {
+		m.mu.Unlock()
+		m.logger.Error("failed to reload changed source", "source", source.Name(), "error", err)
+		return
+	}
+
+	changes, err := m.rebuild(&loadErr, true)
+	if err != nil {
+		if hadData {
+			m.sourceData[source] = previousData
+		} else {
+			delete(m.sourceData, source)
+		}
+	}
+	m.mu.Unlock()
+
+	if err != nil {
+		m.logger.Error("rejected change from source", "source", source.Name(), "error", err)
+	}
+	for _, change := range changes {
+		m.notifyWatchers(change)
+	}
+}
@@ -0,0 +1,141 @@
+package config
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type ConfigStats struct {
+	Keys         int
+	KeysBySource map[string]int
+	DefaultKeys  int
+	SecretKeys   int
+	DynamicKeys  int
+
+	Loads              uint64
+	ValidationFailures uint64
+	// LastLoad is the time of the last Load that finished without error.
+	LastLoad      time.Time
+	LastLoadError error
+
+	Sources      []SourceHealth
+	SecretStores []SecretStoreHealth
+	Watchers     int
+	Subscribers  int
+
+	// DroppedChanges counts notifications lost because the change channel
+	// was full.
+	DroppedChanges uint64
+	// DroppedDeliveries counts notifications lost because an individual
+	// Watch subscriber had fallen behind.
+	DroppedDeliveries uint64
+	OverflowPolicy    OverflowPolicy
+
+	// Dynamic describes the update queue of the DynamicConfigManager built
+	// on this manager, if there is one.
+	Dynamic *DynamicStats
+}
+
+// attachDynamic makes Stats report d's update queue.
+func (m *ConfigManager) attachDynamic(d *DynamicConfigManager) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dynamic = d
+}
+
+// Stats reports what the manager currently holds. It only takes read locks,
+// so it is safe to call from metrics collectors at any rate.
+func (m *ConfigManager) Stats() ConfigStats {
+	stats := ConfigStats{
+		KeysBySource:      make(map[string]int),
+		DroppedChanges:    atomic.LoadUint64(&m.droppedChanges),
+		DroppedDeliveries: atomic.LoadUint64(&m.droppedDeliveries),
+		OverflowPolicy:    m.overflow,
+	}
+
+	m.mu.RLock()
+	stats.Keys = len(m.values)
+	for _, value := range m.values {
+		stats.KeysBySource[value.Source.String()]++
+		if value.IsDefault {
+			stats.DefaultKeys++
+		}
+		if value.IsSecret {
+			stats.SecretKeys++
+		}
+		if value.IsDynamic {
+			stats.DynamicKeys++
+		}
+	}
+	for _, regs := range m.watchers {
+		stats.Watchers += len(regs)
+	}
+	stats.Loads = m.loadCount
+	stats.ValidationFailures = m.validationFailures
+	stats.LastLoad = m.lastLoad
+	stats.LastLoadError = m.lastLoadErr
+	dynamic := m.dynamic
+	m.mu.RUnlock()
+
+	if dynamic != nil {
+		dynamicStats := dynamic.Stats()
+		stats.Dynamic = &dynamicStats
+	}
+
+	stats.Sources = m.SourceStatus()
+	stats.SecretStores = m.SecretStoreHealth()
+
+	m.subMu.Lock()
+	stats.Subscribers = len(m.subscribers)
+	m.subMu.Unlock()
+
+	return stats
+}
+
+// Metrics flattens the stats into the map shape MonitoringPlugin's
+// CollectMetrics returns.
+func (s ConfigStats) Metrics() map[string]interface{} {
+	metrics := map[string]interface{}{
+		"config.keys":                s.Keys,
+		"config.default_keys":        s.DefaultKeys,
+		"config.secret_keys":         s.SecretKeys,
+		"config.dynamic_keys":        s.DynamicKeys,
+		"config.loads":               s.Loads,
+		"config.validation_failures": s.ValidationFailures,
+		"config.watchers":            s.Watchers,
+		"config.subscribers":         s.Subscribers,
+		"config.dropped_changes":     s.DroppedChanges,
+		"config.dropped_deliveries":  s.DroppedDeliveries,
+		"config.last_load_failed":    s.LastLoadError != nil,
+	}
+	if !s.LastLoad.IsZero() {
+		metrics["config.last_load_unix"] = s.LastLoad.Unix()
+	}
+	for source, count := range s.KeysBySource {
+		metrics["config.keys."+source] = count
+	}
+	for _, source := range s.Sources {
+		prefix := "config.source." + source.Name + "."
+		metrics[prefix+"loads"] = source.Loads
+		metrics[prefix+"failures"] = source.Failures
+		metrics[prefix+"last_duration_ms"] = source.LastDuration.Milliseconds()
+		metrics[prefix+"healthy"] = source.LastError == nil && source.WatchError == nil
+	}
+	if s.Dynamic != nil {
+		metrics["config.dynamic.queue_depth"] = s.Dynamic.QueueDepth
+		metrics["config.dynamic.rate_limited"] = s.Dynamic.RateLimited
+		metrics["config.dynamic.queue_full"] = s.Dynamic.QueueFull
+		metrics["config.dynamic.coalesced"] = s.Dynamic.Coalesced
+	}
+	for _, store := range s.SecretStores {
+		prefix := "config.secret_store." + store.Backend + "."
+		metrics[prefix+"healthy"] = store.Healthy
+		if !store.LastAuth.IsZero() {
+			metrics[prefix+"last_auth_unix"] = store.LastAuth.Unix()
+		}
+		if !store.Expires.IsZero() {
+			metrics[prefix+"expires_unix"] = store.Expires.Unix()
+		}
+	}
+	return metrics
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+type subscription struct {
+	id     uint64
+	prefix string
+	ch     chan ConfigChange
+}
+
+// Watch returns a channel that receives every configuration change. Each
+// caller gets its own channel; a subscriber that falls behind only drops its
+// own events. Call Unwatch to release the subscription.
+func (m *ConfigManager) Watch() <-chan ConfigChange {
+	return m.subscribe("")
+}
+
+func (m *ConfigManager) Unwatch(ch <-chan ConfigChange) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+
+	for id, sub := range m.subscribers {
+		if (<-chan ConfigChange)(sub.ch) == ch {
+			delete(m.subscribers, id)
+			close(sub.ch)
+			return
+		}
+	}
+}
+
+// subscribe registers a subscriber that only receives changes below prefix,
+// with keys rewritten relative to it.
+func (m *ConfigManager) subscribe(prefix string) <-chan ConfigChange {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+
+	m.nextSubID++
+	sub := &subscription{
+		id:     m.nextSubID,
+		prefix: prefix,
+		ch:     make(chan ConfigChange, m.bufferSize),
+	}
+	if m.ctx.Err() != nil {
+		close(sub.ch)
+		return sub.ch
+	}
+	m.subscribers[sub.id] = sub
+	return sub.ch
+}
+
+func (m *ConfigManager) fanOut() {
+	defer close(m.fanOutDone)
+	defer func() {
+		m.subMu.Lock()
+		for id, sub := range m.subscribers {
+			delete(m.subscribers, id)
+			close(sub.ch)
+		}
+		m.subMu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			for {
+				select {
+				case change := <-m.onChange:
+					m.deliver(change)
+				default:
+					m.flushPending()
+					return
+				}
+			}
+		case change := <-m.onChange:
+			m.deliver(change)
+		case <-m.pending.signal:
+			m.flushPending()
+		}
+	}
+}
+
+func (m *ConfigManager) flushPending() {
+	for _, change := range m.pending.drain() {
+		m.deliver(change)
+	}
+}
+
+func (m *ConfigManager) deliver(change ConfigChange) {
+	m.subMu.Lock()
+	defer m.subMu.Unlock()
+
+	for _, sub := range m.subscribers {
+		delivered := change
+		if sub.prefix != "" {
+			relKey, inside := relativeKey(sub.prefix, change.Key)
+			if !inside {
+				continue
+			}
+			delivered.Key = relKey
+		}
+		select {
+		case sub.ch <- delivered:
+		default:
+			atomic.AddUint64(&m.droppedDeliveries, 1)
+			m.logger.Warn("Config subscriber channel full, dropping change",
+				"subscriber", sub.id, "key", change.Key)
+		}
+	}
+}
+
+func relativeKey(prefix, key string) (string, bool) {
+	if prefix == "" {
+		return key, true
+	}
+	if key == prefix {
+		return "", true
+	}
+	if strings.HasPrefix(key, prefix+".") {
+		return strings.TrimPrefix(key, prefix+"."), true
+	}
+	return "", false
+}
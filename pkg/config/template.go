@@ -0,0 +1,97 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// WithTemplating runs every file through text/template before it is
+// parsed. It is off by default so files that happen to contain "{{" load
+// unchanged.
+func WithTemplating() LoaderOption {
+	return func(l *ConfigLoader) {
+		l.templating = true
+	}
+}
+
+// SetTemplating turns template rendering of loaded files on or off. It
+// should be set before the loader is first used.
+func (l *ConfigLoader) SetTemplating(enabled bool) {
+	l.templating = enabled
+}
+
+// EnableTemplating renders config files read by the manager's file sources
+// as templates. Call it before Load.
+func (m *ConfigManager) EnableTemplating(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.loader.SetTemplating(enabled)
+}
+
+// templateData is the dot value of a config template.
+type templateData struct {
+	Hostname string
+	Env      map[string]string
+}
+
+var templateFuncs = template.FuncMap{
+	"env": os.Getenv,
+	"hostname": func() (string, error) {
+		return os.Hostname()
+	},
+	"file": func(path string) (string, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	},
+	// default returns value unless it is empty, so
+	// {{ env "RACK" | default "r1" }} falls back to r1.
+	"default": func(def string, value interface{}) interface{} {
+		if value == nil {
+			return def
+		}
+		if s, ok := value.(string); ok && s == "" {
+			return def
+		}
+		return value
+	},
+}
+
+// renderTemplate executes data as a template named after path, so parse and
+// execution errors carry the file name and line.
+func renderTemplate(path string, data []byte) ([]byte, error) {
+	tmpl, err := template.New(path).Funcs(templateFuncs).Option("missingkey=error").Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	env := make(map[string]string)
+	for _, entry := range os.Environ() {
+		if name, value, ok := strings.Cut(entry, "="); ok {
+			env[name] = value
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, templateData{Hostname: hostname, Env: env}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (l *ConfigLoader) render(path string, data []byte) ([]byte, error) {
+	if !l.templating {
+		return data, nil
+	}
+	rendered, err := renderTemplate(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	return rendered, nil
+}
@@ -1,12 +1,18 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
 	"time"
 )
 
+var (
+	ErrLowerPriority = errors.New("key is held by a higher-priority source")
+	ErrClosed        = errors.New("config manager is closed")
+)
+
 type ConfigSource int
 
 const (
@@ -18,20 +24,26 @@ const (
 	SourceSecret
 )
 
+var sourceNames = [...]string{
+	"default",
+	"file",
+	"environment",
+	"flag",
+	"dynamic",
+	"secret",
+}
+
 func (s ConfigSource) String() string {
-	return [...]string{
-		"default",
-		"file",
-		"environment",
-		"flag",
-		"dynamic",
-		"secret",
-	}[s]
+	if s < 0 || int(s) >= len(sourceNames) {
+		return fmt.Sprintf("source(%d)", int(s))
+	}
+	return sourceNames[s]
 }
 
 type ConfigValue struct {
 	Value     interface{}
 	Source    ConfigSource
+	Priority  int
 	IsSet     bool
 	IsDefault bool
 	IsSecret  bool
@@ -45,12 +57,39 @@ type ConfigChange struct {
 	NewValue  interface{}
 	Source    ConfigSource
 	Timestamp time.Time
+	BatchID   string
+	// Stage marks the changes made by a StagedUpdate; it is empty for
+	// ordinary ones.
+	Stage ChangeStage
+}
+
+// ChangeStage tells the apply of a StagedUpdate from its revert.
+type ChangeStage string
+
+const (
+	StageApplied  ChangeStage = "staged"
+	StageReverted ChangeStage = "reverted"
+)
+
+// ConfigChangeSet groups the changes applied together by one SetBatch call.
+type ConfigChangeSet struct {
+	BatchID   string
+	Changes   []ConfigChange
+	Source    ConfigSource
+	Timestamp time.Time
 }
 
 type ConfigWatcher interface {
 	OnConfigChange(change ConfigChange)
 }
 
+// ConfigChangeSetWatcher is implemented by watchers that want a batch as a
+// single notification instead of one OnConfigChange per key.
+type ConfigChangeSetWatcher interface {
+	ConfigWatcher
+	OnConfigChangeSet(changes ConfigChangeSet)
+}
+
 type ConfigValidator interface {
 	Validate(key string, value interface{}) error
 }
@@ -69,15 +108,29 @@ type ConfigError struct {
 	Key     string
 	Message string
 	Err     error
+	// Code is one of the Code constants, such as CodeOutOfRange.
+	Code string
 }
 
 func (e *ConfigError) Error() string {
+	// Errors for a nested value get their key from the error wrapping
+	// them.
+	if e.Key == "" {
+		if e.Err != nil {
+			return fmt.Sprintf("%s: %v", e.Message, e.Err)
+		}
+		return e.Message
+	}
 	if e.Err != nil {
 		return fmt.Sprintf("config error for key %s: %s: %v", e.Key, e.Message, e.Err)
 	}
 	return fmt.Sprintf("config error for key %s: %s", e.Key, e.Message)
 }
 
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
 type MultiError struct {
 	Errors []error
 }
@@ -107,10 +160,18 @@ type SchemaNode struct {
 	Required             bool                   `json:"required,omitempty"`
 	Secret               bool                   `json:"secret,omitempty"`
 	Dynamic              bool                   `json:"dynamic,omitempty"`
+	TreatEmptyAsUnset    bool                   `json:"treatEmptyAsUnset,omitempty"`
+	MergeStrategy        string                 `json:"mergeStrategy,omitempty"`
 	Min                  interface{}            `json:"min,omitempty"`
 	Max                  interface{}            `json:"max,omitempty"`
 	Pattern              string                 `json:"pattern,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	MinLength            *int                   `json:"minLength,omitempty"`
+	MaxLength            *int                   `json:"maxLength,omitempty"`
+	MinItems             *int                   `json:"minItems,omitempty"`
+	MaxItems             *int                   `json:"maxItems,omitempty"`
 	Enum                 []interface{}          `json:"enum,omitempty"`
+	Validators           []string               `json:"validators,omitempty"`
 	Properties           map[string]*SchemaNode `json:"properties,omitempty"`
 	Items                *SchemaNode            `json:"items,omitempty"`
 	AdditionalProperties *SchemaNode            `json:"additionalProperties,omitempty"`
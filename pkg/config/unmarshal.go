@@ -0,0 +1,670 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// Unmarshal decodes every value stored under prefix into target, which must
+// be a non-nil pointer. Field names are taken from the `config` tag when
+// present and from the `json` tag otherwise; `config:",required"` marks a
+// field whose key must be set. All conversion and missing-key errors are
+// collected into a MultiError.
+func (m *ConfigManager) Unmarshal(prefix string, target interface{}) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &ConfigError{
+			Key:     prefix,
+			Message: fmt.Sprintf("unmarshal target must be a non-nil pointer, got %T", target),
+		}
+	}
+
+	var multiErr MultiError
+	decodeValue(prefix, m.subtree(prefix), rv.Elem(), &multiErr)
+	if multiErr.HasErrors() {
+		return &multiErr
+	}
+	return nil
+}
+
+// subtree rebuilds the nested map for every key below prefix. If prefix is
+// itself a leaf key its value is returned directly.
+func (m *ConfigManager) subtree(prefix string) interface{} {
+	inside := func(key string) bool {
+		return prefix == "" || key == prefix || strings.HasPrefix(key, prefix+".")
+	}
+
+	m.mu.RLock()
+	prefix = m.canonicalKey(prefix)
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		if inside(key) {
+			keys = append(keys, key)
+		}
+	}
+	// Expose canonical values under their old names too, so structs that
+	// still use an aliased field name keep decoding.
+	for oldKey, alias := range m.aliases {
+		_, oldSet := m.values[oldKey]
+		if _, newSet := m.values[alias.newKey]; newSet && !oldSet && inside(oldKey) {
+			keys = append(keys, oldKey)
+		}
+	}
+	m.mu.RUnlock()
+
+	// A map stored directly under prefix is merged with any flattened
+	// children; a scalar there wins outright.
+	result := make(map[string]interface{})
+	sort.Strings(keys)
+	for _, key := range keys {
+		if key != prefix {
+			continue
+		}
+		value, err := m.Get(key)
+		if err != nil {
+			break
+		}
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+		result = nested
+	}
+	for _, key := range keys {
+		if key == prefix {
+			continue
+		}
+		value, err := m.Get(key)
+		if err != nil {
+			continue
+		}
+		relKey := key
+		if prefix != "" {
+			relKey = strings.TrimPrefix(key, prefix+".")
+		}
+		setNestedValue(result, relKey, value)
+	}
+	return result
+}
+
+func decodeValue(key string, raw interface{}, dst reflect.Value, errs *MultiError) {
+	if dst.Kind() == reflect.Ptr {
+		if raw == nil {
+			return
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		decodeValue(key, raw, dst.Elem(), errs)
+		return
+	}
+
+	switch dst.Kind() {
+	case reflect.Struct:
+		if dst.Type() == timeType {
+			t, err := toTime(key, raw)
+			if err != nil {
+				errs.Add(err)
+				return
+			}
+			dst.Set(reflect.ValueOf(t))
+			return
+		}
+		decodeStruct(key, raw, dst, errs)
+	case reflect.Slice:
+		decodeSlice(key, raw, dst, errs)
+	case reflect.Map:
+		decodeMap(key, raw, dst, errs)
+	default:
+		if err := convertValue(key, raw, dst); err != nil {
+			errs.Add(err)
+		}
+	}
+}
+
+func decodeStruct(key string, raw interface{}, dst reflect.Value, errs *MultiError) {
+	fields, ok := raw.(map[string]interface{})
+	if raw != nil && !ok {
+		errs.Add(&ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("expected object, got %T", raw),
+		})
+		return
+	}
+
+	t := dst.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name, required := fieldKey(field)
+		if name == "-" {
+			continue
+		}
+		childKey := joinKey(key, name)
+
+		value, exists := fields[name]
+		if !exists {
+			if required {
+				errs.Add(&ConfigError{
+					Key:     childKey,
+					Message: "required key not set",
+				})
+			}
+			if field.Type.Kind() == reflect.Struct {
+				decodeStruct(childKey, nil, dst.Field(i), errs)
+			}
+			continue
+		}
+		decodeValue(childKey, value, dst.Field(i), errs)
+	}
+}
+
+func decodeSlice(key string, raw interface{}, dst reflect.Value, errs *MultiError) {
+	src := reflect.ValueOf(raw)
+	if src.Kind() != reflect.Slice && src.Kind() != reflect.Array {
+		errs.Add(&ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("expected array, got %T", raw),
+		})
+		return
+	}
+
+	result := reflect.MakeSlice(dst.Type(), src.Len(), src.Len())
+	for i := 0; i < src.Len(); i++ {
+		decodeValue(fmt.Sprintf("%s[%d]", key, i), src.Index(i).Interface(), result.Index(i), errs)
+	}
+	dst.Set(result)
+}
+
+func decodeMap(key string, raw interface{}, dst reflect.Value, errs *MultiError) {
+	src, ok := raw.(map[string]interface{})
+	if !ok {
+		errs.Add(&ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("expected object, got %T", raw),
+		})
+		return
+	}
+	if dst.Type().Key().Kind() != reflect.String {
+		errs.Add(&ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("unsupported map key type %s", dst.Type().Key()),
+		})
+		return
+	}
+
+	result := reflect.MakeMapWithSize(dst.Type(), len(src))
+	for k, v := range src {
+		elem := reflect.New(dst.Type().Elem()).Elem()
+		decodeValue(joinKey(key, k), v, elem, errs)
+		result.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), elem)
+	}
+	dst.Set(result)
+}
+
+func convertValue(key string, raw interface{}, dst reflect.Value) error {
+	if dst.Type() == durationType {
+		d, err := toDuration(key, raw)
+		if err != nil {
+			return err
+		}
+		dst.SetInt(int64(d))
+		return nil
+	}
+
+	mismatch := func() error {
+		return &ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("cannot convert %T to %s", raw, dst.Type()),
+		}
+	}
+
+	switch dst.Kind() {
+	case reflect.Interface:
+		if raw != nil {
+			dst.Set(reflect.ValueOf(raw))
+		}
+	case reflect.String:
+		str, ok := raw.(string)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetString(str)
+	case reflect.Bool:
+		switch v := raw.(type) {
+		case bool:
+			dst.SetBool(v)
+		case string:
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return mismatch()
+			}
+			dst.SetBool(b)
+		default:
+			return mismatch()
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := toInt64(key, raw)
+		if err != nil {
+			return err
+		}
+		if dst.OverflowInt(i) {
+			return &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("value %d overflows %s", i, dst.Type()),
+			}
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := toUint64(key, raw)
+		if err != nil {
+			return err
+		}
+		if dst.OverflowUint(u) {
+			return &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("value %d overflows %s", u, dst.Type()),
+			}
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := toFloat64(key, raw)
+		if err != nil {
+			return err
+		}
+		dst.SetFloat(f)
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+func toInt64(key string, value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case int32:
+		return int64(v), nil
+	case float64:
+		if v != math.Trunc(v) || v >= math.MaxInt64 || v < math.MinInt64 {
+			return 0, &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("value %v is not an integer", v),
+			}
+		}
+		return int64(v), nil
+	case json.Number:
+		i, err := v.Int64()
+		if err != nil {
+			return 0, &ConfigError{
+				Key:     key,
+				Message: "cannot convert to int",
+				Err:     err,
+			}
+		}
+		return i, nil
+	case string:
+		i, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, &ConfigError{
+				Key:     key,
+				Message: "cannot convert to int",
+				Err:     err,
+			}
+		}
+		return i, nil
+	default:
+		return 0, &ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("value is not an int: %T", value),
+		}
+	}
+}
+
+func toUint64(key string, value interface{}) (uint64, error) {
+	switch v := value.(type) {
+	case uint:
+		return uint64(v), nil
+	case uint64:
+		return v, nil
+	case uint32:
+		return uint64(v), nil
+	case float64:
+		if v != math.Trunc(v) || v < 0 || v >= math.MaxUint64 {
+			return 0, &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("value %v is not an unsigned integer", v),
+			}
+		}
+		return uint64(v), nil
+	case json.Number:
+		u, err := strconv.ParseUint(v.String(), 10, 64)
+		if err != nil {
+			return 0, &ConfigError{
+				Key:     key,
+				Message: "cannot convert to uint",
+				Err:     err,
+			}
+		}
+		return u, nil
+	case string:
+		u, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, &ConfigError{
+				Key:     key,
+				Message: "cannot convert to uint",
+				Err:     err,
+			}
+		}
+		return u, nil
+	default:
+		i, err := toInt64(key, value)
+		if err != nil {
+			return 0, err
+		}
+		if i < 0 {
+			return 0, &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("value %d is negative", i),
+			}
+		}
+		return uint64(i), nil
+	}
+}
+
+func toTime(key string, value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, &ConfigError{
+				Key:     key,
+				Message: "cannot convert to time, expected RFC3339",
+				Err:     err,
+			}
+		}
+		return t, nil
+	case int, int64, float64, json.Number:
+		secs, err := toInt64(key, v)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(secs, 0).UTC(), nil
+	default:
+		return time.Time{}, &ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("cannot convert %T to time", value),
+		}
+	}
+}
+
+func toFloat64(key string, value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, &ConfigError{
+				Key:     key,
+				Message: "cannot convert to float",
+				Err:     err,
+			}
+		}
+		return f, nil
+	default:
+		return 0, &ConfigError{
+			Key:     key,
+			Message: "cannot convert to float",
+		}
+	}
+}
+
+// toDuration normalizes every representation a duration can arrive in:
+// time.Duration, a duration string such as "30s", or a number of seconds
+// (integer, float or numeric string).
+func toDuration(key string, value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case time.Duration:
+		return v, nil
+	case string:
+		str := strings.TrimSpace(v)
+		d, err := time.ParseDuration(str)
+		if err == nil {
+			return d, nil
+		}
+		if seconds, numErr := strconv.ParseFloat(str, 64); numErr == nil {
+			return secondsToDuration(key, seconds)
+		}
+		return 0, &ConfigError{
+			Key:     key,
+			Message: "cannot convert to duration",
+			Err:     err,
+		}
+	}
+
+	n, ok := toNumber(value)
+	if !ok {
+		return 0, &ConfigError{
+			Key:     key,
+			Message: "cannot convert to duration",
+		}
+	}
+	if n.isInt {
+		if n.i > maxDurationSeconds || n.i < -maxDurationSeconds {
+			return 0, durationRangeError(key)
+		}
+		return time.Duration(n.i) * time.Second, nil
+	}
+	return secondsToDuration(key, n.f)
+}
+
+// maxDurationSeconds is the largest whole number of seconds a
+// time.Duration holds, about 292 years.
+const maxDurationSeconds = math.MaxInt64 / int64(time.Second)
+
+func secondsToDuration(key string, seconds float64) (time.Duration, error) {
+	nanos := seconds * float64(time.Second)
+	if math.IsNaN(nanos) || nanos >= math.MaxInt64 || nanos < math.MinInt64 {
+		return 0, durationRangeError(key)
+	}
+	return time.Duration(nanos), nil
+}
+
+func durationRangeError(key string) error {
+	return &ConfigError{
+		Key:     key,
+		Message: "duration out of range",
+	}
+}
+
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+func toSizeBytes(key string, value interface{}) (int64, error) {
+	var size int64
+	switch v := value.(type) {
+	case string:
+		str := strings.TrimSpace(v)
+		i := 0
+		for i < len(str) && (str[i] >= '0' && str[i] <= '9' || str[i] == '.' || str[i] == '-') {
+			i++
+		}
+		num, err := strconv.ParseFloat(str[:i], 64)
+		if err != nil {
+			return 0, &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("invalid size %q", v),
+				Err:     err,
+			}
+		}
+		unit, ok := sizeUnits[strings.ToLower(strings.TrimSpace(str[i:]))]
+		if !ok {
+			return 0, &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("invalid size unit in %q", v),
+			}
+		}
+		bytes := num * float64(unit)
+		if bytes >= math.MaxInt64 {
+			return 0, &ConfigError{
+				Key:     key,
+				Message: fmt.Sprintf("size %q overflows int64", v),
+			}
+		}
+		size = int64(bytes)
+	default:
+		i, err := toInt64(key, value)
+		if err != nil {
+			return 0, err
+		}
+		size = i
+	}
+
+	if size < 0 {
+		return 0, &ConfigError{
+			Key:     key,
+			Message: fmt.Sprintf("size %v must not be negative", value),
+		}
+	}
+	return size, nil
+}
+
+func fieldKey(field reflect.StructField) (string, bool) {
+	tag, ok := field.Tag.Lookup("config")
+	if !ok {
+		tag = field.Tag.Get("json")
+	}
+
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	required := false
+	for _, opt := range parts[1:] {
+		if opt == "required" {
+			required = true
+		}
+	}
+
+	if name == "" {
+		if jsonName := strings.Split(field.Tag.Get("json"), ",")[0]; jsonName != "" {
+			name = jsonName
+		} else {
+			name = strings.ToLower(field.Name)
+		}
+	}
+	return name, required
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// number is a numeric value normalized for comparison. Integers keep their
+// exact int64 form so large values don't lose precision through float64.
+type number struct {
+	i     int64
+	f     float64
+	isInt bool
+}
+
+func toNumber(value interface{}) (number, bool) {
+	switch v := value.(type) {
+	case int:
+		return number{i: int64(v), f: float64(v), isInt: true}, true
+	case int8:
+		return number{i: int64(v), f: float64(v), isInt: true}, true
+	case int16:
+		return number{i: int64(v), f: float64(v), isInt: true}, true
+	case int32:
+		return number{i: int64(v), f: float64(v), isInt: true}, true
+	case int64:
+		return number{i: v, f: float64(v), isInt: true}, true
+	case uint:
+		return number{i: int64(v), f: float64(v), isInt: v <= math.MaxInt64}, true
+	case uint8:
+		return number{i: int64(v), f: float64(v), isInt: true}, true
+	case uint16:
+		return number{i: int64(v), f: float64(v), isInt: true}, true
+	case uint32:
+		return number{i: int64(v), f: float64(v), isInt: true}, true
+	case uint64:
+		return number{i: int64(v), f: float64(v), isInt: v <= math.MaxInt64}, true
+	case float32:
+		return floatNumber(float64(v)), true
+	case float64:
+		return floatNumber(v), true
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return number{i: i, f: float64(i), isInt: true}, true
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return number{}, false
+		}
+		return floatNumber(f), true
+	default:
+		return number{}, false
+	}
+}
+
+func floatNumber(f float64) number {
+	if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+		return number{i: int64(f), f: f, isInt: true}
+	}
+	return number{f: f}
+}
+
+func (n number) compare(other number) int {
+	if n.isInt && other.isInt {
+		switch {
+		case n.i < other.i:
+			return -1
+		case n.i > other.i:
+			return 1
+		}
+		return 0
+	}
+	switch {
+	case n.f < other.f:
+		return -1
+	case n.f > other.f:
+		return 1
+	}
+	return 0
+}
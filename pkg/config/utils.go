@@ -0,0 +1,145 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// mergeMaps merges src into dst recursively: nested maps are merged key by
+// key and any other value in src replaces the one in dst.
+func mergeMaps(dst, src map[string]interface{}) map[string]interface{} {
+	return mergeMapsWith(dst, src, ArrayReplace)
+}
+
+// flattenMap turns nested maps into a single map keyed by dotted paths.
+func flattenMap(config map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	var flatten func(prefix string, value interface{})
+	flatten = func(prefix string, value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for k, val := range v {
+				flatten(joinKey(prefix, k), val)
+			}
+		default:
+			flat[prefix] = v
+		}
+	}
+	flatten("", config)
+	return flat
+}
+
+func setNestedValue(m map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	current := m
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
+
+func parseEnvValue(value string) interface{} {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	if i, err := strconv.Atoi(value); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil {
+		return f
+	}
+
+	trimmed := strings.TrimSpace(value)
+	if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{") {
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(trimmed), &parsed); err == nil {
+			return parsed
+		}
+	}
+	return value
+}
+
+func deepCopyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			copied[k] = deepCopyValue(val)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, val := range v {
+			copied[i] = deepCopyValue(val)
+		}
+		return copied
+	case []string:
+		return append([]string(nil), v...)
+	case map[string]string:
+		copied := make(map[string]string, len(v))
+		for k, val := range v {
+			copied[k] = val
+		}
+		return copied
+	default:
+		return v
+	}
+}
+
+func isEmptyValue(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case []string:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// diffFlat reports the keys added, changed or removed between two flattened
+// maps, in key order. Removed keys carry a nil NewValue.
+func diffFlat(old, new map[string]interface{}) []ConfigChange {
+	now := time.Now()
+	keys := make([]string, 0, len(old)+len(new))
+	for key := range new {
+		keys = append(keys, key)
+	}
+	for key := range old {
+		if _, exists := new[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var changes []ConfigChange
+	for _, key := range keys {
+		oldValue, hadOld := old[key]
+		newValue, hasNew := new[key]
+		if hadOld && hasNew && reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, ConfigChange{
+			Key:       key,
+			OldValue:  oldValue,
+			NewValue:  newValue,
+			Timestamp: now,
+		})
+	}
+	return changes
+}
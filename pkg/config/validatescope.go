@@ -0,0 +1,97 @@
+package config
+
+import (
+	"errors"
+	"strings"
+)
+
+// ValidateKey runs only the checks that concern key: its validators and
+// schema node, the keyed cross validators that read it, and the schema's
+// required rule for it. Warnings are not reported.
+func (m *ConfigManager) ValidateKey(key string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key = m.canonicalKey(key)
+	report := m.validateScope(func(k string) bool { return k == key }, nil)
+	return report.Err(false)
+}
+
+// ValidateSubtree is ValidateKey for prefix and every key below it, such
+// as storage for storage.engine and storage.data_dir.
+func (m *ConfigManager) ValidateSubtree(prefix string) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	prefix = m.canonicalKey(prefix)
+	report := m.validateScope(func(k string) bool { return keyUnder(prefix, k) }, nil)
+	return report.Err(false)
+}
+
+// ValidateValue checks value as if it were set for key, without storing
+// it, so an update can be rejected before anything acts on it.
+func (m *ConfigManager) ValidateValue(key string, value interface{}) error {
+	return m.ValidateValues(map[string]interface{}{key: value})
+}
+
+// ValidateValues is ValidateValue for several keys at once, so cross
+// validators see all of the new values together.
+func (m *ConfigManager) ValidateValues(values map[string]interface{}) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	candidates := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		candidates[m.canonicalKey(key)] = value
+	}
+	inScope := func(k string) bool {
+		_, ok := candidates[k]
+		return ok
+	}
+	report := m.validateScope(inScope, candidates)
+	return report.Err(false)
+}
+
+// keyUnder reports whether key is prefix or one of its children. An empty
+// prefix covers every key.
+func keyUnder(prefix, key string) bool {
+	return prefix == "" || key == prefix || strings.HasPrefix(key, prefix+".")
+}
+
+// validateScope validates the keys inScope accepts, with candidates taking
+// the place of the stored values. The caller must hold m.mu.
+func (m *ConfigManager) validateScope(inScope func(key string) bool, candidates map[string]interface{}) ValidationReport {
+	var report ValidationReport
+	for key, value := range m.values {
+		if _, replaced := candidates[key]; replaced || !inScope(key) {
+			continue
+		}
+		m.validateValue(&report, key, value)
+	}
+	for key, candidate := range candidates {
+		value := &ConfigValue{Value: candidate, IsSet: true, IsSecret: m.isSecretKey(key)}
+		m.validateValue(&report, key, value)
+	}
+
+	get := m.validationValue
+	if len(candidates) > 0 {
+		get = func(key string) (interface{}, error) {
+			if value, ok := candidates[m.canonicalKey(key)]; ok {
+				return value, nil
+			}
+			return m.validationValue(key)
+		}
+	}
+	m.validateCross(&report, inScope, get)
+
+	var required MultiError
+	m.validateRequired(&required)
+	for _, err := range required.Errors {
+		var configErr *ConfigError
+		if !errors.As(err, &configErr) || !inScope(configErr.Key) {
+			continue
+		}
+		if _, set := candidates[configErr.Key]; set {
+			continue
+		}
+		report.Errors.Add(err)
+	}
+	return report
+}
@@ -3,13 +3,17 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net"
+	"net/mail"
 	"net/url"
 	"os"
 	"reflect"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 type RequiredValidator struct {
@@ -26,8 +30,40 @@ func (v *RequiredValidator) Validate(key string, value interface{}) error {
 	return nil
 }
 
+// KindGroup matches several reflect kinds at once.
+type KindGroup int
+
+const (
+	// AnyInt matches signed and unsigned integers, floats without a
+	// fractional part and integral json.Numbers, so 8080 passes whether it
+	// was read from YAML or JSON.
+	AnyInt KindGroup = iota + 1
+	// AnyFloat matches float32, float64 and json.Number.
+	AnyFloat
+	// AnyNumber matches every integer and float kind and json.Number.
+	AnyNumber
+)
+
+func (g KindGroup) String() string {
+	switch g {
+	case AnyInt:
+		return "integer"
+	case AnyFloat:
+		return "float"
+	case AnyNumber:
+		return "number"
+	}
+	return fmt.Sprintf("KindGroup(%d)", int(g))
+}
+
+// TypeValidator checks the type of a value. Type, if set, takes precedence
+// and matches named types such as time.Duration; otherwise Group, if set,
+// is used, and otherwise the kind must equal ExpectedType. A json.Number
+// satisfies a numeric ExpectedType when it parses as one.
 type TypeValidator struct {
 	ExpectedType reflect.Kind
+	Group        KindGroup
+	Type         reflect.Type
 }
 
 func (v *TypeValidator) Validate(key string, value interface{}) error {
@@ -36,41 +72,136 @@ func (v *TypeValidator) Validate(key string, value interface{}) error {
 		return fmt.Errorf("%s: value is nil", key)
 	}
 
-	if actualType.Kind() != v.ExpectedType {
+	switch {
+	case v.Type != nil:
+		if actualType != v.Type && !actualType.AssignableTo(v.Type) {
+			return fmt.Errorf("%s: expected type %s, got %s", key, v.Type, actualType)
+		}
+	case v.Group != 0:
+		if !v.Group.matches(value) {
+			return fmt.Errorf("%s: expected %s, got %s", key, v.Group, actualType)
+		}
+	case actualType.Kind() != v.ExpectedType:
+		if num, ok := value.(json.Number); ok && jsonNumberFits(num, v.ExpectedType) {
+			return nil
+		}
 		return fmt.Errorf("%s: expected type %s, got %s",
 			key, v.ExpectedType, actualType.Kind())
 	}
 	return nil
 }
 
+func (g KindGroup) matches(value interface{}) bool {
+	if num, ok := value.(json.Number); ok {
+		if g == AnyInt {
+			return jsonNumberFits(num, reflect.Int64)
+		}
+		return jsonNumberFits(num, reflect.Float64)
+	}
+	rv := reflect.ValueOf(value)
+	switch {
+	case isIntKind(rv.Kind()):
+		return g == AnyInt || g == AnyNumber
+	case rv.Kind() == reflect.Float32 || rv.Kind() == reflect.Float64:
+		if g == AnyInt {
+			f := rv.Float()
+			return f == math.Trunc(f) && !math.IsInf(f, 0)
+		}
+		return true
+	}
+	return false
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// jsonNumberFits reports whether num parses as a number of the given kind.
+func jsonNumberFits(num json.Number, kind reflect.Kind) bool {
+	switch {
+	case isIntKind(kind):
+		if _, err := num.Int64(); err == nil {
+			return true
+		}
+		f, err := num.Float64()
+		return err == nil && f == math.Trunc(f) && !math.IsInf(f, 0)
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		_, err := num.Float64()
+		return err == nil
+	}
+	return false
+}
+
+// RangeValidator bounds a number. With neither HasMin nor HasMax set both
+// Min and Max apply; setting either one makes the range one-sided unless
+// the other is set too. Integers are compared as int64, so values above
+// 2^53 such as byte sizes keep their precision.
 type RangeValidator struct {
-	Min float64
-	Max float64
+	Min    float64
+	Max    float64
+	HasMin bool
+	HasMax bool
+	// ExclusiveMin and ExclusiveMax reject values equal to the bound.
+	ExclusiveMin bool
+	ExclusiveMax bool
 }
 
 func (v *RangeValidator) Validate(key string, value interface{}) error {
-	var num float64
-
-	switch val := value.(type) {
-	case int:
-		num = float64(val)
-	case int64:
-		num = float64(val)
-	case float64:
-		num = val
-	case json.Number:
-		f, err := val.Float64()
-		if err != nil {
+	if val, ok := value.(json.Number); ok {
+		if _, err := val.Float64(); err != nil {
 			return fmt.Errorf("%s: cannot convert to number: %w", key, err)
 		}
-		num = f
-	default:
-		return fmt.Errorf("%s: expected type int, int64, float64 or json.Number, got %T", key, value)
 	}
-	if num < v.Min || num > v.Max {
-		return fmt.Errorf("%s: value out of range [%.2f, %.2f]", key, v.Min, v.Max)
+	num, ok := toNumber(value)
+	if !ok {
+		return fmt.Errorf("%s: expected a number, got %T", key, value)
 	}
-	return nil
+
+	checkMin, checkMax := v.HasMin, v.HasMax
+	if !checkMin && !checkMax {
+		checkMin, checkMax = true, true
+	}
+	tooLow := false
+	if checkMin {
+		cmp := num.compare(floatNumber(v.Min))
+		tooLow = cmp < 0 || (v.ExclusiveMin && cmp == 0)
+	}
+	tooHigh := false
+	if checkMax {
+		cmp := num.compare(floatNumber(v.Max))
+		tooHigh = cmp > 0 || (v.ExclusiveMax && cmp == 0)
+	}
+	if !tooLow && !tooHigh {
+		return nil
+	}
+
+	var bounds []string
+	if checkMin {
+		if v.ExclusiveMin {
+			bounds = append(bounds, fmt.Sprintf("greater than %s", formatBound(v.Min)))
+		} else {
+			bounds = append(bounds, fmt.Sprintf("at least %s", formatBound(v.Min)))
+		}
+	}
+	if checkMax {
+		if v.ExclusiveMax {
+			bounds = append(bounds, fmt.Sprintf("less than %s", formatBound(v.Max)))
+		} else {
+			bounds = append(bounds, fmt.Sprintf("at most %s", formatBound(v.Max)))
+		}
+	}
+	return fmt.Errorf("%s: value %v out of range, must be %s", key, value, strings.Join(bounds, " and "))
+}
+
+// formatBound prints f without an exponent, so large integer bounds read
+// as integers.
+func formatBound(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
 }
 
 type PatternValidator struct {
@@ -102,17 +233,78 @@ func (v *PatternValidator) Validate(key string, value interface{}) error {
 	return nil
 }
 
+// LengthValidator bounds the length of a string, counted in characters,
+// or the number of entries in a slice or map. A Max of 0 means no limit.
+type LengthValidator struct {
+	Min int
+	Max int
+}
+
+func (v *LengthValidator) Validate(key string, value interface{}) error {
+	var length int
+	if str, ok := value.(string); ok {
+		length = utf8.RuneCountInString(str)
+	} else {
+		rv := reflect.ValueOf(value)
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			length = rv.Len()
+		default:
+			return fmt.Errorf("%s: expected string, slice or map for length validation, got %T", key, value)
+		}
+	}
+	if length < v.Min {
+		return fmt.Errorf("%s: length %d is less than minimum %d", key, length, v.Min)
+	}
+	if v.Max > 0 && length > v.Max {
+		return fmt.Errorf("%s: length %d is greater than maximum %d", key, length, v.Max)
+	}
+	return nil
+}
+
 type EnumValidator struct {
 	Allowed []interface{}
+	// CaseInsensitive matches strings regardless of case, so "INFO" is
+	// accepted when "info" is allowed.
+	CaseInsensitive bool
 }
 
+// Validate compares numbers by value, so an allowed 8080 read from JSON
+// matches an int 8080 read from YAML. A string close to an allowed one is
+// suggested in the error.
 func (v *EnumValidator) Validate(key string, value interface{}) error {
+	if enumContains(v.Allowed, value) {
+		return nil
+	}
+	str, isString := value.(string)
+	if !isString {
+		return fmt.Errorf("%s: value %v not in allowed set %v", key, value, v.Allowed)
+	}
+
+	var candidates []string
 	for _, allowed := range v.Allowed {
-		if reflect.DeepEqual(allowed, value) {
-			return nil
+		candidate, ok := allowed.(string)
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(candidate, str) {
+			if v.CaseInsensitive {
+				return nil
+			}
+			return fmt.Errorf("%s: value %q not in allowed set %v, did you mean %q?", key, value, v.Allowed, candidate)
 		}
+		candidates = append(candidates, candidate)
+	}
+	if v.CaseInsensitive {
+		str = strings.ToLower(str)
+		for i, candidate := range candidates {
+			candidates[i] = strings.ToLower(candidate)
+		}
+	}
+	if suggestion := closestKey(str, candidates); suggestion != "" {
+		return fmt.Errorf("%s: value %q not in allowed set %v, did you mean %q?", key, value, v.Allowed, suggestion)
 	}
-	return fmt.Errorf("%s: value %v not in allowed set %v", key, value, v.Allowed)
+	return fmt.Errorf("%s: value %q not in allowed set %v", key, value, v.Allowed)
 }
 
 type DurationValidator struct {
@@ -121,20 +313,9 @@ type DurationValidator struct {
 }
 
 func (v *DurationValidator) Validate(key string, value interface{}) error {
-	var d time.Duration
-	switch val := value.(type) {
-	case string:
-		parsed, err := time.ParseDuration(val)
-		if err != nil {
-			return fmt.Errorf("%s: invalid duration string %q: %w", key, val, err)
-		}
-		d = parsed
-	case int:
-		d = time.Duration(val)
-	case float32:
-		d = time.Duration(val)
-	default:
-		return fmt.Errorf("%s: expected duration, got %T", key, value)
+	d, err := toDuration(key, value)
+	if err != nil {
+		return fmt.Errorf("%s: invalid duration: %w", key, err)
 	}
 	if d < v.Min || (v.Max > 0 && d > v.Max) {
 		return fmt.Errorf("%s: duration %v out of range [%v, %v]", key, d, v.Min, v.Max)
@@ -143,6 +324,29 @@ func (v *DurationValidator) Validate(key string, value interface{}) error {
 	return nil
 }
 
+type SizeValidator struct {
+	Min int64
+	Max int64
+	// WarnBelow returns a ValidationWarning for sizes under it.
+	WarnBelow int64
+}
+
+func (v *SizeValidator) Validate(key string, value interface{}) error {
+	size, err := toSizeBytes(key, value)
+	if err != nil {
+		return fmt.Errorf("%s: invalid size: %w", key, err)
+	}
+	if size < v.Min || (v.Max > 0 && size > v.Max) {
+		return fmt.Errorf("%s: size %d out of range [%d, %d]", key, size, v.Min, v.Max)
+	}
+	if size < v.WarnBelow {
+		return &ValidationWarning{
+			Message: fmt.Sprintf("size %d is below the recommended minimum of %d", size, v.WarnBelow),
+		}
+	}
+	return nil
+}
+
 type FileValidator struct {
 	MustExist   bool
 	MustBeDir   bool
@@ -181,6 +385,8 @@ func (v *FileValidator) Validate(key string, value interface{}) error {
 
 type URLValidator struct {
 	Schemas []string
+	// RequireScheme rejects relative references such as "example.com/x".
+	RequireScheme bool
 }
 
 func (v *URLValidator) Validate(key string, value interface{}) error {
@@ -192,6 +398,9 @@ func (v *URLValidator) Validate(key string, value interface{}) error {
 	if err != nil {
 		return fmt.Errorf("%s: invalid URL %q: %w", key, str, err)
 	}
+	if v.RequireScheme && u.Scheme == "" {
+		return fmt.Errorf("%s: URL %q has no scheme", key, str)
+	}
 
 	if len(v.Schemas) > 0 {
 		validScheme := false
@@ -213,6 +422,11 @@ func (v *URLValidator) Validate(key string, value interface{}) error {
 type IPValidator struct {
 	AllowIPv4 bool
 	AllowIPv6 bool
+	// AllowPort also accepts ip:port, such as 0.0.0.0:8080 or [::]:8080.
+	AllowPort bool
+	// AllowCIDR also accepts a network such as 10.0.0.0/8. With CIDRs set,
+	// the whole network must fall inside one of them.
+	AllowCIDR bool
 	CIDRs     []*net.IPNet
 }
 
@@ -240,7 +454,25 @@ func (v *IPValidator) Validate(key string, value interface{}) error {
 		return fmt.Errorf("%s: expected IP address string", key)
 	}
 
-	ip := net.ParseIP(str)
+	addr := str
+	if v.AllowPort {
+		if host, port, err := net.SplitHostPort(str); err == nil {
+			// Port 0 binds to a port the OS picks.
+			if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+				return fmt.Errorf("%s: invalid port in %q", key, str)
+			}
+			addr = host
+		}
+	}
+
+	var network *net.IPNet
+	ip := net.ParseIP(addr)
+	if ip == nil && v.AllowCIDR && strings.Contains(addr, "/") {
+		var err error
+		if ip, network, err = net.ParseCIDR(addr); err != nil {
+			return fmt.Errorf("%s: invalid CIDR %q", key, str)
+		}
+	}
 	if ip == nil {
 		return fmt.Errorf("%s: invalid IP address %q", key, str)
 	}
@@ -258,7 +490,7 @@ func (v *IPValidator) Validate(key string, value interface{}) error {
 	if len(v.CIDRs) > 0 {
 		allowed := false
 		for _, cidr := range v.CIDRs {
-			if cidr.Contains(ip) {
+			if cidr.Contains(ip) && (network == nil || cidrContains(cidr, network)) {
 				allowed = true
 				break
 			}
@@ -270,33 +502,271 @@ func (v *IPValidator) Validate(key string, value interface{}) error {
 	return nil
 }
 
+// cidrContains reports whether inner lies entirely within outer.
+func cidrContains(outer, inner *net.IPNet) bool {
+	outerOnes, outerBits := outer.Mask.Size()
+	innerOnes, innerBits := inner.Mask.Size()
+	return outerBits == innerBits && innerOnes >= outerOnes && outer.Contains(inner.IP)
+}
+
+// IPListValidator checks each entry of a list of addresses against
+// Element. The list may be a slice or a comma-separated string; the error
+// names the index of the first bad entry.
+type IPListValidator struct {
+	Element *IPValidator
+	// AllowEmpty accepts a list with no entries.
+	AllowEmpty bool
+}
+
+func (v *IPListValidator) Validate(key string, value interface{}) error {
+	var entries []interface{}
+	switch val := value.(type) {
+	case string:
+		if strings.TrimSpace(val) != "" {
+			for _, entry := range strings.Split(val, ",") {
+				entries = append(entries, strings.TrimSpace(entry))
+			}
+		}
+	case []string:
+		for _, entry := range val {
+			entries = append(entries, entry)
+		}
+	case []interface{}:
+		entries = val
+	default:
+		return fmt.Errorf("%s: expected list of IP addresses, got %T", key, value)
+	}
+	if len(entries) == 0 && !v.AllowEmpty {
+		return fmt.Errorf("%s: list of IP addresses is empty", key)
+	}
+
+	element := v.Element
+	if element == nil {
+		element = NewIPValidator()
+	}
+	for i, entry := range entries {
+		if err := element.Validate(fmt.Sprintf("%s[%d]", key, i), entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EmailValidator accepts a bare address such as ops@example.com, without a
+// display name.
+type EmailValidator struct{}
+
+func (v *EmailValidator) Validate(key string, value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s: expected email address string", key)
+	}
+	addr, err := mail.ParseAddress(str)
+	if err != nil || addr.Name != "" || addr.Address != str {
+		return fmt.Errorf("%s: invalid email address %q", key, str)
+	}
+	return nil
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// UUIDValidator accepts UUIDs in the 8-4-4-4-12 hex form.
+type UUIDValidator struct{}
+
+func (v *UUIDValidator) Validate(key string, value interface{}) error {
+	str, ok := value.(string)
+	if !ok || !uuidPattern.MatchString(str) {
+		return fmt.Errorf("%s: invalid UUID %v", key, value)
+	}
+	return nil
+}
+
+// RegexValidator accepts values that compile as regular expressions.
+type RegexValidator struct{}
+
+func (v *RegexValidator) Validate(key string, value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s: expected regular expression string", key)
+	}
+	if _, err := regexp.Compile(str); err != nil {
+		return fmt.Errorf("%s: invalid regular expression: %w", key, err)
+	}
+	return nil
+}
+
+// HostValidator accepts RFC 1123 hostnames and IPv4 or IPv6 literals.
+type HostValidator struct {
+	// AllowPort also accepts host:port, with IPv6 literals in brackets.
+	AllowPort bool
+	// MustResolve requires hostnames to resolve with net.LookupHost. IP
+	// literals are never looked up.
+	MustResolve bool
+}
+
+func (v *HostValidator) Validate(key string, value interface{}) error {
+	str, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("%s: expected host string", key)
+	}
+
+	host := str
+	if v.AllowPort {
+		if h, port, err := net.SplitHostPort(str); err == nil {
+			p, err := strconv.Atoi(port)
+			if err != nil || p < 1 || p > 65535 {
+				return fmt.Errorf("%s: invalid port in %q", key, str)
+			}
+			host = h
+		} else if strings.HasPrefix(str, "[") && strings.HasSuffix(str, "]") {
+			if ip := net.ParseIP(str[1 : len(str)-1]); ip != nil && ip.To4() == nil {
+				return nil
+			}
+			return fmt.Errorf("%s: invalid host %q", key, str)
+		}
+	}
+
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	if !isValidHostname(host) {
+		return fmt.Errorf("%s: invalid host %q", key, str)
+	}
+	if v.MustResolve {
+		if _, err := net.LookupHost(host); err != nil {
+			return fmt.Errorf("%s: cannot resolve %q: %w", key, host, err)
+		}
+	}
+	return nil
+}
+
+// isValidHostname reports whether name is a hostname as RFC 1123 defines
+// it. An all-numeric last label is rejected, so malformed IPv4 addresses
+// such as 256.1.1.1 don't pass as names.
+func isValidHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	last := labels[len(labels)-1]
+	return strings.Trim(last, "0123456789") != ""
+}
+
 type PortValidator struct {
 	Min int
 	Max int
+	// AllowZero accepts 0, which asks the OS to pick a free port.
+	AllowZero bool
+	// CheckAvailable binds the port on localhost to make sure nothing else
+	// is listening on it.
+	CheckAvailable bool
 }
 
 func (v *PortValidator) Validate(key string, value interface{}) error {
-	var port int
+	port, err := portNumber(key, value)
+	if err != nil {
+		return err
+	}
+	if port == 0 && v.AllowZero {
+		return nil
+	}
+	if port < v.Min || port > v.Max {
+		return fmt.Errorf("%s: port %d out of range [%d, %d]", key, port, v.Min, v.Max)
+	}
+	if v.CheckAvailable {
+		listener, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+		if err != nil {
+			return fmt.Errorf("%s: port %d is not available: %w", key, port, err)
+		}
+		listener.Close()
+	}
+	return nil
+}
 
+// portNumber converts the forms a port can take in a config source.
+func portNumber(key string, value interface{}) (int, error) {
 	switch val := value.(type) {
 	case int:
-		port = val
+		return val, nil
+	case int64:
+		return int(val), nil
 	case float64:
-		port = int(val)
+		if val != float64(int(val)) {
+			return 0, fmt.Errorf("%s: port %v is not an integer", key, val)
+		}
+		return int(val), nil
+	case json.Number:
+		p, err := val.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("%s: invalid port number %q", key, val)
+		}
+		return int(p), nil
 	case string:
-		p, err := strconv.Atoi(val)
+		p, err := strconv.Atoi(strings.TrimSpace(val))
 		if err != nil {
-			return fmt.Errorf("%s: invalid port number %q", key, val)
+			return 0, fmt.Errorf("%s: invalid port number %q", key, val)
 		}
-		port = p
+		return p, nil
 	default:
-		return fmt.Errorf("%s: expected port number, got %T", key, value)
-
+		return 0, fmt.Errorf("%s: expected port number, got %T", key, value)
 	}
-	if port < v.Min || port > v.Max {
-		return fmt.Errorf("%s: port %d out of range [%d, %d]", key, port, v.Min, v.Max)
+}
+
+// CrossKeyValidator checks several keys together, for constraints no
+// single-key validator can see. Check gets the values of the keys in Keys
+// that are set.
+type CrossKeyValidator struct {
+	Keys  []string
+	Check func(values map[string]interface{}) error
+}
+
+// NewUniquePortValidator fails when two of keys are set to the same port.
+// Port 0 is never a collision.
+func NewUniquePortValidator(keys ...string) *CrossKeyValidator {
+	return &CrossKeyValidator{
+		Keys: keys,
+		Check: func(values map[string]interface{}) error {
+			var multiErr MultiError
+			used := make(map[int]string)
+			for _, key := range keys {
+				value, exists := values[key]
+				if !exists {
+					continue
+				}
+				port, err := portNumber(key, value)
+				if err != nil || port == 0 {
+					continue
+				}
+				if other, taken := used[port]; taken {
+					multiErr.Add(&ConfigError{
+						Key:     key,
+						Message: fmt.Sprintf("port %d is already used by %s", port, other),
+						Code:    CodeConflict,
+					})
+					continue
+				}
+				used[port] = key
+			}
+			if multiErr.HasErrors() {
+				return &multiErr
+			}
+			return nil
+		},
 	}
-	return nil
 }
 
 type CompositeValidator struct {
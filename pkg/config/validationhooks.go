@@ -0,0 +1,71 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AddValidationHook registers hook to be called with every value Set is
+// about to store and every value ValidateAll checks, along with the name
+// of its source. A non-nil error rejects the value. The plugin registry
+// registers its config.validate hooks this way. Hooks run without the
+// manager locked, so they may read configuration through it.
+func (m *ConfigManager) AddValidationHook(hook func(key string, value interface{}, source string) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.validationHooks = append(m.validationHooks, hook)
+}
+
+type validationHook = func(key string, value interface{}, source string) error
+
+// hookCheck is a value captured under m.mu for the validation hooks to
+// check once it has been released.
+type hookCheck struct {
+	key    string
+	value  interface{}
+	source ConfigSource
+	secret bool
+}
+
+// pluginError is implemented by hook errors that name the plugin they
+// came from, such as plugin.HookError.
+type pluginError interface {
+	error
+	HookPluginID() string
+}
+
+// copyValidationHooks returns the registered hooks. The caller must hold
+// m.mu.
+func (m *ConfigManager) copyValidationHooks() []validationHook {
+	if len(m.validationHooks) == 0 {
+		return nil
+	}
+	return append([]validationHook(nil), m.validationHooks...)
+}
+
+// runValidationHooks runs hooks for one value and returns the first
+// rejection. It must be called without m.mu held.
+func runValidationHooks(hooks []validationHook, check hookCheck) error {
+	for _, hook := range hooks {
+		err := hook(check.key, check.value, check.source.String())
+		if err == nil {
+			continue
+		}
+		configErr := &ConfigError{Key: check.key, Message: "rejected by validation hook", Err: err}
+		var fromPlugin pluginError
+		if errors.As(err, &fromPlugin) {
+			configErr.Message = fmt.Sprintf("rejected by plugin %s", fromPlugin.HookPluginID())
+			if cause := errors.Unwrap(fromPlugin); cause != nil {
+				configErr.Err = cause
+			}
+		}
+		if configErr.Code = errorCode(configErr.Err); configErr.Code == CodeInvalidValue {
+			configErr.Code = CodeRejected
+		}
+		if check.secret {
+			return secretValidationError(check.key, configErr)
+		}
+		return configErr
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+const (
+	defaultKubernetesJWTPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	vaultMinRenewDelay       = time.Second
+)
+
+// NewVaultSecretStoreFromConfig logs in with the configured auth method and
+// starts a goroutine that renews the token before its lease runs out,
+// logging in again when it can no longer be renewed. Close stops it.
+func NewVaultSecretStoreFromConfig(cfg VaultConfig, logger Logger) (*VaultSecretStore, error) {
+	config := vault.DefaultConfig()
+	config.Address = cfg.Address
+	client, err := vault.NewClient(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Vault client: %w", err)
+	}
+
+	mountPath := cfg.MountPath
+	if mountPath == "" {
+		mountPath = "secret"
+	}
+	s := &VaultSecretStore{
+		client:    client,
+		mountPath: mountPath,
+		cache:     make(map[string]cachedSecret),
+		logger:    logger,
+		auth:      cfg,
+		done:      make(chan struct{}),
+	}
+
+	ttl, renewable, err := s.login()
+	if err != nil && s.canLogin() {
+		return nil, err
+	}
+	// A static token is still usable when it can't look itself up, it just
+	// won't be renewed.
+	if err != nil && logger != nil {
+		logger.Warn("Vault token lookup failed, token will not be renewed", "error", err)
+	}
+	s.recordAuth(ttl, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	go s.renewLoop(ctx, ttl, renewable)
+	return s, nil
+}
+
+// Close stops the token renewer.
+func (s *VaultSecretStore) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// Health reports whether the store currently holds a working token.
+func (s *VaultSecretStore) Health() SecretStoreHealth {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	health := SecretStoreHealth{
+		Backend:   "vault",
+		Healthy:   s.authErr == nil,
+		LastError: s.authErr,
+		LastAuth:  s.lastAuth,
+		Expires:   s.tokenExpiry,
+	}
+	if health.Healthy && !s.tokenExpiry.IsZero() && time.Now().After(s.tokenExpiry) {
+		health.Healthy = false
+		health.LastError = fmt.Errorf("Vault token expired at %s", s.tokenExpiry.Format(time.RFC3339))
+	}
+	return health
+}
+
+func (s *VaultSecretStore) recordAuth(ttl time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.authErr = err
+	if err != nil {
+		return
+	}
+	s.lastAuth = time.Now()
+	s.tokenExpiry = time.Time{}
+	if ttl > 0 {
+		s.tokenExpiry = s.lastAuth.Add(ttl)
+	}
+}
+
+func (s *VaultSecretStore) canLogin() bool {
+	return s.auth.AuthMethod == "approle" || s.auth.AuthMethod == "kubernetes"
+}
+
+// login authenticates with the configured method and returns the token's
+// TTL and whether it can be renewed. For a static token it only looks the
+// token up.
+func (s *VaultSecretStore) login() (time.Duration, bool, error) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+
+	var path string
+	var data map[string]interface{}
+	switch s.auth.AuthMethod {
+	case "", "token":
+		s.client.SetToken(s.auth.Token)
+		secret, err := s.client.Auth().Token().LookupSelf()
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to look up Vault token: %w", err)
+		}
+		ttl, _ := secret.TokenTTL()
+		renewable, _ := secret.TokenIsRenewable()
+		return ttl, renewable, nil
+	case "approle":
+		if s.auth.RoleID == "" {
+			return 0, false, fmt.Errorf("approle auth requires a role_id")
+		}
+		path = "auth/" + s.authMount("approle") + "/login"
+		data = map[string]interface{}{
+			"role_id":   s.auth.RoleID,
+			"secret_id": s.auth.SecretID,
+		}
+	case "kubernetes":
+		if s.auth.Role == "" {
+			return 0, false, fmt.Errorf("kubernetes auth requires a role")
+		}
+		jwtPath := s.auth.JWTPath
+		if jwtPath == "" {
+			jwtPath = defaultKubernetesJWTPath
+		}
+		// The service account token is re-read on every login because the
+		// kubelet rotates it.
+		jwt, err := os.ReadFile(jwtPath)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		path = "auth/" + s.authMount("kubernetes") + "/login"
+		data = map[string]interface{}{
+			"role": s.auth.Role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		}
+	default:
+		return 0, false, fmt.Errorf("unknown Vault auth method %q", s.auth.AuthMethod)
+	}
+
+	// Log in with a token-less clone so an expired token isn't sent along.
+	loginClient, err := s.client.Clone()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to create Vault login client: %w", err)
+	}
+	loginClient.ClearToken()
+	secret, err := loginClient.Logical().Write(path, data)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to log in to Vault with %s: %w", s.auth.AuthMethod, err)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return 0, false, fmt.Errorf("%s login returned no token", s.auth.AuthMethod)
+	}
+	s.client.SetToken(secret.Auth.ClientToken)
+	return time.Duration(secret.Auth.LeaseDuration) * time.Second, secret.Auth.Renewable, nil
+}
+
+func (s *VaultSecretStore) authMount(method string) string {
+	if s.auth.AuthMount != "" {
+		return strings.Trim(s.auth.AuthMount, "/")
+	}
+	return method
+}
+
+// refresh renews the current token, or logs in again when it can't be
+// renewed any more.
+func (s *VaultSecretStore) refresh(renewable bool) (time.Duration, bool, error) {
+	if renewable {
+		secret, err := s.client.Auth().Token().RenewSelf(0)
+		if err == nil && secret != nil && secret.Auth != nil {
+			// Near its max TTL a token renews for less and less; past a
+			// second it is simpler to start over with a fresh login.
+			ttl := time.Duration(secret.Auth.LeaseDuration) * time.Second
+			if ttl >= vaultMinRenewDelay || !s.canLogin() {
+				return ttl, secret.Auth.Renewable, nil
+			}
+		}
+		if err != nil && !s.canLogin() {
+			return 0, false, fmt.Errorf("failed to renew Vault token: %w", err)
+		}
+	}
+	if !s.canLogin() {
+		return 0, false, fmt.Errorf("token is not renewable")
+	}
+	return s.login()
+}
+
+func (s *VaultSecretStore) renewLoop(ctx context.Context, ttl time.Duration, renewable bool) {
+	defer close(s.done)
+
+	for {
+		// A token without a TTL never expires, so there is nothing to renew.
+		// Neither is there for a static token that can't be renewed; Health
+		// reports it once it has expired.
+		if ttl <= 0 || (!renewable && !s.canLogin()) {
+			<-ctx.Done()
+			return
+		}
+		timer := time.NewTimer(renewDelay(ttl))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		backoff := backendRetryBackoff
+		newTTL, newRenewable, err := s.refresh(renewable)
+		for err != nil {
+			s.recordAuth(0, err)
+			if s.logger != nil {
+				s.logger.Error("Vault token renewal failed", "error", err)
+			}
+			if !sleepBackoff(ctx, &backoff) {
+				return
+			}
+			newTTL, newRenewable, err = s.refresh(renewable)
+		}
+		ttl, renewable = newTTL, newRenewable
+		s.recordAuth(ttl, nil)
+	}
+}
+
+// renewDelay waits for about two thirds of ttl, with up to a tenth of ttl
+// of jitter so a fleet started together doesn't renew together.
+func renewDelay(ttl time.Duration) time.Duration {
+	delay := ttl * 2 / 3
+	if jitter := int64(ttl / 10); jitter > 0 {
+		delay -= time.Duration(rand.Int63n(jitter))
+	}
+	if delay < vaultMinRenewDelay {
+		delay = vaultMinRenewDelay
+	}
+	return delay
+}
+
+// do runs op and, when Vault answers permission denied and the store can
+// log in by itself, logs in again and retries once.
+func (s *VaultSecretStore) do(op func() (*vault.Secret, error)) (*vault.Secret, error) {
+	secret, err := op()
+	if err == nil || !isVaultPermissionDenied(err) || !s.canLogin() {
+		return secret, err
+	}
+	ttl, _, loginErr := s.login()
+	s.recordAuth(ttl, loginErr)
+	if loginErr != nil {
+		return nil, err
+	}
+	return op()
+}
+
+func isVaultPermissionDenied(err error) bool {
+	var respErr *vault.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+// ConfigView is a read/write window onto the keys below a single prefix of a
+// ConfigManager. Keys passed to a view are relative to that prefix.
+type ConfigView struct {
+	manager *ConfigManager
+	prefix  string
+}
+
+func (m *ConfigManager) Sub(prefix string) *ConfigView {
+	return &ConfigView{
+		manager: m,
+		prefix:  strings.Trim(prefix, "."),
+	}
+}
+
+func (v *ConfigView) Prefix() string {
+	return v.prefix
+}
+
+func (v *ConfigView) Sub(prefix string) *ConfigView {
+	return v.manager.Sub(v.key(strings.Trim(prefix, ".")))
+}
+
+func (v *ConfigView) Get(key string) (interface{}, error) {
+	return v.manager.Get(v.key(key))
+}
+
+func (v *ConfigView) GetString(key string) (string, error) {
+	return v.manager.GetString(v.key(key))
+}
+
+func (v *ConfigView) GetInt(key string) (int, error) {
+	return v.manager.GetInt(v.key(key))
+}
+
+func (v *ConfigView) GetBool(key string) (bool, error) {
+	return v.manager.GetBool(v.key(key))
+}
+
+func (v *ConfigView) GetDuration(key string) (time.Duration, error) {
+	return v.manager.GetDuration(v.key(key))
+}
+
+func (v *ConfigView) GetFloat(key string) (float64, error) {
+	return v.manager.GetFloat(v.key(key))
+}
+
+func (v *ConfigView) GetStringSlice(key string) ([]string, error) {
+	return v.manager.GetStringSlice(v.key(key))
+}
+
+func (v *ConfigView) GetStringMap(key string) (map[string]interface{}, error) {
+	return v.manager.GetStringMap(v.key(key))
+}
+
+func (v *ConfigView) GetStringMapString(key string) (map[string]string, error) {
+	return v.manager.GetStringMapString(v.key(key))
+}
+
+func (v *ConfigView) GetStringOrDefault(key, def string) string {
+	return v.manager.GetStringOrDefault(v.key(key), def)
+}
+
+func (v *ConfigView) GetIntOrDefault(key string, def int) int {
+	return v.manager.GetIntOrDefault(v.key(key), def)
+}
+
+func (v *ConfigView) GetBoolOrDefault(key string, def bool) bool {
+	return v.manager.GetBoolOrDefault(v.key(key), def)
+}
+
+func (v *ConfigView) GetDurationOrDefault(key string, def time.Duration) time.Duration {
+	return v.manager.GetDurationOrDefault(v.key(key), def)
+}
+
+func (v *ConfigView) Set(key string, value interface{}, source ConfigSource, dynamic bool) error {
+	return v.manager.Set(v.key(key), value, source, dynamic)
+}
+
+func (v *ConfigView) Unmarshal(target interface{}) error {
+	return v.manager.Unmarshal(v.prefix, target)
+}
+
+// AllSettings returns the nested map of every value below the view's prefix,
+// suitable for handing to a plugin's Init.
+func (v *ConfigView) AllSettings() map[string]interface{} {
+	settings, ok := v.manager.subtree(v.prefix).(map[string]interface{})
+	if !ok {
+		return make(map[string]interface{})
+	}
+	return settings
+}
+
+// Watch returns a subscription to changes inside the view, with Key
+// rewritten to be relative to the view's prefix. Release it with Unwatch.
+func (v *ConfigView) Watch() <-chan ConfigChange {
+	return v.manager.subscribe(v.prefix)
+}
+
+func (v *ConfigView) Unwatch(ch <-chan ConfigChange) {
+	v.manager.Unwatch(ch)
+}
+
+func (v *ConfigView) key(key string) string {
+	if v.prefix == "" {
+		return key
+	}
+	if key == "" {
+		return v.prefix
+	}
+	return v.prefix + "." + key
+}
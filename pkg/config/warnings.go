@@ -0,0 +1,74 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ValidationWarning is returned by a validator for a value that is legal
+// but suspicious. It is reported without failing validation.
+type ValidationWarning struct {
+	Message string
+}
+
+func (w *ValidationWarning) Error() string {
+	return w.Message
+}
+
+// ConfigWarning is a warning about the value of Key.
+type ConfigWarning struct {
+	Key     string
+	Message string
+}
+
+func (w ConfigWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.Key, w.Message)
+}
+
+// ValidationReport holds everything ValidateAll found: the errors that
+// fail validation and the warnings that don't.
+type ValidationReport struct {
+	Errors   MultiError
+	Warnings []ConfigWarning
+}
+
+// Err returns the report's errors, or nil if there are none. With strict,
+// warnings count as errors too.
+func (r *ValidationReport) Err(strict bool) error {
+	multiErr := MultiError{Errors: append([]error(nil), r.Errors.Errors...)}
+	if strict {
+		for _, warning := range r.Warnings {
+			multiErr.Add(&ConfigError{Key: warning.Key, Message: warning.Message})
+		}
+	}
+	if multiErr.HasErrors() {
+		return &multiErr
+	}
+	return nil
+}
+
+func (r *ValidationReport) warn(key, message string) {
+	r.Warnings = append(r.Warnings, ConfigWarning{Key: key, Message: message})
+}
+
+// addWarning records err as a warning for key if it is a
+// ValidationWarning, and reports whether it was.
+func (r *ValidationReport) addWarning(key string, err error) bool {
+	warning, ok := asWarning(err)
+	if ok {
+		r.warn(key, warning.Message)
+	}
+	return ok
+}
+
+func asWarning(err error) (*ValidationWarning, bool) {
+	var warning *ValidationWarning
+	ok := errors.As(err, &warning)
+	return warning, ok
+}
+
+func (m *ConfigManager) logWarnings(report ValidationReport) {
+	for _, warning := range report.Warnings {
+		m.logger.Warn("config warning", "key", warning.Key, "warning", warning.Message)
+	}
+}
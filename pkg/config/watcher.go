@@ -1,92 +1,506 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 )
 
+const (
+	defaultDebounce = 100 * time.Millisecond
+
+	// watcherErrorBuffer is how many errors Errors holds before further
+	// ones are dropped.
+	watcherErrorBuffer = 16
+
+	minRecreateBackoff = 100 * time.Millisecond
+	maxRecreateBackoff = 30 * time.Second
+)
+
+// WatchCallback is called with the path of a watched file and the hex
+// SHA-256 of its new content, or "" if the file can no longer be read.
+type WatchCallback func(path, hash string)
+
 type FileWatcher struct {
 	watcher   *fsnotify.Watcher
-	callbacks map[string][]func()
-	mu        sync.RWMutex
-	running   bool
-	stopCh    chan struct{}
+	callbacks map[string][]WatchCallback
+	// hashes holds the content hash last delivered for each watched file.
+	hashes map[string]string
+	// dirCallbacks fire for any non-hidden file created, written or
+	// removed inside the directory.
+	dirCallbacks map[string][]func()
+	mu           sync.RWMutex
+	running      bool
+	stopCh       chan struct{}
+	stopOnce     sync.Once
+	logger       Logger
+	errCh        chan error
+
+	debounce      time.Duration
+	debounceMu    sync.Mutex
+	debounceTimer *time.Timer
+	pendingPaths  map[string]bool
+
+	// deliveries holds callbacks waiting to run. They run one at a time
+	// in the order they were queued, so a slow reload can't finish after
+	// a newer one.
+	deliverMu  sync.Mutex
+	deliveries []func()
+	delivering bool
+}
+
+// FileWatcherOption configures a FileWatcher.
+type FileWatcherOption func(*FileWatcher)
+
+// WithDebounce sets how long the watcher waits for events to stop before
+// firing callbacks. The default is 100ms.
+func WithDebounce(d time.Duration) FileWatcherOption {
+	return func(w *FileWatcher) {
+		w.debounce = d
+	}
 }
 
-func NewFileWatcher() *FileWatcher {
-	return &FileWatcher{
-		callbacks: make(map[string][]func()),
-		stopCh:    make(chan struct{}),
+func NewFileWatcher(opts ...FileWatcherOption) *FileWatcher {
+	w := &FileWatcher{
+		callbacks:    make(map[string][]WatchCallback),
+		hashes:       make(map[string]string),
+		dirCallbacks: make(map[string][]func()),
+		stopCh:       make(chan struct{}),
+		logger:       &DefaultLogger{},
+		errCh:        make(chan error, watcherErrorBuffer),
+		debounce:     defaultDebounce,
+		pendingPaths: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(w)
 	}
+	return w
 }
 
+// Start creates the fsnotify watcher, adds every path registered so far and
+// starts delivering events. Calling Start on a running watcher is a no-op.
 func (w *FileWatcher) Start() error {
-	watcher, err := fsnotify.NewWatcher()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.running {
+		return nil
+	}
+	select {
+	case <-w.stopCh:
+		return fmt.Errorf("file watcher stopped")
+	default:
+	}
+
+	watcher, err := w.newWatcher()
 	if err != nil {
 		return err
 	}
-
 	w.watcher = watcher
 	w.running = true
+	go w.watchLoop(watcher)
+
+	return nil
+}
+
+// newWatcher creates an fsnotify watcher covering every registered path.
+// The caller must hold w.mu.
+func (w *FileWatcher) newWatcher() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for path := range w.callbacks {
+		if err := addWatch(watcher, path); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+	for dir := range w.dirCallbacks {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch directory %s: %w", dir, err)
+		}
+	}
+	return watcher, nil
+}
+
+// SetLogger replaces the logger watch errors are reported to, which is a
+// DefaultLogger unless set.
+func (w *FileWatcher) SetLogger(logger Logger) {
+	if logger == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.logger = logger
+}
+
+// Errors delivers the errors the watcher runs into, such as losing its
+// fsnotify instance. They are logged as well; when nobody reads them the
+// oldest are kept and newer ones dropped. The channel is never closed.
+func (w *FileWatcher) Errors() <-chan error {
+	return w.errCh
+}
+
+func (w *FileWatcher) reportError(err error) {
+	w.mu.RLock()
+	logger := w.logger
+	w.mu.RUnlock()
+	logger.Error("file watcher error", "error", err)
+	select {
+	case w.errCh <- err:
+	default:
+	}
+}
+
+func (w *FileWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.watcher != nil {
+			w.watcher.Close()
+		}
+		w.running = false
+
+		w.debounceMu.Lock()
+		if w.debounceTimer != nil {
+			w.debounceTimer.Stop()
+			w.debounceTimer = nil
+		}
+		w.debounceMu.Unlock()
+	})
+}
+
+// Watch registers callback for changes to path. The parent directory is
+// watched as well, so the callback keeps firing when an editor replaces the
+// file by renaming a new one over it. Events that leave the content as it
+// was, such as a touch, don't fire the callback.
+func (w *FileWatcher) Watch(path string, callback WatchCallback) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, watched := w.callbacks[absPath]; !watched {
+		w.hashes[absPath] = fileHash(absPath)
+	}
+	w.callbacks[absPath] = append(w.callbacks[absPath], callback)
+	if w.running {
+		return addWatch(w.watcher, absPath)
+	}
+	return nil
+}
+
+// WatchDir registers callback for files being added to, changed in or
+// removed from dir.
+func (w *FileWatcher) WatchDir(dir string, callback func()) error {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.dirCallbacks[absDir] = append(w.dirCallbacks[absDir], callback)
+	if w.running {
+		if err := w.watcher.Add(absDir); err != nil {
+			return fmt.Errorf("failed to watch directory %s: %w", absDir, err)
+		}
+	}
+	return nil
+}
+
+// Remove drops the callbacks registered for path with Watch or WatchDir
+// and stops watching it. A parent directory stays watched while another
+// registered file still lives in it.
+func (w *FileWatcher) Remove(path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, isFile := w.callbacks[absPath]
+	_, isDir := w.dirCallbacks[absPath]
+	if !isFile && !isDir {
+		return fmt.Errorf("%s is not being watched", absPath)
+	}
+	delete(w.callbacks, absPath)
+	delete(w.hashes, absPath)
+	delete(w.dirCallbacks, absPath)
+
+	w.debounceMu.Lock()
+	delete(w.pendingPaths, absPath)
+	w.debounceMu.Unlock()
 
+	if !w.running {
+		return nil
+	}
+	if err := w.unwatch(absPath); err != nil {
+		return err
+	}
+	if isFile {
+		return w.unwatch(filepath.Dir(absPath))
+	}
+	return nil
+}
+
+// unwatch removes the fsnotify watch on path unless a registered file or
+// directory still needs it. The caller must hold w.mu.
+func (w *FileWatcher) unwatch(path string) error {
+	if _, ok := w.callbacks[path]; ok {
+		return nil
+	}
+	if _, ok := w.dirCallbacks[path]; ok {
+		return nil
+	}
+	for file := range w.callbacks {
+		if filepath.Dir(file) == path {
+			return nil
+		}
+	}
+	if err := w.watcher.Remove(path); err != nil && !errors.Is(err, fsnotify.ErrNonExistentWatch) {
+		return fmt.Errorf("failed to stop watching %s: %w", path, err)
+	}
 	return nil
 }
 
-func (w *FileWatcher) watchLoop() {
-	var debounceTimer *time.Timer
-	pendingPaths := make(map[string]bool)
-	debounceMutex := sync.Mutex{}
+// addWatch watches path's directory and, if it exists yet, the file itself.
+func addWatch(watcher *fsnotify.Watcher, path string) error {
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to watch directory of %s: %w", path, err)
+	}
+	if err := watcher.Add(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to watch %s: %w", path, err)
+	}
+	return nil
+}
+
+// watchLoop delivers events until the watcher is stopped, recreating the
+// fsnotify watcher whenever it is lost.
+func (w *FileWatcher) watchLoop(watcher *fsnotify.Watcher) {
+	for w.watchEvents(watcher) {
+		watcher.Close()
+		w.reportError(fmt.Errorf("fsnotify watcher closed unexpectedly"))
+		if watcher = w.recreate(); watcher == nil {
+			return
+		}
+	}
+}
 
+// watchEvents handles watcher's events and reports whether it stopped
+// because the fsnotify watcher was lost rather than because w was stopped.
+func (w *FileWatcher) watchEvents(watcher *fsnotify.Watcher) bool {
 	for {
 		select {
 		case <-w.stopCh:
-			return
-		case event, ok := <-w.watcher.Events:
+			return false
+		case event, ok := <-watcher.Events:
 			if !ok {
-				return
+				return !w.stopped()
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
 			}
+			dir, base := filepath.Split(event.Name)
+			dir = filepath.Clean(dir)
+			swapped := base == kubernetesDataLink
+			hidden := strings.HasPrefix(base, ".") && !swapped
 			w.mu.RLock()
-			_, exists := w.callbacks[event.Name]
+			files := w.affectedFiles(dir, base, swapped)
+			_, dirWatched := w.dirCallbacks[dir]
 			w.mu.RUnlock()
 
-			if exists {
-				debounceMutex.Lock()
-				pendingPaths[event.Name] = true
+			for _, file := range files {
+				if event.Has(fsnotify.Create) && file == event.Name {
+					// The file was renamed over or recreated, which drops
+					// the watch on the old one.
+					watcher.Add(file)
+				}
+				w.schedule(file)
+			}
+			if dirWatched && !hidden {
+				w.schedule(dir)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return !w.stopped()
+			}
+			w.reportError(err)
+		}
+	}
+}
+
+func (w *FileWatcher) stopped() bool {
+	select {
+	case <-w.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// recreate replaces a lost fsnotify watcher, retrying with exponential
+// backoff until it succeeds or w is stopped, in which case it returns nil.
+// Every watched path is then checked for changes missed in between.
+func (w *FileWatcher) recreate() *fsnotify.Watcher {
+	backoff := minRecreateBackoff
+	for {
+		select {
+		case <-w.stopCh:
+			return nil
+		case <-time.After(backoff):
+		}
+
+		w.mu.Lock()
+		if !w.running {
+			w.mu.Unlock()
+			return nil
+		}
+		watcher, err := w.newWatcher()
+		var paths []string
+		if err == nil {
+			w.watcher = watcher
+			for path := range w.callbacks {
+				paths = append(paths, path)
+			}
+			for dir := range w.dirCallbacks {
+				paths = append(paths, dir)
+			}
+		}
+		logger := w.logger
+		w.mu.Unlock()
+
+		if err == nil {
+			logger.Info("file watcher recreated", "paths", len(paths))
+			for _, path := range paths {
+				w.schedule(path)
+			}
+			return watcher
+		}
+		w.reportError(fmt.Errorf("failed to recreate fsnotify watcher: %w", err))
+		backoff = min(backoff*2, maxRecreateBackoff)
+	}
+}
 
-				if debounceTimer == nil {
-					debounceTimer = time.AfterFunc(100*time.Millisecond, func() {
-						debounceMutex.Lock()
-						paths := make([]string, 0, len(pendingPaths))
+// affectedFiles returns the watched files an event for base in dir
+// concerns: the file named base, or every watched file in dir when a
+// Kubernetes volume swapped its ..data link, since the files there are
+// links through it and no event names them. The caller must hold w.mu.
+func (w *FileWatcher) affectedFiles(dir, base string, swapped bool) []string {
+	var files []string
+	for file := range w.callbacks {
+		if filepath.Dir(file) != dir {
+			continue
+		}
+		if swapped || filepath.Base(file) == base {
+			files = append(files, file)
+		}
+	}
+	return files
+}
 
-						for path := range pendingPaths {
-							paths = append(paths, path)
-						}
-						pendingPaths = make(map[string]bool)
-						debounceMutex.Unlock()
+// schedule queues path and fires its callbacks once no further events have
+// arrived for the debounce window.
+func (w *FileWatcher) schedule(path string) {
+	w.debounceMu.Lock()
+	defer w.debounceMu.Unlock()
 
-						w.mu.RLock()
-						for _, path := range paths {
-							for _, cp := range w.callbacks[path] {
-								go cp()
-							}
-						}
+	w.pendingPaths[path] = true
+	if w.debounceTimer != nil {
+		w.debounceTimer.Reset(w.debounce)
+		return
+	}
+	w.debounceTimer = time.AfterFunc(w.debounce, w.flush)
+}
 
-						w.mu.RUnlock()
+func (w *FileWatcher) flush() {
+	w.debounceMu.Lock()
+	paths := make([]string, 0, len(w.pendingPaths))
+	for path := range w.pendingPaths {
+		paths = append(paths, path)
+	}
+	w.pendingPaths = make(map[string]bool)
+	w.debounceTimer = nil
+	w.debounceMu.Unlock()
 
-					})
-					debounceMutex.Unlock()
-				}
+	select {
+	case <-w.stopCh:
+		return
+	default:
+	}
 
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, path := range paths {
+		if callbacks, ok := w.callbacks[path]; ok {
+			hash := fileHash(path)
+			if hash == w.hashes[path] {
+				continue
 			}
-		case err, ok := <-w.watcher.Errors:
-			if !ok {
-				return
+			w.hashes[path] = hash
+			for _, cb := range callbacks {
+				w.deliver(func() { cb(path, hash) })
 			}
-			fmt.Printf("file watcher error: %v\n", err)
+		}
+		for _, cb := range w.dirCallbacks[path] {
+			w.deliver(cb)
+		}
+	}
+}
 
+// deliver queues fn behind the callbacks already waiting and starts a
+// goroutine to run them if none is running.
+func (w *FileWatcher) deliver(fn func()) {
+	w.deliverMu.Lock()
+	defer w.deliverMu.Unlock()
+	w.deliveries = append(w.deliveries, fn)
+	if w.delivering {
+		return
+	}
+	w.delivering = true
+	go w.runDeliveries()
+}
+
+func (w *FileWatcher) runDeliveries() {
+	for {
+		w.deliverMu.Lock()
+		if len(w.deliveries) == 0 {
+			w.delivering = false
+			w.deliverMu.Unlock()
+			return
 		}
+		fn := w.deliveries[0]
+		w.deliveries = w.deliveries[1:]
+		w.deliverMu.Unlock()
+		fn()
+	}
+}
+
+// fileHash returns the hex SHA-256 of path's content, or "" if it cannot
+// be read.
+func fileHash(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
 	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
@@ -0,0 +1,275 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var ErrSecretKey = errors.New("refusing to write a secret key in plaintext")
+
+// ConfigWriter edits config files on disk. JSON and YAML files are edited
+// through the YAML node tree, so key order, and for YAML comments, survive
+// the rewrite; other formats are decoded, updated and marshalled again.
+type ConfigWriter struct {
+	manager *ConfigManager
+}
+
+func NewConfigWriter(manager *ConfigManager) *ConfigWriter {
+	return &ConfigWriter{manager: manager}
+}
+
+// SetInFile sets key to value in the file at path and replaces the file
+// atomically. Keys the manager treats as secret are refused with
+// ErrSecretKey.
+func (w *ConfigWriter) SetInFile(path, key string, value interface{}) error {
+	if w.isSecret(key) {
+		return &ConfigError{Key: key, Message: "cannot persist key", Err: ErrSecretKey}
+	}
+
+	format := w.manager.loader.detectFormat(path)
+	if format == nil {
+		return fmt.Errorf("unsupported format for file %s", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var updated []byte
+	switch format.Name() {
+	case "yaml", "json":
+		updated, err = setInYAMLNode(data, key, value, format.Name() == "json")
+	default:
+		updated, err = setInDecoded(format, data, key, value)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", path, err)
+	}
+	return writeFileAtomic(path, updated)
+}
+
+func (w *ConfigWriter) isSecret(key string) bool {
+	w.manager.mu.RLock()
+	defer w.manager.mu.RUnlock()
+
+	key = w.manager.canonicalKey(key)
+	if w.manager.isSecretKey(key) {
+		return true
+	}
+	value, exists := w.manager.values[key]
+	return exists && value.IsSecret
+}
+
+func setInDecoded(format ConfigFormat, data []byte, key string, value interface{}) ([]byte, error) {
+	config := make(map[string]interface{})
+	if len(bytes.TrimSpace(data)) > 0 {
+		decoded, err := format.Unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+		if decoded != nil {
+			config = decoded
+		}
+	}
+	setNestedValue(config, key, value)
+	return format.Marshal(config)
+}
+
+func setInYAMLNode(data []byte, key string, value interface{}, asJSON bool) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode}
+	}
+	if len(doc.Content) == 0 {
+		doc.Content = []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}
+	}
+
+	var valueNode yaml.Node
+	if err := valueNode.Encode(value); err != nil {
+		return nil, err
+	}
+
+	node := doc.Content[0]
+	segments := strings.Split(key, ".")
+	for i, segment := range segments {
+		if node.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s is not a map", strings.Join(segments[:i], "."))
+		}
+		last := i == len(segments)-1
+
+		var child *yaml.Node
+		for j := 0; j+1 < len(node.Content); j += 2 {
+			if node.Content[j].Value == segment {
+				child = node.Content[j+1]
+				break
+			}
+		}
+
+		switch {
+		case child == nil && last:
+			node.Content = append(node.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: segment}, &valueNode)
+		case child == nil:
+			child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: segment}, child)
+		case last:
+			valueNode.LineComment = child.LineComment
+			*child = valueNode
+		case child.Kind != yaml.MappingNode:
+			*child = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		}
+		node = child
+	}
+
+	if asJSON {
+		var buf bytes.Buffer
+		if err := writeJSONNode(&buf, doc.Content[0], jsonIndent(data), 0); err != nil {
+			return nil, err
+		}
+		buf.WriteString("\n")
+		return buf.Bytes(), nil
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeJSONNode writes node as JSON, keeping the order of mapping keys.
+func writeJSONNode(buf *bytes.Buffer, node *yaml.Node, indent string, depth int) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	pad := strings.Repeat(indent, depth)
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteString("{\n")
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyData, err := json.Marshal(node.Content[i].Value)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(pad + indent)
+			buf.Write(keyData)
+			buf.WriteString(": ")
+			if err := writeJSONNode(buf, node.Content[i+1], indent, depth+1); err != nil {
+				return err
+			}
+			if i+2 < len(node.Content) {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(pad + "}")
+	case yaml.SequenceNode:
+		if len(node.Content) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[\n")
+		for i, item := range node.Content {
+			buf.WriteString(pad + indent)
+			if err := writeJSONNode(buf, item, indent, depth+1); err != nil {
+				return err
+			}
+			if i+1 < len(node.Content) {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(pad + "]")
+	default:
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	return nil
+}
+
+// jsonIndent returns the indentation of the first indented line in data,
+// or two spaces.
+func jsonIndent(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return "  "
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, keeping the existing file's permissions.
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileAtomicPerm(path, data, 0644)
+}
+
+// writeFileAtomicPerm is writeFileAtomic with the permissions a new file is
+// created with.
+func writeFileAtomicPerm(path string, data []byte, perm os.FileMode) error {
+	mode := perm
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	// Sync the directory too, or the rename itself may not survive a crash.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
@@ -34,6 +34,11 @@ const (
 	HookPreExecute  HookType = "pre_execute"
 	HookPostExecute HookType = "post_execute"
 	HookShutdown    HookType = "shutdown"
+
+	// HookConfigValidate runs before a config value is set and for every
+	// value ValidateAll checks. Data holds "key", "value" and "source"; a
+	// handler returns an error to reject the value.
+	HookConfigValidate HookType = "config.validate"
 )
 
 type HookContext struct {
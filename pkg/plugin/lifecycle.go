@@ -171,6 +171,13 @@ func (lm *LifecycleManager) StopPlugin(ctx context.Context, pluginID string) err
 		return fmt.Errorf("failed to stop plugin %s: %w", pluginID, err)
 	}
 	info.State = StateStopped
+
+	if remover, ok := lm.registry.configProvider.(WatcherRemover); ok {
+		if removed := remover.RemoveWatchersForOwner(pluginID); removed > 0 {
+			lm.registry.logger.Debug("removed config watchers", "plugin", pluginID,
+				"count", removed)
+		}
+	}
 	lm.registry.logger.Info("plugin stopped", "plugin", pluginID)
 	return nil
 }
@@ -58,11 +58,46 @@ type ConfigProvider interface {
 	GetPluginConfig(pluginID string) (map[string]interface{}, error)
 }
 
+// WatcherRemover is implemented by config providers that let plugins register
+// change watchers; the lifecycle manager uses it to drop a plugin's watchers
+// when the plugin stops.
+type WatcherRemover interface {
+	RemoveWatchersForOwner(owner string) int
+}
+
+// ValidationHookRegistrar is implemented by config providers that accept
+// validation hooks; NewPluginRegistry registers ValidateConfig with them so
+// config.validate hooks see every value before it is set.
+type ValidationHookRegistrar interface {
+	AddValidationHook(hook func(key string, value interface{}, source string) error)
+}
+
+// HookError is returned by ExecuteHooks when a handler fails.
+type HookError struct {
+	Hook     HookType
+	PluginID string
+	Err      error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("hook %s from plugin %s failed %v", e.Hook, e.PluginID, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// HookPluginID names the plugin whose handler failed, so the config
+// manager can attribute a rejected value to it.
+func (e *HookError) HookPluginID() string {
+	return e.PluginID
+}
+
 // NewPluginRegistry creates a new plugin registry
 func NewPluginRegistry(
 	pluginDir string, logger Logger, configProvider ConfigProvider,
 ) *PluginRegistry {
-	return &PluginRegistry{
+	r := &PluginRegistry{
 		plugins:        make(map[string]*PluginInfo),
 		hooks:          make(map[HookType][]*HookRegistration),
 		capabilities:   make(map[string][]string),
@@ -70,6 +105,10 @@ func NewPluginRegistry(
 		logger:         logger,
 		configProvider: configProvider,
 	}
+	if registrar, ok := configProvider.(ValidationHookRegistrar); ok {
+		registrar.AddValidationHook(r.ValidateConfig)
+	}
+	return r
 }
 
 func (r *PluginRegistry) RegisterPlugin(plugin Plugin) error {
@@ -112,7 +151,7 @@ func (r *PluginRegistry) GetPlugin(pluginID string) (Plugin, error) {
 
 func (r *PluginRegistry) GetPluginInfo(pluginID string) (*PluginInfo, error) {
 	r.mu.RLock()
-	defer r.mu.Unlock()
+	defer r.mu.RUnlock()
 
 	info, exists := r.plugins[pluginID]
 	if !exists {
@@ -177,9 +216,19 @@ func (r *PluginRegistry) ExecuteHooks(ctx context.Context, hookType HookType,
 			r.logger.Error("Hook execution failed",
 				"plugin", registration.PluginID,
 				"hook", hookType, "error", err)
-			return fmt.Errorf("hook %s from plugin %s failed %w",
-				hookType, registration.PluginID, err)
+			return &HookError{Hook: hookType, PluginID: registration.PluginID, Err: err}
 		}
 	}
 	return nil
 }
+
+// ValidateConfig runs the config.validate hooks for a proposed value. It
+// has the signature ConfigManager.AddValidationHook takes, for providers
+// that don't implement ValidationHookRegistrar themselves.
+func (r *PluginRegistry) ValidateConfig(key string, value interface{}, source string) error {
+	return r.ExecuteHooks(context.Background(), HookConfigValidate, map[string]interface{}{
+		"key":    key,
+		"value":  value,
+		"source": source,
+	})
+}